@@ -4,6 +4,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -185,8 +186,22 @@ func addSingleDependency(g *pkggraph.PkgGraph, packageNode *pkggraph.PkgNode, de
 	}
 
 	err = g.AddEdge(packageNode, dependentNode)
+	if errors.Is(err, pkggraph.ErrDuplicateEdge) {
+		// Several requirements of the package may be satisfied by the same node, only the first one is recorded
+		logger.Log.Tracef("%+v already depends on %+v", packageNode, dependentNode)
+		return nil
+	}
 	if err != nil {
 		logger.Log.Errorf("Failed to add edge failed between %+v and %+v.", packageNode, dependency)
+		return err
+	}
+
+	// Record versioned requirements so the edge can be checked if the graph changes later on
+	if dependency.Version != "" || dependency.SVersion != "" {
+		err = g.SetEdgeConstraint(packageNode, dependentNode, dependency)
+		if err != nil {
+			logger.Log.Errorf("Failed to record the requirement %+v of %+v.", dependency, packageNode)
+		}
 	}
 
 	return err
@@ -0,0 +1,129 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// bazelRuleName is the rule each build node is emitted as by WriteBazelDeps. It is not a native Bazel rule,
+// consumers are expected to provide it (ie as a macro).
+const bazelRuleName = "rpm_package"
+
+// bazelInvalidNameChars matches the characters which are replaced when turning a package name into a target name.
+var bazelInvalidNameChars = regexp.MustCompile(`[^A-Za-z0-9_.+-]`)
+
+// WriteBazelDeps writes one Bazel-style target per build node, sorted by target name:
+//
+//	rpm_package(
+//	    name = "A",
+//	    srpm = "A.src.rpm",
+//	    deps = [
+//	        ":B",
+//	    ],
+//	    remote_deps = [
+//	        "D",
+//	    ],
+//	)
+//
+// Targets are named after the sanitized package name, with the version appended when several build nodes share a
+// name. deps lists the targets which build the packages the node requires, and remote_deps lists the sanitized
+// names of required packages which are not built locally.
+func (g *PkgGraph) WriteBazelDeps(output io.Writer) (err error) {
+	buildNodes := g.AllBuildNodes()
+	sortNodesByID(buildNodes)
+
+	nameCounts := make(map[string]int)
+	for _, n := range buildNodes {
+		nameCounts[bazelTargetName(n.VersionedPkg.Name)]++
+	}
+	targets := make(map[int64]string, len(buildNodes))
+	for _, n := range buildNodes {
+		target := bazelTargetName(n.VersionedPkg.Name)
+		if nameCounts[target] > 1 {
+			target = bazelTargetName(fmt.Sprintf("%s-%s", n.VersionedPkg.Name, n.VersionedPkg.Version))
+		}
+		targets[n.ID()] = target
+	}
+
+	sort.Slice(buildNodes, func(i, j int) bool {
+		return targets[buildNodes[i].ID()] < targets[buildNodes[j].ID()]
+	})
+
+	bazelWriter := bufio.NewWriter(output)
+	for i, n := range buildNodes {
+		var (
+			deps        []string
+			remoteDeps  []string
+			seenTargets = make(map[string]bool)
+		)
+
+		for _, dependency := range g.packageDependencies(n, make(map[int64]bool)) {
+			var lookupEntry *LookupNode
+			lookupEntry, err = g.FindExactPkgNodeFromPkg(dependency.VersionedPkg)
+			if err != nil {
+				return
+			}
+
+			var dependencyTarget string
+			isLocal := lookupEntry != nil && lookupEntry.BuildNode != nil
+			if isLocal {
+				dependencyTarget = ":" + targets[lookupEntry.BuildNode.ID()]
+			} else {
+				dependencyTarget = bazelTargetName(dependency.VersionedPkg.Name)
+			}
+
+			if seenTargets[dependencyTarget] || (isLocal && lookupEntry.BuildNode == n) {
+				continue
+			}
+			seenTargets[dependencyTarget] = true
+
+			if isLocal {
+				deps = append(deps, dependencyTarget)
+			} else {
+				remoteDeps = append(remoteDeps, dependencyTarget)
+			}
+		}
+		sort.Strings(deps)
+		sort.Strings(remoteDeps)
+
+		if i > 0 {
+			fmt.Fprintln(bazelWriter)
+		}
+		fmt.Fprintf(bazelWriter, "%s(\n", bazelRuleName)
+		fmt.Fprintf(bazelWriter, "    name = %s,\n", strconv.Quote(targets[n.ID()]))
+		fmt.Fprintf(bazelWriter, "    srpm = %s,\n", strconv.Quote(n.SRPMFileName()))
+		writeBazelList(bazelWriter, "deps", deps)
+		writeBazelList(bazelWriter, "remote_deps", remoteDeps)
+		fmt.Fprintln(bazelWriter, ")")
+	}
+
+	err = bazelWriter.Flush()
+
+	return
+}
+
+// bazelTargetName turns a package name into a valid Bazel target name.
+func bazelTargetName(name string) string {
+	return bazelInvalidNameChars.ReplaceAllString(name, "_")
+}
+
+// writeBazelList writes a list attribute of a Bazel target, one quoted value per line.
+func writeBazelList(output io.Writer, attribute string, values []string) {
+	if len(values) == 0 {
+		fmt.Fprintf(output, "    %s = [],\n", attribute)
+		return
+	}
+
+	fmt.Fprintf(output, "    %s = [\n", attribute)
+	for _, value := range values {
+		fmt.Fprintf(output, "        %s,\n", strconv.Quote(value))
+	}
+	fmt.Fprintln(output, "    ],")
+}
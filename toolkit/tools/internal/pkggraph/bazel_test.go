@@ -0,0 +1,47 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Make sure every build node becomes a target referencing the targets it depends on
+func TestWriteBazelDeps(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.NoError(t, addEdgeHelper(g, *pkgABuild, *pkgD1Unresolved))
+
+	var buf bytes.Buffer
+	assert.NoError(t, g.WriteBazelDeps(&buf))
+	output := buf.String()
+
+	assert.Equal(t, len(buildNodes), strings.Count(output, bazelRuleName+"("))
+	assert.Contains(t, output, `rpm_package(
+    name = "A",
+    srpm = "A.src.rpm",
+    deps = [
+        ":B",
+    ],
+    remote_deps = [
+        "D",
+    ],
+)`)
+	// C and C2 share a name, so their versions tell them apart
+	assert.Contains(t, output, `    name = "B",
+    srpm = "B.src.rpm",
+    deps = [
+        ":C-3-3",
+    ],
+    remote_deps = [],
+`)
+	assert.Contains(t, output, `name = "C-3-4"`)
+
+	assert.Equal(t, "libstdc++", bazelTargetName("libstdc++"))
+	assert.Equal(t, "perl_Foo__Bar_", bazelTargetName("perl(Foo::Bar)"))
+}
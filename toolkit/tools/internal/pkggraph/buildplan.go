@@ -0,0 +1,94 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+// buildPlan is the document written by BuildPlanJSON.
+type buildPlan struct {
+	BuildNodes []buildPlanEntry `json:"BuildNodes"`
+}
+
+// buildPlanEntry describes a single build node and the build nodes it transitively depends on.
+type buildPlanEntry struct {
+	ID                    int64   `json:"ID"`                    // ID of the build node
+	Name                  string  `json:"Name"`                  // Package name of the build node
+	Version               string  `json:"Version"`               // Version constraint of the build node
+	SrpmPath              string  `json:"SrpmPath"`              // SRPM built by the node
+	State                 string  `json:"State"`                 // Current state of the build node
+	Prerequisites         []int64 `json:"Prerequisites"`         // IDs of the build nodes which must be built first, sorted
+	RemainingDependencies int     `json:"RemainingDependencies"` // Number of prerequisites which are not available yet
+}
+
+// BuildPlanJSON writes a flat build plan for the graph as JSON. Every build node is listed, sorted by ID, along with
+// the IDs of its transitive build prerequisites: the other build nodes reachable from it through its dependencies.
+// A prerequisite is counted as remaining unless it is already available (StateUpToDate or StateCached).
+// Unlike WriteJSON the plan doesn't describe the graph itself and can't be read back.
+func (g *PkgGraph) BuildPlanJSON(output io.Writer) (err error) {
+	var plan buildPlan
+
+	nodes := g.AllBuildNodes()
+	sortNodesByID(nodes)
+	plan.BuildNodes = make([]buildPlanEntry, 0, len(nodes))
+	for _, n := range nodes {
+		entry := buildPlanEntry{
+			ID:            n.ID(),
+			Name:          nodeName(n),
+			Version:       nodeVersion(n),
+			SrpmPath:      n.SrpmPath,
+			State:         n.State.String(),
+			Prerequisites: []int64{},
+		}
+
+		for _, prerequisite := range g.buildPrerequisites(n) {
+			entry.Prerequisites = append(entry.Prerequisites, prerequisite.ID())
+			switch prerequisite.State {
+			case StateUpToDate, StateCached:
+			default:
+				entry.RemainingDependencies++
+			}
+		}
+		sort.Slice(entry.Prerequisites, func(i, j int) bool { return entry.Prerequisites[i] < entry.Prerequisites[j] })
+
+		plan.BuildNodes = append(plan.BuildNodes, entry)
+	}
+
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(plan)
+
+	return
+}
+
+// buildPrerequisites returns every build node, other than buildNode itself, which is reachable from buildNode.
+func (g *PkgGraph) buildPrerequisites(buildNode *PkgNode) (prerequisites []*PkgNode) {
+	visited := map[int64]bool{buildNode.ID(): true}
+	stack := []*PkgNode{buildNode}
+
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, dependency := range graph.NodesOf(g.From(current.ID())) {
+			dependencyNode := dependency.(*PkgNode).This
+			if visited[dependencyNode.ID()] {
+				continue
+			}
+			visited[dependencyNode.ID()] = true
+
+			if dependencyNode.Type == TypeBuild {
+				prerequisites = append(prerequisites, dependencyNode)
+			}
+			stack = append(stack, dependencyNode)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildPlanJSON(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	aBuild := findTestNode(t, g, pkgABuild)
+	bBuild := findTestNode(t, g, pkgBBuild)
+	cBuild := findTestNode(t, g, pkgCBuild)
+	c2Build := findTestNode(t, g, pkgC2Build)
+	cBuild.State = StateUpToDate
+
+	var buf bytes.Buffer
+	err = g.BuildPlanJSON(&buf)
+	assert.NoError(t, err)
+
+	var plan buildPlan
+	err = json.Unmarshal(buf.Bytes(), &plan)
+	assert.NoError(t, err)
+
+	expected := []*PkgNode{aBuild, bBuild, cBuild, c2Build}
+	sortNodesByID(expected)
+	assert.Len(t, plan.BuildNodes, len(expected))
+	for i, n := range expected {
+		assert.Equal(t, n.ID(), plan.BuildNodes[i].ID)
+		assert.Equal(t, n.VersionedPkg.Name, plan.BuildNodes[i].Name)
+		assert.Equal(t, n.SrpmPath, plan.BuildNodes[i].SrpmPath)
+	}
+
+	entries := make(map[int64]buildPlanEntry)
+	for _, entry := range plan.BuildNodes {
+		entries[entry.ID] = entry
+	}
+
+	prerequisites := []int64{bBuild.ID(), cBuild.ID()}
+	if prerequisites[0] > prerequisites[1] {
+		prerequisites[0], prerequisites[1] = prerequisites[1], prerequisites[0]
+	}
+	assert.Equal(t, prerequisites, entries[aBuild.ID()].Prerequisites)
+	assert.Equal(t, 1, entries[aBuild.ID()].RemainingDependencies)
+	assert.Equal(t, []int64{cBuild.ID()}, entries[bBuild.ID()].Prerequisites)
+	assert.Equal(t, 0, entries[bBuild.ID()].RemainingDependencies)
+	assert.Empty(t, entries[cBuild.ID()].Prerequisites)
+	assert.Equal(t, StateUpToDate.String(), entries[cBuild.ID()].State)
+	assert.Empty(t, entries[c2Build.ID()].Prerequisites)
+}
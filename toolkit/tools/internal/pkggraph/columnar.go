@@ -0,0 +1,342 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+// columnarFormatVersion is incremented whenever the layout of columnarGraph changes.
+const columnarFormatVersion = 2
+
+// columnarGraph stores a graph as parallel per-field arrays, one entry per node. All string fields are stored
+// as indices into a shared dictionary so repeated values (SRPM paths, repos, architectures) are only stored once.
+type columnarGraph struct {
+	FormatVersion int
+	Strings       []string
+
+	IDs           []int64
+	HasPkg        []bool
+	Packages      packageColumns
+	States        []NodeState
+	Types         []NodeType
+	SrpmPaths     []uint32
+	RpmPaths      []uint32
+	SpecPaths     []uint32
+	SourceDirs    []uint32
+	Architectures []uint32
+	SourceRepos   []uint32
+	GoalNames     []uint32
+	Implicit      []bool
+	PinnedIDs     []int64 // Pinned nodes are rare, so only their IDs are stored
+
+	EdgesFrom []int64
+	EdgesTo   []int64
+
+	// Constraints and weights are only recorded for some edges, so they are stored by edge index
+	ConstrainedEdges []int
+	Constraints      packageColumns
+	WeightedEdges    []int
+	Weights          []float64
+}
+
+// packageColumns stores a list of PackageVer structures as parallel per-field arrays of dictionary indices.
+type packageColumns struct {
+	Names       []uint32
+	Versions    []uint32
+	Conditions  []uint32
+	SVersions   []uint32
+	SConditions []uint32
+}
+
+// append adds pkgVer to the end of the columns, interning its fields in table.
+func (columns *packageColumns) append(table *stringTable, pkgVer *pkgjson.PackageVer) {
+	columns.Names = append(columns.Names, table.intern(pkgVer.Name))
+	columns.Versions = append(columns.Versions, table.intern(pkgVer.Version))
+	columns.Conditions = append(columns.Conditions, table.intern(pkgVer.Condition))
+	columns.SVersions = append(columns.SVersions, table.intern(pkgVer.SVersion))
+	columns.SConditions = append(columns.SConditions, table.intern(pkgVer.SCondition))
+}
+
+// lengths returns the length of every column.
+func (columns *packageColumns) lengths() []int {
+	return []int{len(columns.Names), len(columns.Versions), len(columns.Conditions), len(columns.SVersions), len(columns.SConditions)}
+}
+
+// pkgVer reconstructs a new PackageVer from index i of the columns, resolving its fields with str.
+func (columns *packageColumns) pkgVer(i int, str func(uint32) string) *pkgjson.PackageVer {
+	return &pkgjson.PackageVer{
+		Name:       str(columns.Names[i]),
+		Version:    str(columns.Versions[i]),
+		Condition:  str(columns.Conditions[i]),
+		SVersion:   str(columns.SVersions[i]),
+		SCondition: str(columns.SConditions[i]),
+	}
+}
+
+// stringTable interns strings, assigning each distinct value a stable index.
+type stringTable struct {
+	strings []string
+	index   map[string]uint32
+}
+
+// newStringTable creates an empty string table.
+func newStringTable() *stringTable {
+	return &stringTable{index: make(map[string]uint32)}
+}
+
+// intern returns the index of s in the table, adding it if needed.
+func (t *stringTable) intern(s string) uint32 {
+	if idx, found := t.index[s]; found {
+		return idx
+	}
+	idx := uint32(len(t.strings))
+	t.strings = append(t.strings, s)
+	t.index[s] = idx
+	return idx
+}
+
+// lookupString returns the string at index idx of a dictionary.
+func lookupString(dictionary []string, idx uint32) (s string, err error) {
+	if int(idx) >= len(dictionary) {
+		err = fmt.Errorf("string index %d out of range (dictionary has %d entries)", idx, len(dictionary))
+		return
+	}
+	return dictionary[idx], nil
+}
+
+// WriteGraphColumnar serializes a graph into a compact columnar format intended for bulk analytics and for storing
+// large graphs. Edge constraints and weights are kept along with the nodes and edges. Nodes are written in ID order
+// and edges in (from, to) ID order, so identical graphs produce identical output.
+func WriteGraphColumnar(g *PkgGraph, output io.Writer) (err error) {
+	var (
+		columns  columnarGraph
+		emptyPkg pkgjson.PackageVer
+	)
+	table := newStringTable()
+
+	nodes := g.AllNodes()
+	sortNodesByID(nodes)
+
+	for _, n := range nodes {
+		versionedPkg := n.VersionedPkg
+		columns.HasPkg = append(columns.HasPkg, versionedPkg != nil)
+		if versionedPkg == nil {
+			versionedPkg = &emptyPkg
+		}
+
+		columns.IDs = append(columns.IDs, n.ID())
+		columns.Packages.append(table, versionedPkg)
+		columns.States = append(columns.States, n.State)
+		columns.Types = append(columns.Types, n.Type)
+		columns.SrpmPaths = append(columns.SrpmPaths, table.intern(n.SrpmPath))
+		columns.RpmPaths = append(columns.RpmPaths, table.intern(n.RpmPath))
+		columns.SpecPaths = append(columns.SpecPaths, table.intern(n.SpecPath))
+		columns.SourceDirs = append(columns.SourceDirs, table.intern(n.SourceDir))
+		columns.Architectures = append(columns.Architectures, table.intern(n.Architecture))
+		columns.SourceRepos = append(columns.SourceRepos, table.intern(n.SourceRepo))
+		columns.GoalNames = append(columns.GoalNames, table.intern(n.GoalName))
+		columns.Implicit = append(columns.Implicit, n.Implicit)
+		if n.Pinned {
+			columns.PinnedIDs = append(columns.PinnedIDs, n.ID())
+		}
+	}
+
+	edges := graph.EdgesOf(g.Edges())
+	sortEdgesByID(edges)
+	for i, edge := range edges {
+		id := edgeID{from: edge.From().ID(), to: edge.To().ID()}
+		columns.EdgesFrom = append(columns.EdgesFrom, id.from)
+		columns.EdgesTo = append(columns.EdgesTo, id.to)
+
+		if constraint := g.edgeConstraints[id]; constraint != nil {
+			columns.ConstrainedEdges = append(columns.ConstrainedEdges, i)
+			columns.Constraints.append(table, constraint)
+		}
+		if weight, found := g.edgeWeights[id]; found {
+			columns.WeightedEdges = append(columns.WeightedEdges, i)
+			columns.Weights = append(columns.Weights, weight)
+		}
+	}
+
+	columns.FormatVersion = columnarFormatVersion
+	columns.Strings = table.strings
+
+	return gob.NewEncoder(output).Encode(&columns)
+}
+
+// ReadGraphColumnar de-serializes a graph written by WriteGraphColumnar. Node IDs, edge constraints and edge weights
+// are preserved, and every node gets its own copy of its VersionedPkg.
+func ReadGraphColumnar(input io.Reader) (g *PkgGraph, err error) {
+	var columns columnarGraph
+
+	err = gob.NewDecoder(input).Decode(&columns)
+	if err != nil {
+		return
+	}
+
+	if columns.FormatVersion != columnarFormatVersion {
+		err = fmt.Errorf("unsupported columnar graph format version %d", columns.FormatVersion)
+		return
+	}
+
+	nodeCount := len(columns.IDs)
+	for _, length := range append(columns.Packages.lengths(),
+		len(columns.HasPkg), len(columns.States), len(columns.Types), len(columns.SrpmPaths), len(columns.RpmPaths),
+		len(columns.SpecPaths), len(columns.SourceDirs), len(columns.Architectures), len(columns.SourceRepos),
+		len(columns.GoalNames), len(columns.Implicit),
+	) {
+		if length != nodeCount {
+			err = fmt.Errorf("malformed columnar graph, expected %d entries per column but found %d", nodeCount, length)
+			return
+		}
+	}
+	if len(columns.EdgesFrom) != len(columns.EdgesTo) {
+		err = fmt.Errorf("malformed columnar graph, edge columns have different lengths (%d vs %d)", len(columns.EdgesFrom), len(columns.EdgesTo))
+		return
+	}
+	for _, length := range columns.Constraints.lengths() {
+		if length != len(columns.ConstrainedEdges) {
+			err = fmt.Errorf("malformed columnar graph, expected %d constraints but found %d", len(columns.ConstrainedEdges), length)
+			return
+		}
+	}
+	if len(columns.Weights) != len(columns.WeightedEdges) {
+		err = fmt.Errorf("malformed columnar graph, expected %d weights but found %d", len(columns.WeightedEdges), len(columns.Weights))
+		return
+	}
+
+	// graph manipulation calls may panic on error (such as duplicate node IDs)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to rebuild columnar graph: %s", r)
+		}
+	}()
+
+	g = NewPkgGraph()
+	for i := 0; i < nodeCount; i++ {
+		var node *PkgNode
+		node, err = columns.node(i)
+		if err != nil {
+			return
+		}
+		g.AddNode(node)
+	}
+
+	for _, id := range columns.PinnedIDs {
+		pinnedNode := g.Node(id)
+		if pinnedNode == nil {
+			err = fmt.Errorf("malformed columnar graph, pinned node %d is missing", id)
+			return
+		}
+		pinnedNode.(*PkgNode).This.Pinned = true
+	}
+
+	for i := range columns.EdgesFrom {
+		from := g.Node(columns.EdgesFrom[i])
+		to := g.Node(columns.EdgesTo[i])
+		if from == nil || to == nil {
+			err = fmt.Errorf("malformed columnar graph, edge %d -> %d references a missing node", columns.EdgesFrom[i], columns.EdgesTo[i])
+			return
+		}
+		g.SetEdge(g.NewEdge(from, to))
+	}
+
+	for i, edgeIdx := range columns.ConstrainedEdges {
+		var from, to *PkgNode
+		from, to, err = columns.edge(g, edgeIdx)
+		if err != nil {
+			return
+		}
+		constraint := columns.Constraints.pkgVer(i, columns.str(&err))
+		if err != nil {
+			return
+		}
+		err = g.SetEdgeConstraint(from, to, constraint)
+		if err != nil {
+			return
+		}
+	}
+
+	for i, edgeIdx := range columns.WeightedEdges {
+		var from, to *PkgNode
+		from, to, err = columns.edge(g, edgeIdx)
+		if err != nil {
+			return
+		}
+		err = g.SetEdgeWeight(from, to, columns.Weights[i])
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// str returns a function resolving dictionary indices, which stores the first failure in err.
+func (columns *columnarGraph) str(err *error) func(uint32) string {
+	return func(idx uint32) string {
+		s, lookupErr := lookupString(columns.Strings, idx)
+		if lookupErr != nil && *err == nil {
+			*err = lookupErr
+		}
+		return s
+	}
+}
+
+// edge returns the endpoints in g of the edge stored at index i of the edge columns.
+func (columns *columnarGraph) edge(g *PkgGraph, i int) (from, to *PkgNode, err error) {
+	if i < 0 || i >= len(columns.EdgesFrom) {
+		err = fmt.Errorf("malformed columnar graph, edge index %d out of range (graph has %d edges)", i, len(columns.EdgesFrom))
+		return
+	}
+	from = g.Node(columns.EdgesFrom[i]).(*PkgNode)
+	to = g.Node(columns.EdgesTo[i]).(*PkgNode)
+	return
+}
+
+// node reconstructs the PkgNode stored at index i of the columns.
+func (columns *columnarGraph) node(i int) (node *PkgNode, err error) {
+	// Resolve dictionary indices, keeping only the first failure
+	str := columns.str(&err)
+
+	node = &PkgNode{
+		nodeID:       columns.IDs[i],
+		State:        columns.States[i],
+		Type:         columns.Types[i],
+		SrpmPath:     str(columns.SrpmPaths[i]),
+		RpmPath:      str(columns.RpmPaths[i]),
+		SpecPath:     str(columns.SpecPaths[i]),
+		SourceDir:    str(columns.SourceDirs[i]),
+		Architecture: str(columns.Architectures[i]),
+		SourceRepo:   str(columns.SourceRepos[i]),
+		GoalName:     str(columns.GoalNames[i]),
+		Implicit:     columns.Implicit[i],
+	}
+	if columns.HasPkg[i] {
+		node.VersionedPkg = columns.Packages.pkgVer(i, str)
+	}
+	node.This = node
+
+	return
+}
+
+// WriteCompact serializes the graph for storage, keeping every node, edge, edge constraint and edge weight. Repeated
+// strings are only stored once, which keeps the output far smaller than the DOT format. It is the same format as
+// WriteGraphColumnar.
+func (g *PkgGraph) WriteCompact(output io.Writer) (err error) {
+	return WriteGraphColumnar(g, output)
+}
+
+// ReadCompact de-serializes a graph written by WriteCompact. Node IDs are preserved, and the lookup table is rebuilt
+// from the nodes the first time it is needed, the same way it is for graphs read from DOT.
+func ReadCompact(input io.Reader) (g *PkgGraph, err error) {
+	return ReadGraphColumnar(input)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test encoding and decoding a columnar formatted graph
+func TestEncodeDecodeColumnar(t *testing.T) {
+	gOut, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = WriteGraphColumnar(gOut, &buf)
+	assert.NoError(t, err)
+
+	gIn, err := ReadGraphColumnar(&buf)
+	assert.NoError(t, err)
+
+	checkTestGraph(t, gIn)
+
+	// IDs and node contents must survive the round trip
+	for _, n := range gOut.AllNodes() {
+		inNode := gIn.Node(n.ID())
+		assert.NotNil(t, inNode)
+		assert.True(t, n.Equal(inNode.(*PkgNode)))
+	}
+}
+
+// Make sure edge constraints and weights survive the round trip
+func TestEncodeDecodeColumnarEdgeData(t *testing.T) {
+	gOut, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	aRun := findTestNode(t, gOut, pkgARun)
+	aBuild := findTestNode(t, gOut, pkgABuild)
+	d1 := findTestNode(t, gOut, pkgD1Unresolved)
+	constraint := &pkgjson.PackageVer{Name: "D", Condition: "<", Version: "1"}
+	assert.NoError(t, gOut.SetEdgeConstraint(aRun, d1, constraint))
+	assert.NoError(t, gOut.SetEdgeWeight(aRun, aBuild, 2.5))
+
+	var buf bytes.Buffer
+	err = WriteGraphColumnar(gOut, &buf)
+	assert.NoError(t, err)
+
+	gIn, err := ReadGraphColumnar(&buf)
+	assert.NoError(t, err)
+
+	checkTestGraph(t, gIn)
+	assert.Equal(t, constraint, gIn.EdgeConstraint(aRun, d1))
+	assert.Nil(t, gIn.EdgeConstraint(aRun, aBuild))
+	assert.Equal(t, 2.5, gIn.EdgeWeight(aRun, aBuild))
+	assert.Equal(t, defaultEdgeWeight, gIn.EdgeWeight(aRun, d1))
+
+	// Nodes with the same package still get their own copy of it
+	aRunIn := findTestNode(t, gIn, pkgARun)
+	aBuildIn := findTestNode(t, gIn, pkgABuild)
+	assert.Equal(t, *aRunIn.VersionedPkg, *aBuildIn.VersionedPkg)
+	assert.False(t, aRunIn.VersionedPkg == aBuildIn.VersionedPkg)
+}
+
+// Make sure nodes without a VersionedPkg keep it nil
+func TestEncodeDecodeColumnarGoalNode(t *testing.T) {
+	gOut, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	goal, err := gOut.AddGoalNode("test", nil, false)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = WriteGraphColumnar(gOut, &buf)
+	assert.NoError(t, err)
+
+	gIn, err := ReadGraphColumnar(&buf)
+	assert.NoError(t, err)
+
+	goalIn := gIn.FindGoalNode("test")
+	assert.NotNil(t, goalIn)
+	assert.Nil(t, goalIn.VersionedPkg)
+	assert.True(t, goal.Equal(goalIn))
+	assert.Equal(t, gOut.From(goal.ID()).Len(), gIn.From(goalIn.ID()).Len())
+}
+
+// Make sure the columnar format is smaller than the DOT format and is deterministic
+func TestColumnarSizeAndDeterminism(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	var dotBuf, columnarBuf1, columnarBuf2 bytes.Buffer
+	assert.NoError(t, WriteDOTGraph(g, &dotBuf))
+	assert.NoError(t, WriteGraphColumnar(g, &columnarBuf1))
+	assert.NoError(t, WriteGraphColumnar(g, &columnarBuf2))
+
+	assert.Less(t, columnarBuf1.Len(), dotBuf.Len())
+	assert.Equal(t, columnarBuf1.Bytes(), columnarBuf2.Bytes())
+}
+
+func TestReadColumnarInvalid(t *testing.T) {
+	_, err := ReadGraphColumnar(bytes.NewBufferString("not a graph"))
+	assert.Error(t, err)
+}
+
+// Make sure a compact graph reads back Equal to the original one
+func TestEncodeDecodeCompact(t *testing.T) {
+	gOut, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	_, err = gOut.AddGoalNode("test", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+	aRun := findTestNode(t, gOut, pkgARun)
+	d1 := findTestNode(t, gOut, pkgD1Unresolved)
+	constraint := &pkgjson.PackageVer{Name: "D", Condition: "<", Version: "1"}
+	assert.NoError(t, gOut.SetEdgeConstraint(aRun, d1, constraint))
+
+	var buf bytes.Buffer
+	assert.NoError(t, gOut.WriteCompact(&buf))
+	gIn, err := ReadCompact(&buf)
+	assert.NoError(t, err)
+
+	assert.True(t, gOut.Equal(gIn))
+	assert.Equal(t, constraint, gIn.EdgeConstraint(aRun, d1))
+}
@@ -0,0 +1,115 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/graph"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+)
+
+// edgesCSVHeader is the header row written by WriteEdgesCSV.
+var edgesCSVHeader = []string{"from_name", "from_version", "from_type", "to_name", "to_version", "to_type", "edge_kind"}
+
+// WriteEdgesCSV writes every edge of the graph as a CSV row of
+// (from_name, from_version, from_type, to_name, to_version, to_type, edge_kind), preceded by a header.
+// Rows are sorted by the NodesCanonical order of the edge's nodes, so identical graphs produce identical output.
+func (g *PkgGraph) WriteEdgesCSV(output io.Writer) (err error) {
+	var rows [][]string
+
+	for _, edge := range g.edgesCanonical() {
+		from := edge.From().(*PkgNode).This
+		to := edge.To().(*PkgNode).This
+		rows = append(rows, []string{
+			nodeName(from), nodeVersion(from), from.Type.String(),
+			nodeName(to), nodeVersion(to), to.Type.String(),
+			EdgeKindOf(from, to).String(),
+		})
+	}
+
+	writer := csv.NewWriter(output)
+	err = writer.Write(edgesCSVHeader)
+	if err != nil {
+		return
+	}
+	err = writer.WriteAll(rows)
+
+	return
+}
+
+// edgeListCSVHeader is the header row written by WriteEdgeListCSV.
+var edgeListCSVHeader = []string{"from_name", "from_id", "from_type", "to_name", "to_id", "to_type"}
+
+// WriteEdgeListCSV writes every edge of the graph as a CSV row of (from_name, from_id, from_type, to_name, to_id,
+// to_type), preceded by a header. Unlike WriteEdgesCSV nodes are identified by their IDs, so rows can be joined
+// against other exports of the same graph. Rows are sorted by the IDs of the edge's nodes.
+func (g *PkgGraph) WriteEdgeListCSV(output io.Writer) (err error) {
+	edges := graph.EdgesOf(g.Edges())
+	sortEdgesByID(edges)
+
+	writer := csv.NewWriter(output)
+	err = writer.Write(edgeListCSVHeader)
+	if err != nil {
+		return
+	}
+
+	for _, edge := range edges {
+		from := edge.From().(*PkgNode).This
+		to := edge.To().(*PkgNode).This
+		err = writer.Write([]string{
+			nodeName(from), strconv.FormatInt(from.ID(), 10), from.Type.String(),
+			nodeName(to), strconv.FormatInt(to.ID(), 10), to.Type.String(),
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	writer.Flush()
+	err = writer.Error()
+
+	return
+}
+
+// nodeName returns the package name of a node, the goal name for goal nodes, or the friendly name for
+// nodes without package information.
+func nodeName(n *PkgNode) string {
+	switch {
+	case n.Type == TypeGoal:
+		return n.GoalName
+	case n.VersionedPkg != nil:
+		return n.VersionedPkg.Name
+	default:
+		return n.FriendlyName()
+	}
+}
+
+// nodeVersion returns the version constraint of a node as a string (ie ">=1.0", or ">1,<2" for double conditionals).
+// Returns an empty string for nodes without package information.
+func nodeVersion(n *PkgNode) string {
+	if n.VersionedPkg == nil {
+		return ""
+	}
+	return versionConstraintString(n.VersionedPkg)
+}
+
+// versionConstraintString formats the conditions and versions of a PackageVer.
+func versionConstraintString(pkgVer *pkgjson.PackageVer) string {
+	var builder strings.Builder
+
+	builder.WriteString(pkgVer.Condition)
+	builder.WriteString(pkgVer.Version)
+	if pkgVer.SCondition != "" || pkgVer.SVersion != "" {
+		builder.WriteString(",")
+		builder.WriteString(pkgVer.SCondition)
+		builder.WriteString(pkgVer.SVersion)
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Make sure every edge is written with its kind, in a stable order
+func TestWriteEdgesCSV(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("test", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+
+	var buf1, buf2 bytes.Buffer
+	assert.NoError(t, g.WriteEdgesCSV(&buf1))
+	assert.NoError(t, g.WriteEdgesCSV(&buf2))
+	assert.Equal(t, buf1.String(), buf2.String())
+
+	rows, err := csv.NewReader(&buf1).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, edgesCSVHeader, rows[0])
+	assert.Equal(t, len(edges)+1, len(rows[1:]))
+
+	assert.Contains(t, rows, []string{"A", "1", "Build", "B", "2", "Run", "BuildRequires"})
+	assert.Contains(t, rows, []string{"A", "1", "Run", "A", "1", "Build", "BuiltBy"})
+	assert.Contains(t, rows, []string{"C", "3-4", "Run", "D", ">6,<7", "Remote", "Requires"})
+	assert.Contains(t, rows, []string{"test", "", "Goal", "A", "1", "Run", "Goal"})
+
+	// Rows follow the canonical order of the nodes: A's build node sorts first, the goal last
+	assert.Equal(t, []string{"A", "1", "Build", "B", "2", "Run", "BuildRequires"}, rows[1])
+	assert.Equal(t, "test", rows[len(rows)-1][0])
+}
+
+// Make sure every edge is written with the IDs of its nodes, sorted by ID
+func TestWriteEdgeListCSV(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	goalNode, err := g.AddGoalNode("test", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, g.WriteEdgeListCSV(&buf))
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, edgeListCSVHeader, rows[0])
+	assert.Equal(t, len(edges)+1, len(rows[1:]))
+
+	aRun := findTestNode(t, g, pkgARun)
+	aBuild := findTestNode(t, g, pkgABuild)
+	assert.Contains(t, rows, []string{"A", fmt.Sprint(aRun.ID()), "Run", "A", fmt.Sprint(aBuild.ID()), "Build"})
+	// The goal node was added last, so its edge comes last
+	assert.Equal(t, []string{"test", fmt.Sprint(goalNode.ID()), "Goal", "A", fmt.Sprint(aRun.ID()), "Run"}, rows[len(rows)-1])
+
+	for i := 2; i < len(rows); i++ {
+		previous, _ := strconv.ParseInt(rows[i-1][1], 10, 64)
+		current, _ := strconv.ParseInt(rows[i][1], 10, 64)
+		assert.LessOrEqual(t, previous, current)
+	}
+}
+
+// Check the edge kinds derived from node types
+func TestEdgeKindOf(t *testing.T) {
+	goal := &PkgNode{Type: TypeGoal}
+	meta := &PkgNode{Type: TypePureMeta}
+
+	assert.Equal(t, EdgeBuildRequires, EdgeKindOf(pkgABuild, pkgBRun))
+	assert.Equal(t, EdgeBuiltBy, EdgeKindOf(pkgARun, pkgABuild))
+	assert.Equal(t, EdgeRequires, EdgeKindOf(pkgARun, pkgD1Unresolved))
+	assert.Equal(t, EdgeGoal, EdgeKindOf(goal, pkgARun))
+	assert.Equal(t, EdgeMeta, EdgeKindOf(meta, pkgARun))
+	assert.Equal(t, EdgeMeta, EdgeKindOf(pkgARun, meta))
+
+	for k := EdgeUnknown; k <= EdgeKindMAX; k++ {
+		assert.NotEmpty(t, k.String())
+	}
+}
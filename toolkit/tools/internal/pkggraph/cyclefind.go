@@ -5,10 +5,12 @@ package pkggraph
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/logger"
 
 	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/topo"
 )
 
 const (
@@ -64,6 +66,130 @@ func (g *PkgGraph) FindAnyDirectedCycle() (nodes []*PkgNode, err error) {
 	return
 }
 
+// FindAllDirectedCycles returns every elementary cycle in the graph. Each cycle has the same shape as those
+// returned by FindAnyDirectedCycle: the first node is repeated as the last one, and each node depends on the
+// node before it. Cycles are rotated to start at their lowest node ID and sorted by length, then by node IDs, so
+// the result is stable for a given graph.
+// The number of elementary cycles can grow exponentially with the size of the graph, this is meant for reporting
+// rather than for the regular MakeDAG flow.
+func (g *PkgGraph) FindAllDirectedCycles() (cycles [][]*PkgNode, err error) {
+	for _, gonumCycle := range topo.DirectedCyclesIn(g) {
+		// gonum repeats the first node as the last one, drop it while the cycle is being reordered.
+		members := gonumCycle[:len(gonumCycle)-1]
+
+		start := 0
+		for i, member := range members {
+			if member.ID() < members[start].ID() {
+				start = i
+			}
+		}
+
+		// gonum lists nodes in edge order (each node depends on the next one), walk backwards to match cycleDFS.
+		cycle := make([]*PkgNode, 0, len(gonumCycle))
+		for i := 0; i < len(members); i++ {
+			member := members[(start-i+len(members))%len(members)]
+			pkgNode, ok := member.(*PkgNode)
+			if !ok {
+				err = fmt.Errorf("node %d is not a package node", member.ID())
+				return nil, err
+			}
+			cycle = append(cycle, pkgNode.This)
+		}
+		cycle = append(cycle, cycle[0])
+
+		cycles = append(cycles, cycle)
+	}
+
+	sort.Slice(cycles, func(i, j int) bool {
+		if len(cycles[i]) != len(cycles[j]) {
+			return len(cycles[i]) < len(cycles[j])
+		}
+		for k := range cycles[i] {
+			if cycles[i][k].ID() != cycles[j][k].ID() {
+				return cycles[i][k].ID() < cycles[j][k].ID()
+			}
+		}
+		return false
+	})
+
+	return
+}
+
+// AnalyzeCycles reports whether MakeDAG could fix each cycle in the graph, without modifying it. Every cycle found
+// by FindAllDirectedCycles is tried against the default cycle resolvers (see DefaultCycleResolvers) on its own copy
+// of the graph, and is returned in fixable if one of them fixed it or in unfixable otherwise. Cycles are made of
+// the original graph's nodes and keep the order of FindAllDirectedCycles.
+// Since each cycle is handled on its own, fixing one cycle is not assumed to fix or break the others.
+func (g *PkgGraph) AnalyzeCycles() (fixable, unfixable [][]*PkgNode, err error) {
+	cycles, err := g.FindAllDirectedCycles()
+	if err != nil {
+		return
+	}
+
+	resolvers := DefaultCycleResolvers()
+	for _, cycle := range cycles {
+		var analysisGraph *PkgGraph
+		analysisGraph, err = g.copyGraphPreservingIDs()
+		if err != nil {
+			return
+		}
+
+		copiedCycle := make([]*PkgNode, 0, len(cycle))
+		for _, n := range cycle {
+			copiedCycle = append(copiedCycle, analysisGraph.Node(n.ID()).(*PkgNode).This)
+		}
+
+		_, resolveErr := analysisGraph.resolveCycle(copiedCycle, resolvers)
+		if resolveErr == nil {
+			fixable = append(fixable, cycle)
+		} else {
+			logger.Log.Debugf("Cycle %s can't be fixed: %s", cycleString(cycle), resolveErr)
+			unfixable = append(unfixable, cycle)
+		}
+	}
+
+	return
+}
+
+// BuildCyclesReport returns every cycle in the graph which goes through at least one build node, in the order of
+// FindAllDirectedCycles. The cycle resolvers never break a cycle through a build node, so each of these has to be
+// fixed by changing the specs involved. Every cycle is logged along with the SRPMs taking part in it (see
+// CycleSRPMPaths) so they can be refactored. Returns nil if the cycles can't be enumerated.
+func (g *PkgGraph) BuildCyclesReport() (buildCycles [][]*PkgNode) {
+	cycles, err := g.FindAllDirectedCycles()
+	if err != nil {
+		logger.Log.Warnf("Unable to enumerate cycles: %s", err)
+		return
+	}
+
+	for _, cycle := range cycles {
+		for _, n := range cycle {
+			if n.Type == TypeBuild {
+				buildCycles = append(buildCycles, cycle)
+				logger.Log.Infof("Build cycle %s involves SRPMs: %v", cycleString(cycle), CycleSRPMPaths(cycle))
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// CycleSRPMPaths returns the distinct SRPM paths of the local run and build nodes in a cycle, sorted.
+func CycleSRPMPaths(cycle []*PkgNode) (srpmPaths []string) {
+	seen := make(map[string]bool)
+	for _, n := range cycle {
+		if (n.Type != TypeRun && n.Type != TypeBuild) || n.SrpmPath == "" || seen[n.SrpmPath] {
+			continue
+		}
+		seen[n.SrpmPath] = true
+		srpmPaths = append(srpmPaths, n.SrpmPath)
+	}
+	sort.Strings(srpmPaths)
+
+	return
+}
+
 // cycleDFS implements a custom DFS that updates metaData.cycle with the first cycle it finds in a given graph.
 func cycleDFS(g *PkgGraph, rootID int64, metaData *dfsData) (foundCycle bool, err error) {
 	// Recursing on a node that has already been visited indicates a fatal error with the search.
@@ -39,3 +39,96 @@ func TestDFSNoCycle(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Nil(t, cycle)
 }
+
+func TestFindAllDirectedCycles(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.NotNil(t, g)
+
+	// Create two cycles sharing pkgARun
+	addEdgeHelper(g, *pkgCBuild, *pkgARun)
+	addEdgeHelper(g, *pkgABuild, *pkgARun)
+
+	cycles, err := g.FindAllDirectedCycles()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(cycles))
+	assert.Equal(t, 3, len(cycles[0]))
+	assert.Equal(t, 7, len(cycles[1]))
+
+	for _, cycle := range cycles {
+		assert.Equal(t, cycle[0], cycle[len(cycle)-1])
+		for i := 1; i < len(cycle); i++ {
+			// Each node depends on the one before it, matching FindAnyDirectedCycle
+			assert.True(t, g.HasEdgeFromTo(cycle[i].ID(), cycle[i-1].ID()))
+			if i < len(cycle)-1 {
+				assert.Less(t, cycle[0].ID(), cycle[i].ID())
+			}
+		}
+	}
+
+	again, err := g.FindAllDirectedCycles()
+	assert.NoError(t, err)
+	assert.Equal(t, cycles, again)
+}
+
+func TestFindAllDirectedCyclesNoCycle(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.NotNil(t, g)
+
+	cycles, err := g.FindAllDirectedCycles()
+	assert.NoError(t, err)
+	assert.Empty(t, cycles)
+}
+
+func TestAnalyzeCycles(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// C run <-> C2 run only has run nodes from one spec and can be fixed, the cycle through the builds of A, B,
+	// and C can't
+	assert.NoError(t, addEdgeHelper(g, *pkgCBuild, *pkgARun))
+	assert.NoError(t, addEdgeHelper(g, *pkgCRun, *pkgC2Run))
+	assert.NoError(t, addEdgeHelper(g, *pkgC2Run, *pkgCRun))
+	nodeCount := g.Nodes().Len()
+	edgeCount := g.Edges().Len()
+
+	cycles, err := g.FindAllDirectedCycles()
+	assert.NoError(t, err)
+
+	fixable, unfixable, err := g.AnalyzeCycles()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]*PkgNode{cycles[0]}, fixable)
+	assert.Equal(t, [][]*PkgNode{cycles[1]}, unfixable)
+
+	// The graph is left untouched
+	assert.Equal(t, nodeCount, g.Nodes().Len())
+	assert.Equal(t, edgeCount, g.Edges().Len())
+	assert.True(t, g.HasEdgeFromTo(findTestNode(t, g, pkgC2Run).ID(), findTestNode(t, g, pkgCRun).ID()))
+
+	g, err = buildTestGraphHelper()
+	assert.NoError(t, err)
+	fixable, unfixable, err = g.AnalyzeCycles()
+	assert.NoError(t, err)
+	assert.Empty(t, fixable)
+	assert.Empty(t, unfixable)
+}
+
+func TestBuildCyclesReport(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.Empty(t, g.BuildCyclesReport())
+
+	// Only the cycle through the builds of A, B, and C is reported
+	assert.NoError(t, addEdgeHelper(g, *pkgCBuild, *pkgARun))
+	assert.NoError(t, addEdgeHelper(g, *pkgCRun, *pkgC2Run))
+	assert.NoError(t, addEdgeHelper(g, *pkgC2Run, *pkgCRun))
+
+	cycles, err := g.FindAllDirectedCycles()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(cycles))
+
+	buildCycles := g.BuildCyclesReport()
+	assert.Equal(t, [][]*PkgNode{cycles[1]}, buildCycles)
+	assert.Equal(t, []string{pkgARun.SrpmPath, pkgBRun.SrpmPath, pkgCRun.SrpmPath}, CycleSRPMPaths(buildCycles[0]))
+}
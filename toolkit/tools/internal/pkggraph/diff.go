@@ -0,0 +1,135 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"sort"
+)
+
+// GraphDiff describes how a graph differs from an older version of itself, see Diff.
+type GraphDiff struct {
+	Added        []*PkgNode   // Nodes only found in the new graph
+	Removed      []*PkgNode   // Nodes only found in the old graph
+	Changed      []NodeChange // Nodes found in both graphs, but with a different type, state, or different paths
+	AddedEdges   []EdgeNames  // Edges only found in the new graph
+	RemovedEdges []EdgeNames  // Edges only found in the old graph
+}
+
+// NodeChange pairs the old and new versions of a node which changed between two graphs.
+type NodeChange struct {
+	Old *PkgNode
+	New *PkgNode
+}
+
+// EdgeNames identifies an edge by the friendly names of its nodes.
+type EdgeNames struct {
+	From string
+	To   string
+}
+
+// Diff compares two graphs. Nodes are matched by content (name and version, see nodeContentKeys) rather than by ID.
+// A matched node is reported as changed if its type, state, architecture, source repo, or any of its paths differ.
+// Every list is sorted by content key so the result is stable for a given pair of graphs.
+func Diff(oldGraph, newGraph *PkgGraph) (diff GraphDiff) {
+	oldNodes := nodeContentKeys(oldGraph)
+	newNodes := nodeContentKeys(newGraph)
+
+	for _, key := range sortedContentKeys(oldNodes) {
+		oldNode := oldNodes[key]
+		newNode, found := newNodes[key]
+		switch {
+		case !found:
+			diff.Removed = append(diff.Removed, oldNode)
+		case nodeContentChanged(oldNode, newNode):
+			diff.Changed = append(diff.Changed, NodeChange{Old: oldNode, New: newNode})
+		}
+	}
+	for _, key := range sortedContentKeys(newNodes) {
+		if _, found := oldNodes[key]; !found {
+			diff.Added = append(diff.Added, newNodes[key])
+		}
+	}
+
+	oldEdges := edgeContentKeys(oldGraph, oldNodes)
+	newEdges := edgeContentKeys(newGraph, newNodes)
+	for _, edge := range sortedEdgeContentKeys(oldEdges) {
+		if !newEdges[edge] {
+			diff.RemovedEdges = append(diff.RemovedEdges, EdgeNames{From: oldNodes[edge[0]].FriendlyName(), To: oldNodes[edge[1]].FriendlyName()})
+		}
+	}
+	for _, edge := range sortedEdgeContentKeys(newEdges) {
+		if !oldEdges[edge] {
+			diff.AddedEdges = append(diff.AddedEdges, EdgeNames{From: newNodes[edge[0]].FriendlyName(), To: newNodes[edge[1]].FriendlyName()})
+		}
+	}
+
+	return
+}
+
+// Equal returns true if both graphs hold the same nodes (see PkgNode.Equal) connected by the same edges. Nodes are
+// matched by content as in Diff, so the graphs may number their nodes differently. Recorded edge constraints and
+// weights are not compared.
+func (g *PkgGraph) Equal(other *PkgGraph) bool {
+	if g == other {
+		return true
+	}
+	if g == nil || other == nil || g.Nodes().Len() != other.Nodes().Len() || g.Edges().Len() != other.Edges().Len() {
+		return false
+	}
+
+	nodes := nodeContentKeys(g)
+	otherNodes := nodeContentKeys(other)
+	for key, n := range nodes {
+		otherNode, found := otherNodes[key]
+		if !found || !n.Equal(otherNode) {
+			return false
+		}
+	}
+
+	otherEdges := edgeContentKeys(other, otherNodes)
+	for edge := range edgeContentKeys(g, nodes) {
+		if !otherEdges[edge] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// nodeContentChanged checks if two nodes sharing a content key differ in any of the fields Diff reports.
+func nodeContentChanged(oldNode, newNode *PkgNode) bool {
+	return oldNode.Type != newNode.Type ||
+		oldNode.State != newNode.State ||
+		oldNode.SrpmPath != newNode.SrpmPath ||
+		oldNode.RpmPath != newNode.RpmPath ||
+		oldNode.SpecPath != newNode.SpecPath ||
+		oldNode.SourceDir != newNode.SourceDir ||
+		oldNode.Architecture != newNode.Architecture ||
+		oldNode.SourceRepo != newNode.SourceRepo
+}
+
+// sortedContentKeys returns the keys of a map built by nodeContentKeys, sorted.
+func sortedContentKeys(nodeKeys map[string]*PkgNode) (keys []string) {
+	keys = make([]string, 0, len(nodeKeys))
+	for key := range nodeKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// sortedEdgeContentKeys returns the edges of a set built by edgeContentKeys, sorted.
+func sortedEdgeContentKeys(edgeKeys map[[2]string]bool) (edges [][2]string) {
+	edges = make([][2]string, 0, len(edgeKeys))
+	for edge := range edgeKeys {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i][0] != edges[j][0] {
+			return edges[i][0] < edges[j][0]
+		}
+		return edges[i][1] < edges[j][1]
+	})
+	return
+}
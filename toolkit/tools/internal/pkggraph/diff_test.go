@@ -0,0 +1,91 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffIdentical(t *testing.T) {
+	oldGraph, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	newGraph, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	assert.Equal(t, GraphDiff{}, Diff(oldGraph, newGraph))
+}
+
+func TestDiff(t *testing.T) {
+	oldGraph, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	newGraph, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Drop C2 run's dependency on D4, and the D4 node itself
+	newGraph.RemovePkgNode(findTestNode(t, newGraph, pkgD4Unresolved))
+	// Mark C as built
+	newCBuild := findTestNode(t, newGraph, pkgCBuild)
+	newCBuild.State = StateUpToDate
+	// D5 is now provided locally, a type change is reported as a change rather than a removal and an addition
+	newD5 := findTestNode(t, newGraph, pkgD5Unresolved)
+	newD5.Type = TypeRun
+	// Add E, required by A's build
+	eRun, err := newGraph.AddPkgNode(&pkgjson.PackageVer{Name: "E", Version: "1"}, StateMeta, TypeRun, "E.src.rpm", "E.rpm", "E.spec", "", "test_arch", "")
+	assert.NoError(t, err)
+	newABuild := findTestNode(t, newGraph, pkgABuild)
+	assert.NoError(t, newGraph.AddEdge(newABuild, eRun))
+
+	diff := Diff(oldGraph, newGraph)
+
+	oldD4 := findTestNode(t, oldGraph, pkgD4Unresolved)
+	assert.Equal(t, []*PkgNode{eRun}, diff.Added)
+	assert.Equal(t, []*PkgNode{oldD4}, diff.Removed)
+	assert.Equal(t, []NodeChange{
+		{Old: findTestNode(t, oldGraph, pkgCBuild), New: newCBuild},
+		{Old: findTestNode(t, oldGraph, pkgD5Unresolved), New: newD5},
+	}, diff.Changed)
+	assert.Equal(t, []EdgeNames{{From: newABuild.FriendlyName(), To: eRun.FriendlyName()}}, diff.AddedEdges)
+	assert.Equal(t, []EdgeNames{{From: findTestNode(t, oldGraph, pkgC2Run).FriendlyName(), To: oldD4.FriendlyName()}}, diff.RemovedEdges)
+}
+
+func TestGraphEqual(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.True(t, g.Equal(g))
+
+	// Build the same graph in a different order, so every node gets a different ID
+	var reordered []*PkgNode
+	for _, group := range [][]*PkgNode{unresolvedNodes, runNodes, buildNodes} {
+		for i := len(group) - 1; i >= 0; i-- {
+			reordered = append(reordered, group[i])
+		}
+	}
+	other := NewPkgGraph()
+	assert.NoError(t, addNodesHelper(other, reordered))
+	for i := len(edges) - 1; i >= 0; i-- {
+		assert.NoError(t, addEdgeHelper(other, *edges[i][0], *edges[i][1]))
+	}
+	assert.NotEqual(t, findTestNode(t, g, pkgARun).ID(), findTestNode(t, other, pkgARun).ID())
+	assert.True(t, g.Equal(other))
+	assert.True(t, other.Equal(g))
+
+	findTestNode(t, other, pkgCBuild).State = StateUpToDate
+	assert.False(t, g.Equal(other))
+	findTestNode(t, other, pkgCBuild).State = pkgCBuild.State
+	assert.True(t, g.Equal(other))
+
+	// Same number of edges, but one of them is different
+	c2Run := findTestNode(t, other, pkgC2Run)
+	other.RemoveEdge(c2Run.ID(), findTestNode(t, other, pkgD4Unresolved).ID())
+	assert.NoError(t, other.AddEdge(c2Run, findTestNode(t, other, pkgD1Unresolved)))
+	assert.Equal(t, g.Edges().Len(), other.Edges().Len())
+	assert.False(t, g.Equal(other))
+
+	assert.False(t, g.Equal(NewPkgGraph()))
+	assert.False(t, g.Equal(nil))
+}
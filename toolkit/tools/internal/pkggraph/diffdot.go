@@ -0,0 +1,152 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+// Colors used by WriteDiffDOT
+const (
+	diffColorAdded     = "green"
+	diffColorRemoved   = "red"
+	diffColorChanged   = "yellow"
+	diffColorUnchanged = "black"
+)
+
+// WriteDiffDOT writes a single DOT digraph showing how newGraph differs from oldGraph. Nodes are matched by content
+// (name and version, see nodeContentKeys) rather than by ID. Nodes and edges which only exist in newGraph are
+// green, those which only exist in oldGraph are red, and nodes present in both graphs but with a different type or
+// state are yellow. The output is meant for visualization and can't be read back with ReadDOTGraph.
+func WriteDiffDOT(oldGraph, newGraph *PkgGraph, output io.Writer) (err error) {
+	oldNodes := nodeContentKeys(oldGraph)
+	newNodes := nodeContentKeys(newGraph)
+	oldEdges := edgeContentKeys(oldGraph, oldNodes)
+	newEdges := edgeContentKeys(newGraph, newNodes)
+
+	keys := make([]string, 0, len(oldNodes)+len(newNodes))
+	for key := range oldNodes {
+		keys = append(keys, key)
+	}
+	for key := range newNodes {
+		if _, found := oldNodes[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	dotIDs := make(map[string]string, len(keys))
+	for i, key := range keys {
+		dotIDs[key] = fmt.Sprintf("n%d", i)
+	}
+
+	diffWriter := bufio.NewWriter(output)
+	fmt.Fprintln(diffWriter, "digraph dependency_graph_diff {")
+
+	for _, key := range keys {
+		oldNode, inOld := oldNodes[key]
+		newNode, inNew := newNodes[key]
+
+		var (
+			color string
+			label string
+		)
+		switch {
+		case !inOld:
+			color, label = diffColorAdded, newNode.FriendlyName()
+		case !inNew:
+			color, label = diffColorRemoved, oldNode.FriendlyName()
+		case oldNode.Type != newNode.Type:
+			color, label = diffColorChanged, fmt.Sprintf("%s (was %s)", newNode.FriendlyName(), oldNode.Type)
+		case oldNode.State != newNode.State:
+			color, label = diffColorChanged, fmt.Sprintf("%s (was %s)", newNode.FriendlyName(), oldNode.State)
+		default:
+			color, label = diffColorUnchanged, newNode.FriendlyName()
+		}
+		fmt.Fprintf(diffWriter, "\t%s [label=%s, color=%s];\n", dotIDs[key], strconv.Quote(label), color)
+	}
+
+	allEdges := make(map[[2]string]bool, len(oldEdges)+len(newEdges))
+	for edge := range oldEdges {
+		allEdges[edge] = true
+	}
+	for edge := range newEdges {
+		allEdges[edge] = true
+	}
+
+	for _, edge := range sortedEdgeContentKeys(allEdges) {
+		color := diffColorUnchanged
+		switch {
+		case !oldEdges[edge]:
+			color = diffColorAdded
+		case !newEdges[edge]:
+			color = diffColorRemoved
+		}
+		fmt.Fprintf(diffWriter, "\t%s -> %s [color=%s];\n", dotIDs[edge[0]], dotIDs[edge[1]], color)
+	}
+
+	fmt.Fprintln(diffWriter, "}")
+	err = diffWriter.Flush()
+
+	return
+}
+
+// nodeContentKeys maps a content based key to every node of the graph, so nodes can be matched across graphs
+// without relying on IDs. Keys are built from the node name and version, so a node keeps its key if its type
+// changes. Meta nodes have no name or version of their own and are keyed by the nodes they depend on instead. If
+// several nodes share a key (such as the run and build nodes of a package), they are told apart by their canonical
+// order (see NodesCanonical).
+func nodeContentKeys(g *PkgGraph) (keys map[string]*PkgNode) {
+	keys = make(map[string]*PkgNode)
+	for _, n := range g.NodesCanonical() {
+		baseKey := nodeContentKey(g, n)
+		key := baseKey
+		for duplicate := 2; keys[key] != nil; duplicate++ {
+			key = fmt.Sprintf("%s#%d", baseKey, duplicate)
+		}
+		keys[key] = n
+	}
+	return
+}
+
+// nodeContentKey returns the content based key of a single node, see nodeContentKeys.
+func nodeContentKey(g *PkgGraph, n *PkgNode) string {
+	if n.Type == TypePureMeta {
+		var dependencyKeys []string
+		for _, dependency := range graph.NodesOf(g.From(n.ID())) {
+			dependencyNode := dependency.(*PkgNode).This
+			if dependencyNode.Type == TypePureMeta {
+				// Avoid recursing through chains of meta nodes
+				dependencyKeys = append(dependencyKeys, dependencyNode.Type.String())
+				continue
+			}
+			dependencyKeys = append(dependencyKeys, nodeContentKey(g, dependencyNode))
+		}
+		sort.Strings(dependencyKeys)
+		return fmt.Sprintf("%s|[%s]", n.Type, strings.Join(dependencyKeys, ";"))
+	}
+
+	return fmt.Sprintf("%s|%s", canonicalName(n), nodeVersion(n))
+}
+
+// edgeContentKeys returns the set of edges of the graph as pairs of content based node keys.
+func edgeContentKeys(g *PkgGraph, nodeKeys map[string]*PkgNode) (edges map[[2]string]bool) {
+	keysByID := make(map[int64]string, len(nodeKeys))
+	for key, n := range nodeKeys {
+		keysByID[n.ID()] = key
+	}
+
+	edges = make(map[[2]string]bool)
+	for _, edge := range graph.EdgesOf(g.Edges()) {
+		edges[[2]string{keysByID[edge.From().ID()], keysByID[edge.To().ID()]}] = true
+	}
+	return
+}
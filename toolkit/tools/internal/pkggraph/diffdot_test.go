@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/graph/formats/dot"
+)
+
+// Make sure added, removed, and changed nodes and edges are colored, matching nodes by content
+func TestWriteDiffDOT(t *testing.T) {
+	oldGraph, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Build the new graph with different IDs than the old one
+	newGraph := NewPkgGraph()
+	for i := len(allNodes) - 1; i >= 0; i-- {
+		if allNodes[i].Type == TypeBuild || allNodes[i] == pkgD1Unresolved {
+			continue
+		}
+		_, err = addNodeToGraphHelper(newGraph, allNodes[i])
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, addNodesHelper(newGraph, buildNodes))
+	for _, edge := range edges {
+		if edge[1] != pkgD1Unresolved {
+			assert.NoError(t, addEdgeHelper(newGraph, *edge[0], *edge[1]))
+		}
+	}
+	findTestNode(t, newGraph, pkgCBuild).State = StateUpToDate
+	eRun, err := newGraph.AddPkgNode(&pkgjson.PackageVer{Name: "E", Version: "1"}, StateMeta, TypeRun, "E.src.rpm", "E.rpm", "E.spec", "", "test_arch", "")
+	assert.NoError(t, err)
+	assert.NoError(t, newGraph.AddEdge(findTestNode(t, newGraph, pkgABuild), eRun))
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteDiffDOT(oldGraph, newGraph, &buf))
+	_, err = dot.ParseBytes(buf.Bytes())
+	assert.NoError(t, err)
+	output := buf.String()
+
+	colorOf := func(labelPrefix string) string {
+		match := regexp.MustCompile(`label="` + regexp.QuoteMeta(labelPrefix) + `[^"]*", color=(\w+)`).FindStringSubmatch(output)
+		if assert.NotNil(t, match, "no node labeled %s", labelPrefix) {
+			return match[1]
+		}
+		return ""
+	}
+	assert.Equal(t, diffColorAdded, colorOf("E-1-RUN"))
+	// Remote nodes all share the same friendly name, only D1 was removed
+	assert.Equal(t, 1, strings.Count(output, ", color="+diffColorRemoved+"];"))
+	assert.Equal(t, diffColorChanged, colorOf("C-3-3-BUILD"))
+	assert.Equal(t, diffColorUnchanged, colorOf("A-1-RUN"))
+
+	assert.Equal(t, 1, strings.Count(output, "[color="+diffColorAdded+"]"))
+	assert.Equal(t, 1, strings.Count(output, "[color="+diffColorRemoved+"]"))
+	assert.Equal(t, len(edges)-1, strings.Count(output, "[color="+diffColorUnchanged+"]"))
+
+	// Identical graphs produce no differences
+	buf.Reset()
+	assert.NoError(t, WriteDiffDOT(oldGraph, oldGraph, &buf))
+	assert.NotContains(t, buf.String(), "color="+diffColorAdded)
+	assert.NotContains(t, buf.String(), "color="+diffColorRemoved)
+	assert.NotContains(t, buf.String(), "color="+diffColorChanged)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+// WriteDOTGraphClustered writes the graph as a DOT digraph meant for visualization, with the run, build, and
+// prebuilt nodes of each local SRPM grouped into a "subgraph cluster_N" block labeled with the SRPM's file name.
+// Every other node is written outside of any cluster. Nodes are labeled with their friendly names and colored
+// by state (see DOTColor and DOTColorFunc).
+// Unlike WriteDOTGraph the output does not carry the node data, so it can't be read back with ReadDOTGraph.
+func WriteDOTGraphClustered(g *PkgGraph, output io.Writer) (err error) {
+	var (
+		srpmNodes     = make(map[string][]*PkgNode)
+		srpmPaths     []string
+		unclustered   []*PkgNode
+		clusterWriter = bufio.NewWriter(output)
+	)
+
+	nodes := g.AllNodes()
+	sortNodesByID(nodes)
+	for _, n := range nodes {
+		switch n.Type {
+		case TypeRun, TypeBuild, TypePreBuilt:
+			if _, found := srpmNodes[n.SrpmPath]; !found {
+				srpmPaths = append(srpmPaths, n.SrpmPath)
+			}
+			srpmNodes[n.SrpmPath] = append(srpmNodes[n.SrpmPath], n)
+		default:
+			unclustered = append(unclustered, n)
+		}
+	}
+	sort.Strings(srpmPaths)
+
+	fmt.Fprintln(clusterWriter, "digraph dependency_graph {")
+	for i, srpmPath := range srpmPaths {
+		fmt.Fprintf(clusterWriter, "\tsubgraph cluster_%d {\n", i)
+		fmt.Fprintf(clusterWriter, "\t\tlabel=%s;\n", strconv.Quote(srpmNodes[srpmPath][0].SRPMFileName()))
+		for _, n := range srpmNodes[srpmPath] {
+			writeClusteredDOTNode(clusterWriter, "\t\t", n)
+		}
+		fmt.Fprintln(clusterWriter, "\t}")
+	}
+	for _, n := range unclustered {
+		writeClusteredDOTNode(clusterWriter, "\t", n)
+	}
+
+	edges := graph.EdgesOf(g.Edges())
+	sortEdgesByID(edges)
+	for _, edge := range edges {
+		fmt.Fprintf(clusterWriter, "\t%d -> %d;\n", edge.From().ID(), edge.To().ID())
+	}
+	fmt.Fprintln(clusterWriter, "}")
+
+	err = clusterWriter.Flush()
+
+	return
+}
+
+// writeClusteredDOTNode writes a single node statement for WriteDOTGraphClustered.
+func writeClusteredDOTNode(output io.Writer, indent string, n *PkgNode) {
+	fmt.Fprintf(output, "%s%d [label=%s, color=%s];\n", indent, n.ID(), strconv.Quote(n.FriendlyName()), n.outputColor())
+}
@@ -0,0 +1,46 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/graph/formats/dot"
+)
+
+// Make sure the clustered output is valid DOT with one cluster per SRPM
+func TestWriteDOTGraphClustered(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("test", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteDOTGraphClustered(g, &buf))
+
+	file, err := dot.ParseBytes(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(file.Graphs))
+
+	output := buf.String()
+	// C and C2 are built from the same SRPM
+	assert.Equal(t, 3, strings.Count(output, "subgraph cluster_"))
+	assert.Contains(t, output, `label="A.src.rpm";`)
+	assert.Contains(t, output, `label="C.src.rpm";`)
+	assert.Equal(t, len(edges)+1, strings.Count(output, " -> "))
+
+	aBuild := findTestNode(t, g, pkgABuild)
+	bRun := findTestNode(t, g, pkgBRun)
+	assert.Contains(t, output, fmt.Sprintf("\t%d -> %d;\n", aBuild.ID(), bRun.ID()))
+
+	var again bytes.Buffer
+	assert.NoError(t, WriteDOTGraphClustered(g, &again))
+	assert.Equal(t, output, again.String())
+}
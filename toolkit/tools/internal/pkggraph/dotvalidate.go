@@ -0,0 +1,205 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// dotHeaderLimit is how much of the content before a DOT graph's opening brace is kept to check its header.
+const dotHeaderLimit = 1024
+
+// dotReadChunkSize is how much of a DOT file validateDOTStructure reads at a time.
+const dotReadChunkSize = 1 << 20
+
+// dotScanner tracks the lexical structure of a DOT file, one chunk at a time.
+type dotScanner struct {
+	offset        int64
+	header        []byte
+	braceDepth    int
+	bracketDepth  int
+	htmlDepth     int
+	opened        bool
+	closed        bool
+	inString      bool
+	escaped       bool
+	inLineComment bool
+	inBlock       bool
+	atLineStart   bool
+	previous      byte
+}
+
+// validateDOTStructure reads a DOT file in chunks and checks that it holds a single, complete graph: a header
+// naming a graph, balanced braces and brackets, terminated strings and comments, and nothing but whitespace or
+// comments after the graph's closing brace. Only one chunk is held in memory at a time, so corrupt or truncated
+// files are rejected before they are handed to the DOT parser, which needs the whole file in memory.
+// This is not a full DOT parser, a file passing this check may still fail to parse.
+func validateDOTStructure(input io.Reader) (err error) {
+	scanner := dotScanner{atLineStart: true}
+	chunk := make([]byte, dotReadChunkSize)
+
+	for {
+		var n int
+		n, err = input.Read(chunk)
+		if n > 0 {
+			scanErr := scanner.scan(chunk[:n])
+			if scanErr != nil {
+				return scanErr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	return scanner.finish()
+}
+
+// scan processes the next chunk of the file.
+func (s *dotScanner) scan(chunk []byte) (err error) {
+	for _, c := range chunk {
+		err = s.scanByte(c)
+		if err != nil {
+			return
+		}
+		s.offset++
+	}
+	return
+}
+
+// scanByte processes a single character of the file.
+func (s *dotScanner) scanByte(c byte) (err error) {
+	previous := s.previous
+	s.previous = c
+	lineStart := s.atLineStart
+	s.atLineStart = c == '\n'
+
+	switch {
+	case s.inLineComment:
+		s.inLineComment = c != '\n'
+		return
+	case s.inBlock:
+		if previous == '*' && c == '/' {
+			s.inBlock = false
+			// Don't let the closing '/' start a new comment
+			s.previous = 0
+		}
+		return
+	case s.inString:
+		switch {
+		case s.escaped:
+			s.escaped = false
+		case c == '\\':
+			s.escaped = true
+		case c == '"':
+			s.inString = false
+		}
+		return
+	case s.htmlDepth > 0:
+		switch c {
+		case '<':
+			s.htmlDepth++
+		case '>':
+			s.htmlDepth--
+		}
+		return
+	}
+
+	switch c {
+	case '#':
+		if lineStart {
+			s.inLineComment = true
+			return
+		}
+	case '/':
+		if previous == '/' {
+			s.inLineComment = true
+			s.dropLastHeaderByte()
+			return
+		}
+	case '*':
+		if previous == '/' {
+			s.inBlock = true
+			// Don't let the opening '*' close the comment
+			s.previous = 0
+			s.dropLastHeaderByte()
+			return
+		}
+	}
+
+	if s.closed {
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' && c != '/' {
+			return fmt.Errorf("unexpected content after the end of the graph at offset %d", s.offset)
+		}
+		return
+	}
+
+	switch c {
+	case '"':
+		s.inString = true
+	case '<':
+		s.htmlDepth = 1
+	case '{':
+		if !s.opened {
+			if !bytes.Contains(bytes.ToLower(s.header), []byte("graph")) {
+				return fmt.Errorf("missing graph header before offset %d", s.offset)
+			}
+			s.opened = true
+		}
+		s.braceDepth++
+	case '}':
+		s.braceDepth--
+		if s.braceDepth < 0 {
+			return fmt.Errorf("unbalanced '}' at offset %d", s.offset)
+		}
+		if s.braceDepth == 0 {
+			if s.bracketDepth != 0 {
+				return fmt.Errorf("unbalanced '[' before offset %d", s.offset)
+			}
+			s.closed = true
+		}
+	case '[':
+		s.bracketDepth++
+	case ']':
+		s.bracketDepth--
+		if s.bracketDepth < 0 {
+			return fmt.Errorf("unbalanced ']' at offset %d", s.offset)
+		}
+	}
+
+	if !s.opened && len(s.header) < dotHeaderLimit {
+		s.header = append(s.header, c)
+	}
+
+	return
+}
+
+// dropLastHeaderByte forgets the first character of a comment opener which was recorded as part of the header.
+func (s *dotScanner) dropLastHeaderByte() {
+	if !s.opened && len(s.header) > 0 {
+		s.header = s.header[:len(s.header)-1]
+	}
+}
+
+// finish checks the state of the scanner once the whole file has been processed.
+func (s *dotScanner) finish() (err error) {
+	switch {
+	case s.inString:
+		err = fmt.Errorf("unterminated string at end of file")
+	case s.inBlock:
+		err = fmt.Errorf("unterminated comment at end of file")
+	case s.htmlDepth > 0:
+		err = fmt.Errorf("unterminated HTML string at end of file")
+	case !s.opened:
+		err = fmt.Errorf("no graph found")
+	case !s.closed:
+		err = fmt.Errorf("graph is truncated, %d unclosed '{'", s.braceDepth)
+	}
+	return
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDOTStructure(t *testing.T) {
+	valid := []string{
+		`digraph g {}`,
+		"strict digraph dependency_graph {\n// Node definitions.\n\"a {\" [label=\"}\\\"\"];\n\"a {\" -> b;\n}\n",
+		"/* leading { comment */ digraph g { a [label=<<b>}</b>>]; }\n# trailing comment {\n// another {\n",
+	}
+	for _, input := range valid {
+		assert.NoError(t, validateDOTStructure(strings.NewReader(input)), input)
+	}
+
+	invalid := []string{
+		``,
+		`{ a -> b }`,
+		`digraph g { a -> b;`,
+		`digraph g { a [label="unterminated]; }`,
+		`digraph g { a [label=x; }`,
+		`digraph g { a -> b; } }`,
+		`digraph g { a -> b; } garbage`,
+		`digraph g { a -> b; } /* unterminated`,
+	}
+	for _, input := range invalid {
+		assert.Error(t, validateDOTStructure(strings.NewReader(input)), input)
+	}
+}
+
+func TestValidateDOTStructureReferenceGraph(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteDOTGraph(g, &buf))
+	assert.NoError(t, validateDOTStructure(bytes.NewReader(buf.Bytes())))
+
+	// A truncated file is rejected before it is parsed
+	err = ReadDOTGraph(NewPkgGraph(), bytes.NewReader(buf.Bytes()[:buf.Len()/2]))
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "truncated.dot")
+	assert.NoError(t, ioutil.WriteFile(path, buf.Bytes()[:buf.Len()-3], 0644))
+	err = ReadDOTGraphFile(NewPkgGraph(), path)
+	assert.Error(t, err)
+
+	path = filepath.Join(t.TempDir(), "graph.dot")
+	assert.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+	gIn := NewPkgGraph()
+	assert.NoError(t, ReadDOTGraphFile(gIn, path))
+	checkTestGraph(t, gIn)
+}
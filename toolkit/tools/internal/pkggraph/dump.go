@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+// dumpNoSRPM is the group DumpText lists nodes without an SRPM under.
+const dumpNoSRPM = "(no SRPM)"
+
+// DumpText writes a human readable listing of the graph meant for debugging and bug reports:
+//
+//	A.src.rpm
+//	  A-1-RUN<Meta> (ID=0, State=Meta)
+//	    -> A-1-BUILD<Build> (ID=4)
+//
+// Nodes are grouped by SRPM, sorted by SRPM path then by ID, and each node is followed by its dependencies sorted
+// by ID. Nodes without an SRPM (ie goal and meta nodes) are listed last. The output can't be read back.
+func (g *PkgGraph) DumpText(output io.Writer) (err error) {
+	groups := make(map[string][]*PkgNode)
+	for _, n := range g.AllNodes() {
+		srpmPath := n.SrpmPath
+		if srpmPath == "" || srpmPath == "<NO_SRPM_PATH>" {
+			srpmPath = dumpNoSRPM
+		}
+		groups[srpmPath] = append(groups[srpmPath], n)
+	}
+
+	srpmPaths := make([]string, 0, len(groups))
+	for srpmPath := range groups {
+		if srpmPath != dumpNoSRPM {
+			srpmPaths = append(srpmPaths, srpmPath)
+		}
+	}
+	sort.Strings(srpmPaths)
+	if _, found := groups[dumpNoSRPM]; found {
+		srpmPaths = append(srpmPaths, dumpNoSRPM)
+	}
+
+	dumpWriter := bufio.NewWriter(output)
+	for _, srpmPath := range srpmPaths {
+		fmt.Fprintln(dumpWriter, srpmPath)
+
+		nodes := groups[srpmPath]
+		sortNodesByID(nodes)
+		for _, n := range nodes {
+			stateName := fmt.Sprintf("%d", n.State)
+			if n.State > StateUnknown && n.State <= StateMAX {
+				stateName = n.State.String()
+			}
+			fmt.Fprintf(dumpWriter, "  %s (ID=%d, State=%s)\n", n.FriendlyName(), n.ID(), stateName)
+
+			dependencies := graph.NodesOf(g.From(n.ID()))
+			sort.Slice(dependencies, func(i, j int) bool {
+				return dependencies[i].ID() < dependencies[j].ID()
+			})
+			for _, dependency := range dependencies {
+				fmt.Fprintf(dumpWriter, "    -> %s (ID=%d)\n", dependency.(*PkgNode).FriendlyName(), dependency.ID())
+			}
+		}
+	}
+
+	err = dumpWriter.Flush()
+
+	return
+}
@@ -0,0 +1,46 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpText(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	goalNode, err := g.AddGoalNode("test", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, g.DumpText(&buf))
+	output := buf.String()
+
+	aRun := findTestNode(t, g, pkgARun)
+	aBuild := findTestNode(t, g, pkgABuild)
+	d1 := findTestNode(t, g, pkgD1Unresolved)
+
+	// A run is listed first with its dependencies sorted by ID
+	expectedA := fmt.Sprintf("A.src.rpm\n  %s (ID=%d, State=Meta)\n", aRun.FriendlyName(), aRun.ID())
+	assert.True(t, strings.HasPrefix(output, expectedA), output)
+	assert.Contains(t, output, fmt.Sprintf("    -> %s (ID=%d)\n    -> %s (ID=%d)\n", aBuild.FriendlyName(), aBuild.ID(), d1.FriendlyName(), d1.ID()))
+
+	// The goal node has no SRPM and is listed last
+	expectedGoal := fmt.Sprintf("%s\n  %s (ID=%d, State=Meta)\n    -> %s (ID=%d)\n", dumpNoSRPM, goalNode.FriendlyName(), goalNode.ID(), aRun.FriendlyName(), aRun.ID())
+	assert.True(t, strings.HasSuffix(output, expectedGoal), output)
+
+	// C and C2 share a group
+	assert.Equal(t, 1, strings.Count(output, "\nC.src.rpm\n"))
+
+	var again bytes.Buffer
+	assert.NoError(t, g.DumpText(&again))
+	assert.Equal(t, output, again.String())
+}
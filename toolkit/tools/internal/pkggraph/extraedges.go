@@ -0,0 +1,109 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"fmt"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/logger"
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"gonum.org/v1/gonum/graph/topo"
+)
+
+// ExtraEdge describes a dependency which is not expressed in any spec file and is maintained out-of-band.
+type ExtraEdge struct {
+	From      *pkgjson.PackageVer // The package holding the dependency
+	To        *pkgjson.PackageVer // The package being depended on
+	BuildTime bool                // If set, the dependency is added to the build node of From instead of its run node
+}
+
+// ApplyExtraEdges adds the dependencies described by edges to the graph. Both ends of each edge are resolved
+// through the lookup table, and each edge's requirement is recorded (see EdgeConstraint).
+// Edges are applied in order. If any edge can't be resolved or would introduce a cycle, every edge added by
+// this call is removed again and an error is returned.
+func (g *PkgGraph) ApplyExtraEdges(edges []ExtraEdge) (err error) {
+	type addedEdge struct {
+		from, to *PkgNode
+	}
+	var added []addedEdge
+
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, edge := range added {
+			g.RemoveEdge(edge.from.ID(), edge.to.ID())
+		}
+	}()
+
+	for _, extraEdge := range edges {
+		var from, to *PkgNode
+		from, to, err = g.resolveExtraEdge(extraEdge)
+		if err != nil {
+			return
+		}
+
+		if g.HasEdgeFromTo(from.ID(), to.ID()) {
+			logger.Log.Debugf("Extra edge '%s' -> '%s' already exists", from.FriendlyName(), to.FriendlyName())
+			continue
+		}
+
+		if from == to || topo.PathExistsIn(g, to, from) {
+			err = fmt.Errorf("extra edge '%s' -> '%s' would introduce a cycle", from.FriendlyName(), to.FriendlyName())
+			return
+		}
+
+		err = g.AddEdge(from, to)
+		if err != nil {
+			return
+		}
+		added = append(added, addedEdge{from: from, to: to})
+
+		err = g.SetEdgeConstraint(from, to, extraEdge.To)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// resolveExtraEdge finds the nodes an ExtraEdge refers to.
+func (g *PkgGraph) resolveExtraEdge(extraEdge ExtraEdge) (from, to *PkgNode, err error) {
+	if extraEdge.From == nil || extraEdge.To == nil {
+		err = fmt.Errorf("extra edge is missing a package")
+		return
+	}
+
+	fromLookup, err := g.FindBestPkgNode(extraEdge.From)
+	if err != nil {
+		return
+	}
+	if fromLookup == nil {
+		err = fmt.Errorf("could not find a node for extra edge source '%s'", extraEdge.From)
+		return
+	}
+
+	toLookup, err := g.FindBestPkgNode(extraEdge.To)
+	if err != nil {
+		return
+	}
+	if toLookup == nil {
+		err = fmt.Errorf("could not find a node for extra edge target '%s'", extraEdge.To)
+		return
+	}
+
+	from = fromLookup.RunNode
+	if extraEdge.BuildTime {
+		if fromLookup.BuildNode == nil {
+			err = fmt.Errorf("extra edge source '%s' has no build node", extraEdge.From)
+			return
+		}
+		from = fromLookup.BuildNode
+	}
+	to = toLookup.RunNode
+
+	return
+}
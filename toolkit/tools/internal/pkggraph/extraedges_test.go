@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Make sure extra edges are resolved and added, and that a bad edge rolls back the whole set
+func TestApplyExtraEdges(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	a, err := g.FindExactPkgNodeFromPkg(&pkgA)
+	assert.NoError(t, err)
+	c, err := g.FindExactPkgNodeFromPkg(&pkgC)
+	assert.NoError(t, err)
+	c2, err := g.FindExactPkgNodeFromPkg(&pkgC2)
+	assert.NoError(t, err)
+
+	valid := ExtraEdge{From: &pkgC2, To: &pkgA}
+	cyclic := ExtraEdge{From: &pkgC, To: &pkgA}
+
+	err = g.ApplyExtraEdges([]ExtraEdge{valid, cyclic})
+	assert.Error(t, err)
+	assert.False(t, g.HasEdgeFromTo(c2.RunNode.ID(), a.RunNode.ID()))
+	assert.False(t, g.HasEdgeFromTo(c.RunNode.ID(), a.RunNode.ID()))
+	assert.Nil(t, g.EdgeConstraint(c2.RunNode, a.RunNode))
+	checkTestGraph(t, g)
+
+	buildTime := ExtraEdge{From: &pkgC2, To: &pkgA, BuildTime: true}
+	err = g.ApplyExtraEdges([]ExtraEdge{valid, buildTime})
+	assert.NoError(t, err)
+	assert.True(t, g.HasEdgeFromTo(c2.RunNode.ID(), a.RunNode.ID()))
+	assert.True(t, g.HasEdgeFromTo(c2.BuildNode.ID(), a.RunNode.ID()))
+	assert.Equal(t, &pkgA, g.EdgeConstraint(c2.RunNode, a.RunNode))
+
+	// Applying the same edges again is a no-op
+	assert.NoError(t, g.ApplyExtraEdges([]ExtraEdge{valid}))
+	assert.Equal(t, len(edges)+2, g.Edges().Len())
+}
+
+func TestApplyExtraEdgesMissingPackage(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	missing := &pkgjson.PackageVer{Name: "missing", Version: "1"}
+	err = g.ApplyExtraEdges([]ExtraEdge{{From: &pkgA, To: missing}})
+	assert.Error(t, err)
+	checkTestGraph(t, g)
+}
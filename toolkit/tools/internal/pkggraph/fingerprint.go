@@ -0,0 +1,111 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+// fingerprintNode holds every field of a PkgNode which is part of the fingerprint, the node ID is left out.
+type fingerprintNode struct {
+	VersionedPkg *pkgjson.PackageVer
+	State        string
+	Type         string
+	SrpmPath     string
+	RpmPath      string
+	SpecPath     string
+	SourceDir    string
+	Architecture string
+	SourceRepo   string
+	GoalName     string
+	Implicit     bool
+	Pinned       bool
+}
+
+// fingerprintDocument is the canonical form of a graph which is hashed by Fingerprint.
+type fingerprintDocument struct {
+	Nodes []string    // Canonical node encodings, in NodesCanonical order
+	Edges [][4]string // (from, to, constraint, weight) canonical encodings, sorted
+}
+
+// Fingerprint returns a stable SHA-256 content hash of the graph as a hex string. Every field of every node,
+// every edge, and every recorded edge constraint and weight is hashed, but node IDs are not: nodes are identified by their
+// content, so two graphs built from the same packages produce the same fingerprint regardless of the order the
+// nodes were added in. Nodes with identical content (ie two empty meta nodes) can't be told apart, so graphs which
+// only differ in how such nodes are wired together may share a fingerprint.
+// Returns an error if a node has an invalid state or type.
+func (g *PkgGraph) Fingerprint() (fingerprint string, err error) {
+	var document fingerprintDocument
+
+	keys := make(map[int64]string)
+	for _, n := range g.NodesCanonical() {
+		if n.State <= StateUnknown || n.State > StateMAX {
+			err = fmt.Errorf("can't fingerprint node %d, invalid state %d", n.ID(), n.State)
+			return
+		}
+		if n.Type <= TypeUnknown || n.Type > TypePreBuilt {
+			err = fmt.Errorf("can't fingerprint node %d, invalid type %d", n.ID(), n.Type)
+			return
+		}
+
+		var key []byte
+		key, err = json.Marshal(fingerprintNode{
+			VersionedPkg: n.VersionedPkg,
+			State:        n.State.String(),
+			Type:         n.Type.String(),
+			SrpmPath:     n.SrpmPath,
+			RpmPath:      n.RpmPath,
+			SpecPath:     n.SpecPath,
+			SourceDir:    n.SourceDir,
+			Architecture: n.Architecture,
+			SourceRepo:   n.SourceRepo,
+			GoalName:     n.GoalName,
+			Implicit:     n.Implicit,
+			Pinned:       n.Pinned,
+		})
+		if err != nil {
+			return
+		}
+		keys[n.ID()] = string(key)
+		document.Nodes = append(document.Nodes, string(key))
+	}
+
+	for _, edge := range graph.EdgesOf(g.Edges()) {
+		constraint := ""
+		if edgeConstraint := g.EdgeConstraint(edge.From().(*PkgNode), edge.To().(*PkgNode)); edgeConstraint != nil {
+			constraint = edgeConstraint.String()
+		}
+		weight := ""
+		if edgeWeight, found := g.edgeWeights[edgeID{from: edge.From().ID(), to: edge.To().ID()}]; found {
+			weight = strconv.FormatFloat(edgeWeight, 'g', -1, 64)
+		}
+		document.Edges = append(document.Edges, [4]string{keys[edge.From().ID()], keys[edge.To().ID()], constraint, weight})
+	}
+	sort.Slice(document.Edges, func(i, j int) bool {
+		for k := range document.Edges[i] {
+			if document.Edges[i][k] != document.Edges[j][k] {
+				return document.Edges[i][k] < document.Edges[j][k]
+			}
+		}
+		return false
+	})
+
+	data, err := json.Marshal(document)
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256(data)
+	fingerprint = hex.EncodeToString(sum[:])
+
+	return
+}
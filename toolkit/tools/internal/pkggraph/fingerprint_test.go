@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	fingerprint, err := g.Fingerprint()
+	assert.NoError(t, err)
+	assert.Len(t, fingerprint, 64)
+
+	again, err := g.Fingerprint()
+	assert.NoError(t, err)
+	assert.Equal(t, fingerprint, again)
+
+	// Build the same graph in a different order, so every node gets a different ID
+	var reordered []*PkgNode
+	for _, group := range [][]*PkgNode{unresolvedNodes, runNodes, buildNodes} {
+		for i := len(group) - 1; i >= 0; i-- {
+			reordered = append(reordered, group[i])
+		}
+	}
+	other := NewPkgGraph()
+	assert.NoError(t, addNodesHelper(other, reordered))
+	for i := len(edges) - 1; i >= 0; i-- {
+		assert.NoError(t, addEdgeHelper(other, *edges[i][0], *edges[i][1]))
+	}
+	assert.NotEqual(t, findTestNode(t, g, pkgARun).ID(), findTestNode(t, other, pkgARun).ID())
+	otherFingerprint, err := other.Fingerprint()
+	assert.NoError(t, err)
+	assert.Equal(t, fingerprint, otherFingerprint)
+
+	// Node fields, edges, and edge constraints and weights are all part of the fingerprint
+	findTestNode(t, other, pkgARun).State = StateUpToDate
+	otherFingerprint, err = other.Fingerprint()
+	assert.NoError(t, err)
+	assert.NotEqual(t, fingerprint, otherFingerprint)
+	findTestNode(t, other, pkgARun).State = pkgARun.State
+
+	assert.NoError(t, addEdgeHelper(other, *pkgC2Run, *pkgD1Unresolved))
+	otherFingerprint, err = other.Fingerprint()
+	assert.NoError(t, err)
+	assert.NotEqual(t, fingerprint, otherFingerprint)
+
+	aRun := findTestNode(t, g, pkgARun)
+	d1 := findTestNode(t, g, pkgD1Unresolved)
+	assert.NoError(t, g.SetEdgeConstraint(aRun, d1, &pkgjson.PackageVer{Name: "D", Condition: ">=", Version: "1"}))
+	constrained, err := g.Fingerprint()
+	assert.NoError(t, err)
+	assert.NotEqual(t, fingerprint, constrained)
+
+	assert.NoError(t, g.SetEdgeWeight(aRun, d1, 2))
+	weighted, err := g.Fingerprint()
+	assert.NoError(t, err)
+	assert.NotEqual(t, constrained, weighted)
+
+	aRun.State = StateUnknown
+	_, err = g.Fingerprint()
+	assert.Error(t, err)
+}
@@ -0,0 +1,349 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/logger"
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/traverse"
+)
+
+// ExclusiveToGoal returns the build nodes reachable from the named goal node which are not reachable from any
+// other goal node. The nodes are sorted by ID.
+func (g *PkgGraph) ExclusiveToGoal(goalName string) (exclusiveNodes []*PkgNode, err error) {
+	goalNode := g.FindGoalNode(goalName)
+	if goalNode == nil {
+		err = fmt.Errorf("could not find goal node '%s'", goalName)
+		return
+	}
+
+	nodeGoals := g.goalsReachingNodes()
+	for _, node := range g.AllNodesFrom(goalNode) {
+		if node.Type != TypeBuild {
+			continue
+		}
+
+		if len(nodeGoals[node.ID()]) == 1 {
+			exclusiveNodes = append(exclusiveNodes, node)
+		}
+	}
+	sortNodesByID(exclusiveNodes)
+
+	return
+}
+
+// RemovalImpact returns the nodes which are currently reachable from at least one goal node but would no longer be
+// reachable from any goal if the given node were removed. The node itself is not included and the graph is not
+// modified. The nodes are sorted by ID.
+func (g *PkgGraph) RemovalImpact(node *PkgNode) (orphaned []*PkgNode, err error) {
+	if node == nil || g.Node(node.ID()) == nil {
+		err = fmt.Errorf("node is not part of the graph")
+		return
+	}
+
+	reachableWithout := make(map[int64]bool)
+	search := traverse.DepthFirst{
+		Traverse: func(edge graph.Edge) bool {
+			return edge.To().ID() != node.ID()
+		},
+	}
+	for _, goalNode := range g.allGoalNodes() {
+		if goalNode.ID() == node.ID() {
+			continue
+		}
+		search.Walk(g, goalNode, func(n graph.Node) bool {
+			reachableWithout[n.ID()] = true
+			return false
+		})
+	}
+
+	for nodeID := range g.ReachableFrom(g.allGoalNodes()) {
+		pkgNode := g.Node(nodeID).(*PkgNode).This
+		if pkgNode.Type != TypeGoal && nodeID != node.ID() && !reachableWithout[nodeID] {
+			orphaned = append(orphaned, pkgNode)
+		}
+	}
+	sortNodesByID(orphaned)
+
+	return
+}
+
+// IntroducingDependents returns the entry points through which the named goal pulls in the package unwantedName:
+// the direct dependencies of the goal node which are, or transitively depend on, any node providing unwantedName.
+// Removing all of them from the goal is enough to drop the package. The nodes are sorted by ID.
+func (g *PkgGraph) IntroducingDependents(unwantedName string, goalName string) (entryPoints []*PkgNode, err error) {
+	goalNode := g.FindGoalNode(goalName)
+	if goalNode == nil {
+		err = fmt.Errorf("could not find goal node '%s'", goalName)
+		return
+	}
+
+	lookupEntries := g.lookupTable()[unwantedName]
+	if len(lookupEntries) == 0 {
+		err = fmt.Errorf("could not find package '%s'", unwantedName)
+		return
+	}
+
+	pullsInUnwanted := make(map[int64]bool)
+	for _, lookupEntry := range lookupEntries {
+		for _, dependent := range g.AllNodesTo(lookupEntry.RunNode) {
+			pullsInUnwanted[dependent.ID()] = true
+		}
+	}
+
+	for _, dependency := range graph.NodesOf(g.From(goalNode.ID())) {
+		if pullsInUnwanted[dependency.ID()] {
+			entryPoints = append(entryPoints, dependency.(*PkgNode).This)
+		}
+	}
+	sortNodesByID(entryPoints)
+
+	return
+}
+
+// NodesNotReachableFromGoals returns the build nodes which no goal node (transitively) depends on, and so don't need
+// to be built. If the graph has no goal nodes every build node is returned. The nodes are sorted by ID.
+func (g *PkgGraph) NodesNotReachableFromGoals() (unreachable []*PkgNode) {
+	reachable := g.ReachableFrom(g.allGoalNodes())
+	for _, n := range g.AllBuildNodes() {
+		if !reachable[n.ID()] {
+			unreachable = append(unreachable, n)
+		}
+	}
+	sortNodesByID(unreachable)
+
+	return
+}
+
+// allGoalNodes returns every goal node in the graph, sorted by goal name.
+func (g *PkgGraph) allGoalNodes() (goalNodes []*PkgNode) {
+	for _, n := range g.AllNodes() {
+		if n.Type == TypeGoal {
+			goalNodes = append(goalNodes, n)
+		}
+	}
+	sort.Slice(goalNodes, func(i, j int) bool {
+		return goalNodes[i].GoalName < goalNodes[j].GoalName
+	})
+	return
+}
+
+// GoalPackageCounts returns, for each goal node, the number of distinct run nodes reachable from it.
+// Every goal is handled in one shared traversal of the graph. Returns an error if two goal nodes share a name.
+func (g *PkgGraph) GoalPackageCounts() (counts map[string]int, err error) {
+	counts = make(map[string]int)
+	for _, goalNode := range g.allGoalNodes() {
+		if _, found := counts[goalNode.GoalName]; found {
+			err = fmt.Errorf("found multiple goal nodes named '%s'", goalNode.GoalName)
+			return
+		}
+		counts[goalNode.GoalName] = 0
+	}
+
+	for nodeID, goalNames := range g.goalsReachingNodes() {
+		if g.Node(nodeID).(*PkgNode).Type != TypeRun {
+			continue
+		}
+		for goalName := range goalNames {
+			counts[goalName]++
+		}
+	}
+
+	return
+}
+
+// GoalOverlap returns, for each pair of goal names, the run nodes reachable from both goals, sorted by ID.
+// Pairs are keyed with the names in sorted order, and pairs of goals without any shared run nodes are omitted.
+func (g *PkgGraph) GoalOverlap() (overlap map[[2]string][]*PkgNode) {
+	overlap = make(map[[2]string][]*PkgNode)
+	for nodeID, goalNames := range g.goalsReachingNodes() {
+		node := g.Node(nodeID).(*PkgNode).This
+		if node.Type != TypeRun || len(goalNames) < 2 {
+			continue
+		}
+
+		names := make([]string, 0, len(goalNames))
+		for goalName := range goalNames {
+			names = append(names, goalName)
+		}
+		sort.Strings(names)
+
+		for i := range names {
+			for j := i + 1; j < len(names); j++ {
+				pair := [2]string{names[i], names[j]}
+				overlap[pair] = append(overlap[pair], node)
+			}
+		}
+	}
+
+	for _, nodes := range overlap {
+		sortNodesByID(nodes)
+	}
+
+	return
+}
+
+// goalsReachingNodes maps each node ID to the set of names of the goal nodes it is reachable from.
+// Nodes which aren't reachable from any goal are not present in the map.
+// All goals are handled in a single traversal: goal names are pushed along the edges of the graph and a node is
+// only revisited when it picks up a goal it has not seen before, so cycles are handled naturally.
+func (g *PkgGraph) goalsReachingNodes() (nodeGoals map[int64]map[string]bool) {
+	reached := make(map[int64]map[string]bool)
+	var queue []int64
+	for _, goalNode := range g.allGoalNodes() {
+		if reached[goalNode.ID()] == nil {
+			reached[goalNode.ID()] = make(map[string]bool)
+		}
+		reached[goalNode.ID()][goalNode.GoalName] = true
+		queue = append(queue, goalNode.ID())
+	}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, dependency := range graph.NodesOf(g.From(id)) {
+			dependencyID := dependency.ID()
+			changed := false
+			for goalName := range reached[id] {
+				if reached[dependencyID] == nil {
+					reached[dependencyID] = make(map[string]bool)
+				}
+				if !reached[dependencyID][goalName] {
+					reached[dependencyID][goalName] = true
+					changed = true
+				}
+			}
+			if changed {
+				queue = append(queue, dependencyID)
+			}
+		}
+	}
+
+	nodeGoals = make(map[int64]map[string]bool, len(reached))
+	for id, goalNames := range reached {
+		if g.Node(id).(*PkgNode).Type != TypeGoal {
+			nodeGoals[id] = goalNames
+		}
+	}
+	return
+}
+
+// resolveGoalPackages finds the run node satisfying each of a goal's packages, preferring exact matches so the
+// revision number is matched exactly when available. Packages without any version or condition (ie just "openssl")
+// resolve to the highest available version of the package. An empty package list selects every run node. Packages
+// without a matching node are returned in unresolved.
+func (g *PkgGraph) resolveGoalPackages(goalName string, packages []*pkgjson.PackageVer) (targets []*PkgNode, unresolved []*pkgjson.PackageVer, err error) {
+	selectAll := len(packages) == 0
+	if !selectAll {
+		logger.Log.Debugf("Adding \"%s\" goal", goalName)
+		for _, pkg := range packages {
+			logger.Log.Tracef("\t%s-%s", pkg.Name, pkg.Version)
+		}
+	} else {
+		logger.Log.Debugf("Adding \"%s\" goal for all nodes", goalName)
+		for _, node := range g.AllRunNodes() {
+			logger.Log.Tracef("\t%s-%s %d", node.VersionedPkg.Name, node.VersionedPkg.Version, node.ID())
+			packages = append(packages, node.VersionedPkg)
+		}
+	}
+
+	seen := make(map[*pkgjson.PackageVer]bool)
+	for _, pkg := range packages {
+		if seen[pkg] {
+			continue
+		}
+		seen[pkg] = true
+
+		var existingNode *LookupNode
+		// Each node is selected directly when selecting all nodes, even those without a version
+		if !selectAll && isLatestRequest(pkg) {
+			existingNode, err = g.FindLatestPkgNode(pkg.Name)
+		} else {
+			existingNode, err = g.FindExactPkgNodeFromPkg(pkg)
+		}
+		if err != nil {
+			return
+		}
+		if existingNode == nil {
+			// Try again with a more general search
+			existingNode, err = g.FindBestPkgNode(pkg)
+			if err != nil {
+				return
+			}
+		}
+
+		if existingNode != nil {
+			logger.Log.Tracef("Found %s to satisfy %s", existingNode.RunNode, pkg)
+			targets = append(targets, existingNode.RunNode)
+		} else {
+			logger.Log.Warnf("Could not goal package %+v", pkg)
+			unresolved = append(unresolved, pkg)
+		}
+	}
+
+	return
+}
+
+// isLatestRequest returns true if a goal package only names the package, asking for its newest version.
+func isLatestRequest(pkg *pkgjson.PackageVer) bool {
+	return pkg.Version == "" && pkg.Condition == "" && pkg.SVersion == "" && pkg.SCondition == ""
+}
+
+// RemoveGoalNode removes the named goal node along with all of its edges.
+func (g *PkgGraph) RemoveGoalNode(goalName string) (err error) {
+	goalNode := g.FindGoalNode(goalName)
+	if goalNode == nil {
+		err = fmt.Errorf("could not find goal node '%s'", goalName)
+		return
+	}
+
+	// Goal nodes are never part of the lookup table
+	g.RemoveNode(goalNode.ID())
+	logger.Log.Debugf("Removed \"%s\" goal", goalName)
+
+	return
+}
+
+// UpdateGoalNode replaces the edges of the named goal node with edges to a new set of packages, resolved the same
+// way as AddGoalNode. If strict is set and any package can't be found the goal is left unchanged.
+func (g *PkgGraph) UpdateGoalNode(goalName string, packages []*pkgjson.PackageVer, strict bool) (err error) {
+	goalNode := g.FindGoalNode(goalName)
+	if goalNode == nil {
+		err = fmt.Errorf("could not find goal node '%s'", goalName)
+		return
+	}
+
+	targets, unresolved, err := g.resolveGoalPackages(goalName, packages)
+	if err != nil {
+		return
+	}
+	if strict && len(unresolved) > 0 {
+		for _, pkg := range unresolved {
+			logger.Log.Warnf("Missing %+v", pkg)
+		}
+		err = fmt.Errorf("could not find all goal nodes with strict=true, goal '%s' is unchanged", goalName)
+		return
+	}
+
+	// graph manipulation calls may panic on error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("updating goal node '%s' failed: %s", goalName, r)
+		}
+	}()
+
+	for _, dependency := range graph.NodesOf(g.From(goalNode.ID())) {
+		g.RemoveEdge(goalNode.ID(), dependency.ID())
+	}
+	for _, target := range targets {
+		g.SetEdge(g.NewEdge(goalNode, target))
+	}
+
+	return
+}
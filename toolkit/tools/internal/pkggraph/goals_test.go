@@ -0,0 +1,248 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Make sure only build nodes needed by a single goal are reported as exclusive
+func TestExclusiveToGoal(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	_, err = g.AddGoalNode("goalA", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("goalB", []*pkgjson.PackageVer{&pkgB}, true)
+	assert.NoError(t, err)
+
+	// B and C are also needed by goalB
+	exclusive, err := g.ExclusiveToGoal("goalA")
+	assert.NoError(t, err)
+	assert.Equal(t, []*PkgNode{findTestNode(t, g, pkgABuild)}, exclusive)
+
+	exclusive, err = g.ExclusiveToGoal("goalB")
+	assert.NoError(t, err)
+	assert.Empty(t, exclusive)
+
+	_, err = g.ExclusiveToGoal("missing")
+	assert.Error(t, err)
+}
+
+// Make sure only nodes which lose every path from the goals are reported
+func TestRemovalImpact(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	goalA, err := g.AddGoalNode("goalA", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("goalB", []*pkgjson.PackageVer{&pkgB}, true)
+	assert.NoError(t, err)
+
+	// B run is still needed directly by goalB
+	orphaned, err := g.RemovalImpact(findTestNode(t, g, pkgABuild))
+	assert.NoError(t, err)
+	assert.Empty(t, orphaned)
+
+	orphaned, err = g.RemovalImpact(findTestNode(t, g, pkgBRun))
+	assert.NoError(t, err)
+	expected := []*PkgNode{
+		findTestNode(t, g, pkgBBuild), findTestNode(t, g, pkgCRun), findTestNode(t, g, pkgCBuild),
+		findTestNode(t, g, pkgD2Unresolved), findTestNode(t, g, pkgD3Unresolved),
+	}
+	sortNodesByID(expected)
+	assert.Equal(t, expected, orphaned)
+
+	orphaned, err = g.RemovalImpact(goalA)
+	assert.NoError(t, err)
+	expected = []*PkgNode{findTestNode(t, g, pkgARun), findTestNode(t, g, pkgABuild), findTestNode(t, g, pkgD1Unresolved)}
+	sortNodesByID(expected)
+	assert.Equal(t, expected, orphaned)
+
+	// The graph is left untouched
+	assert.Equal(t, len(allNodes)+2, g.Nodes().Len())
+
+	_, err = g.RemovalImpact(&PkgNode{nodeID: 1000})
+	assert.Error(t, err)
+}
+
+// Make sure each goal counts the run nodes it can reach, including those shared with other goals
+func TestGoalPackageCounts(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	counts, err := g.GoalPackageCounts()
+	assert.NoError(t, err)
+	assert.Empty(t, counts)
+
+	_, err = g.AddGoalNode("goalA", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("goalB", []*pkgjson.PackageVer{&pkgB}, true)
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("all", nil, true)
+	assert.NoError(t, err)
+
+	counts, err = g.GoalPackageCounts()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"goalA": 3, "goalB": 2, "all": 4}, counts)
+
+	// Counts must match a separate traversal for each goal, even with a cycle in the graph
+	assert.NoError(t, g.AddEdge(findTestNode(t, g, pkgCBuild), findTestNode(t, g, pkgARun)))
+	counts, err = g.GoalPackageCounts()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"goalA": 3, "goalB": 3, "all": 4}, counts)
+}
+
+// Make sure each pair of goals reports the run nodes they share
+func TestGoalOverlap(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.Empty(t, g.GoalOverlap())
+
+	_, err = g.AddGoalNode("goalA", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("goalB", []*pkgjson.PackageVer{&pkgB}, true)
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("goalC2", []*pkgjson.PackageVer{&pkgC2}, true)
+	assert.NoError(t, err)
+
+	expected := []*PkgNode{findTestNode(t, g, pkgBRun), findTestNode(t, g, pkgCRun)}
+	sortNodesByID(expected)
+	assert.Equal(t, map[[2]string][]*PkgNode{{"goalA", "goalB"}: expected}, g.GoalOverlap())
+}
+
+// Make sure only the goal's direct dependencies leading to the unwanted package are reported
+func TestIntroducingDependents(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	_, err = g.AddGoalNode("image", []*pkgjson.PackageVer{&pkgA, &pkgB, &pkgC2}, true)
+	assert.NoError(t, err)
+
+	// C is reached through both A and B, C2 is another version of C requested directly
+	entryPoints, err := g.IntroducingDependents("C", "image")
+	assert.NoError(t, err)
+	expected := []*PkgNode{findTestNode(t, g, pkgARun), findTestNode(t, g, pkgBRun), findTestNode(t, g, pkgC2Run)}
+	sortNodesByID(expected)
+	assert.Equal(t, expected, entryPoints)
+
+	entryPoints, err = g.IntroducingDependents("B", "image")
+	assert.NoError(t, err)
+	expected = []*PkgNode{findTestNode(t, g, pkgARun), findTestNode(t, g, pkgBRun)}
+	sortNodesByID(expected)
+	assert.Equal(t, expected, entryPoints)
+
+	_, err = g.IntroducingDependents("missing", "image")
+	assert.Error(t, err)
+	_, err = g.IntroducingDependents("C", "missing")
+	assert.Error(t, err)
+}
+
+func TestRemoveGoalNode(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	_, err = g.AddGoalNode("test", []*pkgjson.PackageVer{&pkgA, &pkgC2}, true)
+	assert.NoError(t, err)
+	assert.NoError(t, g.RemoveGoalNode("test"))
+	assert.Nil(t, g.FindGoalNode("test"))
+	checkTestGraph(t, g)
+	assert.Equal(t, len(edges), g.Edges().Len())
+
+	assert.Error(t, g.RemoveGoalNode("test"))
+}
+
+func TestUpdateGoalNode(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	goalNode, err := g.AddGoalNode("test", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+
+	aRun := findTestNode(t, g, pkgARun)
+	bRun := findTestNode(t, g, pkgBRun)
+	c2Run := findTestNode(t, g, pkgC2Run)
+
+	assert.NoError(t, g.UpdateGoalNode("test", []*pkgjson.PackageVer{&pkgB, &pkgC2}, true))
+	assert.Equal(t, []*PkgNode{bRun, c2Run}, g.DirectDependencies(goalNode))
+
+	// A strict update with a missing package leaves the goal alone
+	missing := &pkgjson.PackageVer{Name: "missing"}
+	assert.Error(t, g.UpdateGoalNode("test", []*pkgjson.PackageVer{&pkgA, missing}, true))
+	assert.Equal(t, []*PkgNode{bRun, c2Run}, g.DirectDependencies(goalNode))
+
+	// Without strict the packages which can be found are used
+	assert.NoError(t, g.UpdateGoalNode("test", []*pkgjson.PackageVer{&pkgA, missing}, false))
+	assert.Equal(t, []*PkgNode{aRun}, g.DirectDependencies(goalNode))
+
+	assert.Error(t, g.UpdateGoalNode("missing", nil, false))
+}
+
+func TestAddGoalNodeWithUnresolved(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	missing := &pkgjson.PackageVer{Name: "missing"}
+	tooNew := &pkgjson.PackageVer{Name: "A", Version: "2", Condition: ">="}
+	goalNode, unresolved, err := g.AddGoalNodeWithUnresolved("lenient", []*pkgjson.PackageVer{&pkgA, missing, tooNew}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []*pkgjson.PackageVer{missing, tooNew}, unresolved)
+	assert.Equal(t, []*PkgNode{findTestNode(t, g, pkgARun)}, g.DirectDependencies(goalNode))
+
+	_, unresolved, err = g.AddGoalNodeWithUnresolved("strict", []*pkgjson.PackageVer{&pkgB, missing}, true)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "missing")
+	assert.Equal(t, []*pkgjson.PackageVer{missing}, unresolved)
+
+	_, unresolved, err = g.AddGoalNodeWithUnresolved("all", nil, true)
+	assert.NoError(t, err)
+	assert.Empty(t, unresolved)
+}
+
+func TestNodesNotReachableFromGoals(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	allBuildNodes := g.AllBuildNodes()
+	sortNodesByID(allBuildNodes)
+	assert.Equal(t, allBuildNodes, g.NodesNotReachableFromGoals())
+
+	// B depends on C but not on C2
+	_, err = g.AddGoalNode("b", []*pkgjson.PackageVer{&pkgB}, true)
+	assert.NoError(t, err)
+	expected := []*PkgNode{findTestNode(t, g, pkgABuild), findTestNode(t, g, pkgC2Build)}
+	sortNodesByID(expected)
+	assert.Equal(t, expected, g.NodesNotReachableFromGoals())
+
+	_, err = g.AddGoalNode("all", nil, true)
+	assert.NoError(t, err)
+	assert.Empty(t, g.NodesNotReachableFromGoals())
+}
+
+// Make sure goal packages without a version resolve to the newest available version
+func TestAddGoalNodeLatestVersion(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	latest, err := g.FindLatestPkgNode("C")
+	assert.NoError(t, err)
+	assert.Equal(t, findTestNode(t, g, pkgC2Run), latest.RunNode)
+	latest, err = g.FindLatestPkgNode("missing")
+	assert.NoError(t, err)
+	assert.Nil(t, latest)
+
+	goalNode, unresolved, err := g.AddGoalNodeWithUnresolved("latest", []*pkgjson.PackageVer{{Name: "C"}, {Name: "missing"}}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(unresolved))
+	assert.Equal(t, []*PkgNode{findTestNode(t, g, pkgC2Run)}, g.DirectDependencies(goalNode))
+
+	// Explicit versions are still matched exactly
+	goalNode, err = g.AddGoalNode("exact", []*pkgjson.PackageVer{{Name: "C", Version: "3-3"}}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*PkgNode{findTestNode(t, g, pkgCRun)}, g.DirectDependencies(goalNode))
+}
@@ -0,0 +1,49 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Make sure every node is written with its attributes as data elements, along with every edge
+func TestWriteGraphML(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	var buf1, buf2 bytes.Buffer
+	assert.NoError(t, g.WriteGraphML(&buf1))
+	assert.NoError(t, g.WriteGraphML(&buf2))
+	assert.Equal(t, buf1.String(), buf2.String())
+
+	var document graphMLDocument
+	assert.NoError(t, xml.Unmarshal(buf1.Bytes(), &document))
+	assert.Equal(t, graphMLNamespace, document.XMLNS)
+	assert.Equal(t, len(graphMLNodeKeys), len(document.Keys))
+	assert.Equal(t, "directed", document.Graph.EdgeDefault)
+	assert.Equal(t, len(allNodes), len(document.Graph.Nodes))
+	assert.Equal(t, len(edges), len(document.Graph.Edges))
+
+	d6 := findTestNode(t, g, pkgD6Unresolved)
+	expected := graphMLNode{
+		ID: graphMLNodeID(d6),
+		Data: []graphMLData{
+			{Key: "name", Value: "D"},
+			{Key: "version", Value: ">6,<7"},
+			{Key: "type", Value: "Remote"},
+			{Key: "state", Value: "Unresolved"},
+			{Key: "srpm", Value: "url://D.src.rpm"},
+			{Key: "color", Value: "crimson"},
+		},
+	}
+	assert.Contains(t, document.Graph.Nodes, expected)
+
+	aRun := findTestNode(t, g, pkgARun)
+	aBuild := findTestNode(t, g, pkgABuild)
+	assert.Contains(t, document.Graph.Edges, graphMLEdge{Source: graphMLNodeID(aRun), Target: graphMLNodeID(aBuild)})
+}
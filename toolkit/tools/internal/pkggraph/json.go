@@ -0,0 +1,151 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+// jsonGraph is the document written by WriteJSON.
+type jsonGraph struct {
+	Nodes []jsonNode `json:"Nodes"`
+	Edges []jsonEdge `json:"Edges"`
+}
+
+// jsonNode holds every exported field of a PkgNode along with its ID. States and types are stored by name.
+type jsonNode struct {
+	ID           int64               `json:"ID"`
+	VersionedPkg *pkgjson.PackageVer `json:"VersionedPkg"`
+	State        string              `json:"State"`
+	Type         string              `json:"Type"`
+	SrpmPath     string              `json:"SrpmPath"`
+	RpmPath      string              `json:"RpmPath"`
+	SpecPath     string              `json:"SpecPath"`
+	SourceDir    string              `json:"SourceDir"`
+	Architecture string              `json:"Architecture"`
+	SourceRepo   string              `json:"SourceRepo"`
+	GoalName     string              `json:"GoalName"`
+	Implicit     bool                `json:"Implicit"`
+	Pinned       bool                `json:"Pinned"`
+}
+
+// jsonEdge is a single dependency edge, from depends on to.
+type jsonEdge struct {
+	From int64 `json:"From"`
+	To   int64 `json:"To"`
+}
+
+// WriteJSON serializes the graph as a human readable JSON document holding every node (with its ID) and every
+// edge (as a pair of node IDs). Nodes are written in NodesCanonical order and edges in (from, to) ID order, so
+// identical graphs produce identical output.
+// Nodes without package information have a null VersionedPkg. Edge constraints are not serialized.
+func (g *PkgGraph) WriteJSON(output io.Writer) (err error) {
+	var document jsonGraph
+
+	nodes := g.NodesCanonical()
+	document.Nodes = make([]jsonNode, 0, len(nodes))
+	for _, n := range nodes {
+		if n.State <= StateUnknown || n.State > StateMAX {
+			err = fmt.Errorf("can't serialize node %d, invalid state %d", n.ID(), n.State)
+			return
+		}
+		if n.Type <= TypeUnknown || n.Type > TypePreBuilt {
+			err = fmt.Errorf("can't serialize node %d, invalid type %d", n.ID(), n.Type)
+			return
+		}
+
+		document.Nodes = append(document.Nodes, jsonNode{
+			ID:           n.ID(),
+			VersionedPkg: n.VersionedPkg,
+			State:        n.State.String(),
+			Type:         n.Type.String(),
+			SrpmPath:     n.SrpmPath,
+			RpmPath:      n.RpmPath,
+			SpecPath:     n.SpecPath,
+			SourceDir:    n.SourceDir,
+			Architecture: n.Architecture,
+			SourceRepo:   n.SourceRepo,
+			GoalName:     n.GoalName,
+			Implicit:     n.Implicit,
+			Pinned:       n.Pinned,
+		})
+	}
+
+	edges := graph.EdgesOf(g.Edges())
+	sortEdgesByID(edges)
+	for _, edge := range edges {
+		document.Edges = append(document.Edges, jsonEdge{From: edge.From().ID(), To: edge.To().ID()})
+	}
+
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(document)
+
+	return
+}
+
+// ReadJSON de-serializes a graph written by WriteJSON. Node IDs are preserved, and the lookup table is rebuilt
+// from the nodes the first time it is needed, the same way it is for graphs read from DOT.
+func ReadJSON(input io.Reader) (g *PkgGraph, err error) {
+	var document jsonGraph
+
+	err = json.NewDecoder(input).Decode(&document)
+	if err != nil {
+		return
+	}
+
+	// graph manipulation calls may panic on error (such as duplicate node IDs)
+	defer func() {
+		if r := recover(); r != nil {
+			g = nil
+			err = fmt.Errorf("failed to rebuild graph from JSON: %s", r)
+		}
+	}()
+
+	g = NewPkgGraph()
+	for _, entry := range document.Nodes {
+		node := &PkgNode{
+			nodeID:       entry.ID,
+			VersionedPkg: entry.VersionedPkg,
+			SrpmPath:     entry.SrpmPath,
+			RpmPath:      entry.RpmPath,
+			SpecPath:     entry.SpecPath,
+			SourceDir:    entry.SourceDir,
+			Architecture: entry.Architecture,
+			SourceRepo:   entry.SourceRepo,
+			GoalName:     entry.GoalName,
+			Implicit:     entry.Implicit,
+			Pinned:       entry.Pinned,
+		}
+		node.This = node
+
+		node.State, err = ParseNodeState(entry.State)
+		if err != nil {
+			return nil, fmt.Errorf("node %d: %w", entry.ID, err)
+		}
+		node.Type, err = ParseNodeType(entry.Type)
+		if err != nil {
+			return nil, fmt.Errorf("node %d: %w", entry.ID, err)
+		}
+
+		g.AddNode(node)
+	}
+
+	for _, edge := range document.Edges {
+		from := g.Node(edge.From)
+		to := g.Node(edge.To)
+		if from == nil || to == nil {
+			return nil, fmt.Errorf("edge %d -> %d references a missing node", edge.From, edge.To)
+		}
+		g.SetEdge(g.NewEdge(from, to))
+	}
+
+	return
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Make sure a graph survives a JSON round trip, including IDs, nil packages, and the lookup table
+func TestWriteReadJSON(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, g.WriteJSON(&buf))
+	decoded, err := ReadJSON(&buf)
+	assert.NoError(t, err)
+	checkTestGraph(t, decoded)
+}
+
+// Make sure IDs, nil packages, and pinned nodes are preserved and the output is stable
+func TestWriteReadJSONGoalNode(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	goal, err := g.AddGoalNode("test", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+	findTestNode(t, g, pkgBRun).Pinned = true
+
+	var buf bytes.Buffer
+	assert.NoError(t, g.WriteJSON(&buf))
+	written := buf.String()
+	assert.Contains(t, written, `"Type": "Goal"`)
+	assert.Contains(t, written, `"VersionedPkg": null`)
+
+	decoded, err := ReadJSON(&buf)
+	assert.NoError(t, err)
+	decodedGoal := decoded.FindGoalNode("test")
+	assert.NotNil(t, decodedGoal)
+	assert.Equal(t, goal.ID(), decodedGoal.ID())
+	assert.Nil(t, decodedGoal.VersionedPkg)
+	assert.True(t, findTestNode(t, decoded, pkgBRun).Pinned)
+	for _, n := range g.AllNodes() {
+		assert.True(t, n.Equal(decoded.Node(n.ID()).(*PkgNode)))
+	}
+	assert.Equal(t, g.Edges().Len(), decoded.Edges().Len())
+
+	// Identical graphs produce identical documents
+	var again bytes.Buffer
+	assert.NoError(t, decoded.WriteJSON(&again))
+	assert.Equal(t, written, again.String())
+}
+
+func TestReadJSONInvalid(t *testing.T) {
+	_, err := ReadJSON(strings.NewReader(`{"Nodes": [{"ID": 1, "State": "Bogus", "Type": "Run"}]}`))
+	assert.Error(t, err)
+
+	_, err = ReadJSON(strings.NewReader(`{"Nodes": [], "Edges": [{"From": 1, "To": 2}]}`))
+	assert.Error(t, err)
+
+	_, err = ReadJSON(strings.NewReader(`not json`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"fmt"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+// Merge imports every node and edge of other into the graph. Run, build, and remote nodes are matched to the
+// existing nodes through the lookup table, and goal nodes are matched by goal name. A matching node which is
+// Equal to the imported one is reused, and edges pointing at it are rewired to the existing node. A matching node
+// which differs (ie different paths or state) is a conflict. Meta and 'PreBuilt' nodes are always imported as
+// new nodes. Edge constraints are imported unless the graph already has one for the same edge.
+// Imported nodes are given new IDs, other is not modified. Conflicts are detected before the graph is modified,
+// and any later failure rolls back the imported nodes, edges and constraints, so on error the graph is left untouched.
+func (g *PkgGraph) Merge(other *PkgGraph) (err error) {
+	// Make sure the lookup table is initialized before we start (otherwise it will try to 'fix' orphaned build nodes by removing them)
+	g.lookupTable()
+
+	otherNodes := other.AllNodes()
+	sortNodesByID(otherNodes)
+
+	mergedNodes := make(map[int64]*PkgNode, len(otherNodes))
+	for _, n := range otherNodes {
+		var existingNode *PkgNode
+		existingNode, err = g.mergeCandidate(n)
+		if err != nil {
+			return
+		}
+		if existingNode == nil {
+			continue
+		}
+		if !existingNode.Equal(n) {
+			err = fmt.Errorf("can't merge graphs, conflicting nodes for '%s': %s and %s", n.FriendlyName(), existingNode, n)
+			return
+		}
+		mergedNodes[n.ID()] = existingNode
+	}
+
+	// Undo the partial merge on failure, this runs after the recover below has set err
+	var (
+		addedNodes       []*PkgNode
+		addedEdges       []edgeID
+		addedConstraints []edgeID
+	)
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, id := range addedEdges {
+			g.RemoveEdge(id.from, id.to)
+		}
+		for _, id := range addedConstraints {
+			delete(g.edgeConstraints, id)
+		}
+		for i := len(addedNodes) - 1; i >= 0; i-- {
+			if addedNodes[i].VersionedPkg != nil {
+				g.RemovePkgNode(addedNodes[i])
+			} else {
+				g.RemoveNode(addedNodes[i].ID())
+			}
+		}
+	}()
+
+	// graph manipulation calls may panic on error (such as duplicate node IDs)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to merge graphs: %s", r)
+		}
+	}()
+
+	// Import run and remote nodes first, the lookup table expects them to be present before their build nodes
+	touchedPackages := make(map[string]bool)
+	for _, runPass := range []bool{true, false} {
+		for _, n := range otherNodes {
+			isRunNode := n.Type == TypeRun || n.Type == TypeRemote
+			if isRunNode != runPass || mergedNodes[n.ID()] != nil {
+				continue
+			}
+
+			newNode := g.CloneNode(n)
+			newNode.GoalName = n.GoalName
+			g.AddNode(newNode)
+			addedNodes = append(addedNodes, newNode)
+
+			err = g.addToLookup(newNode, true)
+			if err != nil {
+				return
+			}
+			if newNode.VersionedPkg != nil {
+				touchedPackages[newNode.VersionedPkg.Name] = true
+			}
+			mergedNodes[n.ID()] = newNode
+		}
+	}
+	for pkgName := range touchedPackages {
+		g.sortLookupBucket(g.lookupTable()[pkgName])
+	}
+
+	for _, edge := range graph.EdgesOf(other.Edges()) {
+		otherFrom := edge.From().(*PkgNode).This
+		otherTo := edge.To().(*PkgNode).This
+		from := mergedNodes[otherFrom.ID()]
+		to := mergedNodes[otherTo.ID()]
+
+		if !g.HasEdgeFromTo(from.ID(), to.ID()) {
+			g.SetEdge(g.NewEdge(from, to))
+			addedEdges = append(addedEdges, edgeID{from: from.ID(), to: to.ID()})
+		}
+
+		constraint := other.EdgeConstraint(otherFrom, otherTo)
+		if constraint != nil && g.EdgeConstraint(from, to) == nil {
+			err = g.SetEdgeConstraint(from, to, constraint)
+			if err != nil {
+				return
+			}
+			addedConstraints = append(addedConstraints, edgeID{from: from.ID(), to: to.ID()})
+		}
+	}
+
+	return
+}
+
+// mergeCandidate returns the node of the graph an imported node would be merged into, if any.
+func (g *PkgGraph) mergeCandidate(n *PkgNode) (existingNode *PkgNode, err error) {
+	switch n.Type {
+	case TypeRun, TypeRemote, TypeBuild:
+		var lookupEntry *LookupNode
+		lookupEntry, err = g.FindExactPkgNodeFromPkg(n.VersionedPkg)
+		if err != nil || lookupEntry == nil {
+			return
+		}
+		if n.Type == TypeBuild {
+			existingNode = lookupEntry.BuildNode
+		} else {
+			existingNode = lookupEntry.RunNode
+		}
+	case TypeGoal:
+		existingNode = g.FindGoalNode(n.GoalName)
+	}
+
+	return
+}
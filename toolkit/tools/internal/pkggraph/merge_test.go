@@ -0,0 +1,114 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildMergeGraphHelper builds a graph sharing C with the test graph, and adding a new package E built with C
+func buildMergeGraphHelper(t *testing.T) (g *PkgGraph, pkgERun, pkgEBuild *PkgNode) {
+	pkgE := pkgjson.PackageVer{Name: "E", Version: "5"}
+	pkgERun = buildRunNodeHelper(&pkgE)
+	pkgEBuild = buildBuildNodeHelper(&pkgE)
+
+	g = NewPkgGraph()
+	err := addNodesHelper(g, []*PkgNode{pkgCRun, pkgCBuild, pkgD3Unresolved, pkgERun, pkgEBuild})
+	assert.NoError(t, err)
+	for _, edgePair := range [][]*PkgNode{
+		{pkgCRun, pkgCBuild},
+		{pkgCRun, pkgD3Unresolved},
+		{pkgERun, pkgEBuild},
+		{pkgEBuild, pkgCRun},
+	} {
+		err = addEdgeHelper(g, *edgePair[0], *edgePair[1])
+		assert.NoError(t, err)
+	}
+
+	return
+}
+
+func TestMerge(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	other, pkgERun, pkgEBuild := buildMergeGraphHelper(t)
+	otherEBuild := findTestNode(t, other, pkgEBuild)
+	otherCRun := findTestNode(t, other, pkgCRun)
+	constraint := &pkgjson.PackageVer{Name: "C", Condition: ">=", Version: "3"}
+	assert.NoError(t, other.SetEdgeConstraint(otherEBuild, otherCRun, constraint))
+
+	err = g.Merge(other)
+	assert.NoError(t, err)
+
+	// Only E is new, and its build node depends on the existing C run node
+	assert.Equal(t, len(allNodes)+2, len(g.AllNodes()))
+	assert.Equal(t, 12+2, g.Edges().Len())
+	eRun := findTestNode(t, g, pkgERun)
+	eBuild := findTestNode(t, g, pkgEBuild)
+	cRun := findTestNode(t, g, pkgCRun)
+	assert.True(t, g.HasEdgeFromTo(eRun.ID(), eBuild.ID()))
+	assert.True(t, g.HasEdgeFromTo(eBuild.ID(), cRun.ID()))
+	assert.Equal(t, constraint, g.EdgeConstraint(eBuild, cRun))
+
+	// The source graph is untouched
+	assert.Equal(t, 5, len(other.AllNodes()))
+}
+
+func TestMergeGoalNode(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	other, _, _ := buildMergeGraphHelper(t)
+
+	_, err = g.AddGoalNode("test", []*pkgjson.PackageVer{&pkgC}, true)
+	assert.NoError(t, err)
+	_, err = other.AddGoalNode("test", []*pkgjson.PackageVer{&pkgC}, true)
+	assert.NoError(t, err)
+
+	err = g.Merge(other)
+	assert.NoError(t, err)
+	// The goal nodes are merged, only E is new
+	assert.Equal(t, len(allNodes)+3, len(g.AllNodes()))
+	assert.Equal(t, 1, g.Stats().GoalNodes)
+	assert.Equal(t, 1, g.From(g.FindGoalNode("test").ID()).Len())
+}
+
+func TestMergeConflict(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	other, _, _ := buildMergeGraphHelper(t)
+
+	findTestNode(t, other, pkgCRun).RpmPath = "other.rpm"
+
+	err = g.Merge(other)
+	assert.Error(t, err)
+	checkTestGraph(t, g)
+	assert.Equal(t, 12, g.Edges().Len())
+}
+
+func TestMergeRollback(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	other, _, pkgEBuild := buildMergeGraphHelper(t)
+
+	// A second build node for E is only caught once the first one has been imported
+	other.AddNode(other.CloneNode(findTestNode(t, other, pkgEBuild)))
+
+	err = g.Merge(other)
+	assert.Error(t, err)
+	checkTestGraph(t, g)
+	assert.Equal(t, 12, g.Edges().Len())
+	lookupEntry, err := g.FindExactPkgNodeFromPkg(pkgEBuild.VersionedPkg)
+	assert.NoError(t, err)
+	assert.Nil(t, lookupEntry)
+	assert.Empty(t, g.NodesBySRPM(pkgEBuild.SrpmPath))
+
+	// The graph is still usable for a valid merge
+	other, _, _ = buildMergeGraphHelper(t)
+	assert.NoError(t, g.Merge(other))
+	assert.Equal(t, len(allNodes)+2, len(g.AllNodes()))
+}
@@ -5,8 +5,10 @@ package pkggraph
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -15,6 +17,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/file"
 	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/logger"
@@ -24,6 +27,7 @@ import (
 	"gonum.org/v1/gonum/graph"
 	"gonum.org/v1/gonum/graph/encoding"
 	"gonum.org/v1/gonum/graph/encoding/dot"
+	"gonum.org/v1/gonum/graph/iterator"
 	"gonum.org/v1/gonum/graph/simple"
 	"gonum.org/v1/gonum/graph/traverse"
 )
@@ -58,12 +62,51 @@ const (
 	TypeMAX      NodeType = TypePureMeta // Max allowable type
 )
 
+// EdgeKind describes the relationship a dependency edge represents, derived from the types of its endpoints.
+type EdgeKind int
+
+// Valid values for EdgeKind type
+const (
+	EdgeUnknown       EdgeKind = iota     // Unknown relationship
+	EdgeBuildRequires EdgeKind = iota     // A build node requires a package to be available to build
+	EdgeRequires      EdgeKind = iota     // A package requires another package at run time
+	EdgeBuiltBy       EdgeKind = iota     // A run node is produced by its partner build node
+	EdgeGoal          EdgeKind = iota     // A goal node selects a package
+	EdgeMeta          EdgeKind = iota     // An edge to or from a pure meta node
+	EdgeKindMAX       EdgeKind = EdgeMeta // Max allowable edge kind
+)
+
+// CycleFixStrategy indicates how MakeDAG resolves a cycle.
+type CycleFixStrategy int
+
+// Valid values for CycleFixStrategy type
+const (
+	CycleFixIntraSpec CycleFixStrategy = iota // The cycle's interdependencies are replaced by a new meta node
+	CycleFixPrebuilt  CycleFixStrategy = iota // Build edges into the cycle are redirected to a new 'PreBuilt' node
+	CycleFixCustom    CycleFixStrategy = iota // The cycle is fixed by a resolver passed to MakeDAGWithResolvers
+)
+
+// CycleResolver attempts to fix a cycle in the graph, returning whether it did. The cycle has the first node
+// repeated as the last one, as returned by FindAnyDirectedCycle. A resolver which can't fix the cycle should leave
+// the graph unchanged and may return an error explaining why, which is reported if no other resolver fixes it.
+type CycleResolver func(g *PkgGraph, cycle []*PkgNode) (fixed bool, err error)
+
+// CycleFixAction describes a single cycle fix performed (or planned) by MakeDAG.
+type CycleFixAction struct {
+	Cycle     []*PkgNode       // The cycle being fixed, with the first node repeated as the last
+	Strategy  CycleFixStrategy // How the cycle is fixed
+	Resolver  int              // The index of the resolver which fixes the cycle
+	AddedNode *PkgNode         // The meta or 'PreBuilt' node added to fix the cycle, if any
+}
+
 // Dot encoding/decoding keys
 const (
 	dotKeyNodeInBase64 = "NodeInBase64"
 	dotKeySRPM         = "SRPM"
 	dotKeyColor        = "fillcolor"
 	dotKeyFill         = "style"
+
+	dotKeyConstraintInBase64 = "ConstraintInBase64"
 )
 
 // PkgNode represents a package.
@@ -80,7 +123,10 @@ type PkgNode struct {
 	SourceRepo   string              // The location this package was acquired from
 	GoalName     string              // Optional string for goal nodes
 	Implicit     bool                // If the package is an implicit provide
+	Pinned       bool                // If set, cycle fixes will not modify the node or the edges leading to it
 	This         *PkgNode            // Self reference since the graph library returns nodes by value, not reference
+
+	dotSRPM *string // Plaintext SRPM attribute read from a DOT file, see PreferDOTPlaintextAttributes
 }
 
 // ID implements the graph.Node interface, returns the node's unique ID
@@ -91,17 +137,77 @@ func (n PkgNode) ID() int64 {
 //PkgGraph implements a simple.DirectedGraph using pkggraph Nodes.
 type PkgGraph struct {
 	*simple.DirectedGraph
-	nodeLookup map[string][]*LookupNode
+	nodeLookup        map[string][]*LookupNode
+	srpmIndex         map[string][]*PkgNode
+	rpmIndex          map[string][]*PkgNode
+	edgeConstraints   map[edgeID]*pkgjson.PackageVer
+	edgeWeights       map[edgeID]float64
+	versionComparator func(a, b *pkgjson.PackageVer) int
+
+	// The lookup table is built lazily, possibly by several readers at once. lookupMutex serializes building it
+	// and lookupReady is set once nodeLookup, srpmIndex, and rpmIndex are safe to read.
+	lookupMutex sync.Mutex
+	lookupReady uint32
+}
+
+// edgeID uniquely identifies a directed edge by the IDs of its endpoints.
+type edgeID struct {
+	from, to int64
 }
 
 //LookupNode represents a graph node for a package in the lookup list
 type LookupNode struct {
 	RunNode   *PkgNode // The "meta" run node for a package. Tracks the run-time dependencies for the package. Remote packages will only have a RunNode.
 	BuildNode *PkgNode // The build node for a package. Tracks the build requirements for the package. May be nil for remote packages.
+
+	interval    pkgjson.PackageVerInterval // Cached version interval of the run node's package
+	intervalErr error                      // Error from computing the cached interval, if any
+	intervalPkg *pkgjson.PackageVer        // The package the cached interval was computed from
+}
+
+// RunInterval returns the version interval of the entry's run node. The interval is cached when the run node is
+// added to the lookup table so finders and comparators don't need to re-parse the version. If the run node's
+// VersionedPkg was replaced since then the interval is recomputed, call RefreshLookupForNode to update the cache.
+// Modifying the fields of the VersionedPkg in place is not detected.
+func (l *LookupNode) RunInterval() (interval pkgjson.PackageVerInterval, err error) {
+	if l.RunNode == nil {
+		err = fmt.Errorf("lookup entry has no run node")
+		return
+	}
+
+	// The cache is only written while the lookup table is being modified, so concurrent readers holding a read
+	// lock on the graph never race on it.
+	if l.intervalPkg != nil && l.intervalPkg == l.RunNode.VersionedPkg {
+		return l.interval, l.intervalErr
+	}
+	return l.RunNode.VersionedPkg.Interval()
+}
+
+// cacheRunInterval computes and stores the version interval of the entry's run node.
+func (l *LookupNode) cacheRunInterval() {
+	l.intervalPkg = l.RunNode.VersionedPkg
+	l.interval, l.intervalErr = l.intervalPkg.Interval()
 }
 
 var (
 	registerOnce sync.Once
+
+	// DOTColorFunc, if set, picks the color of each node in DOT output instead of DOTColor. It allows using a
+	// different palette (ie one friendly to colorblind readers). It must be set before any graph is written.
+	DOTColorFunc func(n *PkgNode) string
+
+	// PreferDOTPlaintextAttributes, if set, makes the plaintext SRPM attribute of a node in a DOT file override the
+	// SRPM path stored in its base64 encoded blob, so DOT files can be edited by hand. By default the blob is
+	// authoritative and the plaintext attributes are ignored. The color and style attributes are always derived from
+	// the node and are ignored either way. It must be set before any graph is read.
+	PreferDOTPlaintextAttributes bool
+
+	errPinnedNode = errors.New("cycle can't be fixed without modifying a pinned node")
+
+	// ErrSelfLoop is returned by AddEdge when asked to make a node depend on itself.
+	ErrSelfLoop = errors.New("a node can't depend on itself")
+	// ErrDuplicateEdge is returned by AddEdge when the edge already exists.
+	ErrDuplicateEdge = errors.New("edge already exists")
 )
 
 func (n NodeState) String() string {
@@ -144,6 +250,63 @@ func (n NodeType) String() string {
 	}
 }
 
+// ParseNodeState converts the name of a state, as returned by NodeState.String, back into a NodeState.
+// Returns an error if the name doesn't belong to any valid state.
+func ParseNodeState(name string) (state NodeState, err error) {
+	for state = StateUnknown + 1; state <= StateMAX; state++ {
+		if state.String() == name {
+			return
+		}
+	}
+	return StateUnknown, fmt.Errorf("unknown node state '%s'", name)
+}
+
+// ParseNodeType converts the name of a type, as returned by NodeType.String, back into a NodeType.
+// Returns an error if the name doesn't belong to any valid type.
+func ParseNodeType(name string) (nodeType NodeType, err error) {
+	for nodeType = TypeUnknown + 1; nodeType <= TypePreBuilt; nodeType++ {
+		if nodeType.String() == name {
+			return
+		}
+	}
+	return TypeUnknown, fmt.Errorf("unknown node type '%s'", name)
+}
+
+func (k EdgeKind) String() string {
+	switch k {
+	case EdgeBuildRequires:
+		return "BuildRequires"
+	case EdgeRequires:
+		return "Requires"
+	case EdgeBuiltBy:
+		return "BuiltBy"
+	case EdgeGoal:
+		return "Goal"
+	case EdgeMeta:
+		return "Meta"
+	default:
+		return "Unknown"
+	}
+}
+
+// EdgeKindOf returns the kind of relationship an edge from -> to represents.
+func EdgeKindOf(from *PkgNode, to *PkgNode) EdgeKind {
+	switch {
+	case from.Type == TypeGoal:
+		return EdgeGoal
+	case from.Type == TypePureMeta || to.Type == TypePureMeta:
+		return EdgeMeta
+	case from.Type == TypeBuild:
+		return EdgeBuildRequires
+	case from.Type == TypeRun && to.Type == TypeBuild:
+		return EdgeBuiltBy
+	case from.Type == TypeRun || from.Type == TypeRemote || from.Type == TypePreBuilt:
+		return EdgeRequires
+	default:
+		return EdgeUnknown
+	}
+}
+
 //DOTColor returns the graphviz color to set a node to
 func (n *PkgNode) DOTColor() string {
 	switch n.State {
@@ -171,6 +334,15 @@ func (n *PkgNode) DOTColor() string {
 	}
 }
 
+// outputColor returns the color to use for the node when writing it out: the one picked by DOTColorFunc if set,
+// otherwise DOTColor.
+func (n *PkgNode) outputColor() string {
+	if DOTColorFunc != nil {
+		return DOTColorFunc(n)
+	}
+	return n.DOTColor()
+}
+
 // NewPkgGraph creates a new package dependency graph based on a simple.DirectedGraph
 func NewPkgGraph() *PkgGraph {
 	g := &PkgGraph{DirectedGraph: simple.NewDirectedGraph()}
@@ -180,9 +352,37 @@ func NewPkgGraph() *PkgGraph {
 	return g
 }
 
-// initLookup initializes the run and build node lookup table
+// initLookup initializes the run and build node lookup table. The table is built on a separate view of the graph
+// and only published once complete, since building it looks up nodes in the partial table. The view shares the
+// graph's edge data so removing orphaned build nodes also forgets the data of their edges.
 func (g *PkgGraph) initLookup() {
-	g.nodeLookup = make(map[string][]*LookupNode)
+	if g.edgeConstraints == nil {
+		g.edgeConstraints = make(map[edgeID]*pkgjson.PackageVer)
+	}
+	if g.edgeWeights == nil {
+		g.edgeWeights = make(map[edgeID]float64)
+	}
+
+	builder := &PkgGraph{
+		DirectedGraph:     g.DirectedGraph,
+		nodeLookup:        make(map[string][]*LookupNode),
+		srpmIndex:         make(map[string][]*PkgNode),
+		rpmIndex:          make(map[string][]*PkgNode),
+		edgeConstraints:   g.edgeConstraints,
+		edgeWeights:       g.edgeWeights,
+		versionComparator: g.versionComparator,
+		lookupReady:       1,
+	}
+	builder.buildLookup()
+
+	g.nodeLookup = builder.nodeLookup
+	g.srpmIndex = builder.srpmIndex
+	g.rpmIndex = builder.rpmIndex
+	atomic.StoreUint32(&g.lookupReady, 1)
+}
+
+// buildLookup fills in the empty lookup table with every node in the graph.
+func (g *PkgGraph) buildLookup() {
 
 	// Scan all nodes, start with only the run nodes to properly initialize the lookup structures
 	// (they always expect a run node to be present)
@@ -215,22 +415,74 @@ func (g *PkgGraph) initLookup() {
 			} else {
 				logger.Log.Debugf("Lookup for %s has no run node, lost in a cycle fix? Removing it", idx)
 				g.RemoveNode(n.BuildNode.ID())
+				g.removeFromPathIndexes(n.BuildNode)
 			}
 		}
 		// Prune off the invalid entries at the end of the slice
 		g.nodeLookup[idx] = g.nodeLookup[idx][:endOfValidData]
 
-		sort.Slice(g.nodeLookup[idx], func(i, j int) bool {
-			intervalI, _ := g.nodeLookup[idx][i].RunNode.VersionedPkg.Interval()
-			intervalJ, _ := g.nodeLookup[idx][j].RunNode.VersionedPkg.Interval()
-			return intervalI.Compare(&intervalJ) < 0
-		})
+		g.sortLookupBucket(g.nodeLookup[idx])
 	}
 }
 
+// SetVersionComparator overrides how the versions of nodes sharing a package name are ordered in the lookup table.
+// The comparator must return a negative value if a is older than b, a positive value if it is newer, and 0 otherwise.
+// Since lookups prefer the highest version which satisfies a request, this also decides which node FindBestPkgNode
+// returns when several match. Passing nil restores the default PackageVerInterval comparison.
+// The comparator is not serialized with the graph.
+func (g *PkgGraph) SetVersionComparator(comparator func(a, b *pkgjson.PackageVer) int) {
+	g.versionComparator = comparator
+
+	// Re-sort any existing lookup lists, otherwise they will be sorted when the table is initialized
+	if g.nodeLookup != nil {
+		for _, lookupBucket := range g.nodeLookup {
+			g.sortLookupBucket(lookupBucket)
+		}
+	}
+}
+
+// compareVersions compares two packages using the comparator set by SetVersionComparator, or by their version
+// intervals if none was set.
+func (g *PkgGraph) compareVersions(a, b *pkgjson.PackageVer) int {
+	if g.versionComparator != nil {
+		return g.versionComparator(a, b)
+	}
+
+	intervalA, _ := a.Interval()
+	intervalB, _ := b.Interval()
+	return intervalA.Compare(&intervalB)
+}
+
+// compareLookupVersions compares the run nodes of two lookup entries like compareVersions, reusing the entries'
+// cached intervals if no comparator was set.
+func (g *PkgGraph) compareLookupVersions(a, b *LookupNode) int {
+	if g.versionComparator != nil {
+		return g.versionComparator(a.RunNode.VersionedPkg, b.RunNode.VersionedPkg)
+	}
+
+	intervalA, _ := a.RunInterval()
+	intervalB, _ := b.RunInterval()
+	return intervalA.Compare(&intervalB)
+}
+
+// sortLookupBucket sorts the lookup entries for a single package name from lowest version to highest version.
+func (g *PkgGraph) sortLookupBucket(lookupBucket []*LookupNode) {
+	sort.SliceStable(lookupBucket, func(i, j int) bool {
+		return g.compareLookupVersions(lookupBucket[i], lookupBucket[j]) < 0
+	})
+}
+
 // lookupTable returns a reference to the lookup table, initialzing it first if needed.
+// Concurrent readers may call this safely, the table is only ever initialized once. Note that initializing the
+// table removes build nodes without a run node from the graph.
 func (g *PkgGraph) lookupTable() map[string][]*LookupNode {
-	if g.nodeLookup == nil {
+	if atomic.LoadUint32(&g.lookupReady) == 1 {
+		return g.nodeLookup
+	}
+
+	g.lookupMutex.Lock()
+	defer g.lookupMutex.Unlock()
+	if atomic.LoadUint32(&g.lookupReady) == 0 {
 		g.initLookup()
 	}
 	return g.nodeLookup
@@ -269,6 +521,17 @@ func (g *PkgGraph) validateNodeForLookup(pkgNode *PkgNode) (valid bool, err erro
 		}
 	}
 
+	err = validateLookupVersion(pkgNode)
+	if err != nil {
+		return
+	}
+
+	valid = true
+	return
+}
+
+// validateLookupVersion checks if the version of a node is valid for adding to the lookup table.
+func validateLookupVersion(pkgNode *PkgNode) (err error) {
 	// Make sure we have a valid version.
 	versionInterval, err := pkgNode.VersionedPkg.Interval()
 	if err != nil {
@@ -289,7 +552,6 @@ func (g *PkgGraph) validateNodeForLookup(pkgNode *PkgNode) (valid bool, err erro
 		}
 	}
 
-	valid = true
 	return
 }
 
@@ -324,7 +586,7 @@ func (g *PkgGraph) addToLookup(pkgNode *PkgNode, deferSort bool) (err error) {
 			err = fmt.Errorf("can't add %s, no corresponding run node found and not defering sort", pkgNode)
 			return
 		}
-		existingLookup = &LookupNode{nil, nil}
+		existingLookup = &LookupNode{}
 		g.lookupTable()[pkgName] = append(g.lookupTable()[pkgName], existingLookup)
 	}
 
@@ -342,27 +604,204 @@ func (g *PkgGraph) addToLookup(pkgNode *PkgNode, deferSort bool) (err error) {
 	case TypeRun:
 		if existingLookup.RunNode == nil {
 			existingLookup.RunNode = pkgNode.This
+			existingLookup.cacheRunInterval()
 		} else {
 			err = duplicateError
 			return
 		}
 	}
 
+	g.srpmIndex[pkgNode.SrpmPath] = append(g.srpmIndex[pkgNode.SrpmPath], pkgNode.This)
+	if hasRPMIndexEntry(pkgNode) {
+		g.rpmIndex[pkgNode.RpmPath] = append(g.rpmIndex[pkgNode.RpmPath], pkgNode.This)
+	}
+
 	// Sort the updated list unless we are defering until all nodes are added
 	if !deferSort {
-		sort.Slice(g.lookupTable()[pkgName], func(i, j int) bool {
-			intervalI, _ := g.lookupTable()[pkgName][i].RunNode.VersionedPkg.Interval()
-			intervalJ, _ := g.lookupTable()[pkgName][j].RunNode.VersionedPkg.Interval()
-			return intervalI.Compare(&intervalJ) < 0
-		})
+		g.sortLookupBucket(g.lookupTable()[pkgName])
 	}
 	return
 }
 
+// RefreshLookupForNode brings the lookup entries for the package name of pkgNode back in sync with the graph,
+// without rebuilding the whole lookup table. Entries referring to nodes which are no longer part of the graph are
+// dropped, pkgNode is re-validated and re-recorded (so a changed version is picked up), and the entries for the
+// name are re-sorted. Only the lookup entries for pkgNode's current package name are refreshed.
+// Returns an error if pkgNode can't be recorded, or if a build node is left without a run node. Unlike the full
+// lookup initialization, such build nodes are not removed from the graph.
+func (g *PkgGraph) RefreshLookupForNode(pkgNode *PkgNode) (err error) {
+	if pkgNode == nil || pkgNode.VersionedPkg == nil {
+		err = fmt.Errorf("can't refresh the lookup table for a node without package information")
+		return
+	}
+
+	pkgName := pkgNode.VersionedPkg.Name
+	inGraph := func(n *PkgNode) bool {
+		graphNode := g.Node(n.ID())
+		return graphNode != nil && graphNode.(*PkgNode).This == n
+	}
+
+	var (
+		refreshedBucket []*LookupNode
+		orphanedBuilds  []*PkgNode
+	)
+	for _, entry := range g.lookupTable()[pkgName] {
+		for _, slot := range []**PkgNode{&entry.RunNode, &entry.BuildNode} {
+			if *slot != nil && (*slot == pkgNode.This || !inGraph(*slot)) {
+				g.removeFromPathIndexes(*slot)
+				*slot = nil
+			}
+		}
+
+		switch {
+		case entry.RunNode != nil:
+			refreshedBucket = append(refreshedBucket, entry)
+		case entry.BuildNode != nil:
+			// Remove the build node for now, it will be re-attached if its run node is still around
+			g.removeFromPathIndexes(entry.BuildNode)
+			orphanedBuilds = append(orphanedBuilds, entry.BuildNode)
+		}
+	}
+	g.sortLookupBucket(refreshedBucket)
+	g.nodeLookup[pkgName] = refreshedBucket
+
+	if inGraph(pkgNode.This) && (pkgNode.Type == TypeRun || pkgNode.Type == TypeRemote) {
+		err = g.addToLookup(pkgNode.This, false)
+		if err != nil {
+			return
+		}
+	}
+
+	// Build nodes can only be recorded once their run node is
+	if inGraph(pkgNode.This) && pkgNode.Type == TypeBuild {
+		orphanedBuilds = append(orphanedBuilds, pkgNode.This)
+	}
+	for _, buildNode := range orphanedBuilds {
+		err = g.addToLookup(buildNode, false)
+		if err != nil {
+			err = fmt.Errorf("build node %s has no run node: %w", buildNode, err)
+			return
+		}
+	}
+
+	if len(g.nodeLookup[pkgName]) == 0 {
+		delete(g.nodeLookup, pkgName)
+	}
+
+	return
+}
+
+// UpdateNodeVersion replaces the VersionedPkg of a node (and updates Implicit to match) while keeping the lookup
+// table consistent. A run or remote node is moved to the lookup entry for its new version along with the build node
+// paired with it, which is given the same version since both come from the same spec. A build node on its own can
+// only move to an entry whose run node already has the new version.
+// If the new version can't be recorded (ie it duplicates an existing lookup entry) the old version is restored and
+// an error is returned.
+func (g *PkgGraph) UpdateNodeVersion(pkgNode *PkgNode, newVer *pkgjson.PackageVer) (err error) {
+	if pkgNode == nil || newVer == nil {
+		err = fmt.Errorf("can't update the version of a node without package information")
+		return
+	}
+	graphNode := g.Node(pkgNode.ID())
+	if graphNode == nil || graphNode.(*PkgNode).This != pkgNode.This {
+		err = fmt.Errorf("can't update the version of %s, it is not part of the graph", pkgNode.FriendlyName())
+		return
+	}
+	pkgNode = pkgNode.This
+
+	// Nodes outside the lookup table can be updated directly
+	if pkgNode.Type != TypeBuild && pkgNode.Type != TypeRun && pkgNode.Type != TypeRemote {
+		pkgNode.VersionedPkg = newVer
+		pkgNode.Implicit = newVer.IsImplicitPackage()
+		return
+	}
+
+	movedNodes := g.detachFromLookup(pkgNode)
+	if len(movedNodes) == 0 {
+		err = fmt.Errorf("can't update the version of %s, it has no lookup entry", pkgNode.FriendlyName())
+		return
+	}
+
+	oldVer := pkgNode.VersionedPkg
+	setVersion := func(versionedPkg *pkgjson.PackageVer) {
+		for _, n := range movedNodes {
+			n.VersionedPkg = versionedPkg
+			n.Implicit = versionedPkg.IsImplicitPackage()
+		}
+	}
+
+	setVersion(newVer)
+	for i, n := range movedNodes {
+		err = g.addToLookup(n, false)
+		if err != nil {
+			err = fmt.Errorf("failed to update the version of %s to %s:\n%w", pkgNode.FriendlyName(), newVer, err)
+
+			// Undo any partial move and put the nodes back where they were
+			for j := i - 1; j >= 0; j-- {
+				g.detachFromLookup(movedNodes[j])
+			}
+			setVersion(oldVer)
+			for _, original := range movedNodes {
+				restoreErr := g.addToLookup(original, false)
+				if restoreErr != nil {
+					logger.Log.Errorf("Failed to restore the lookup entry for %s: %s", original.FriendlyName(), restoreErr)
+				}
+			}
+			return
+		}
+	}
+
+	return
+}
+
+// detachFromLookup removes a node from its lookup entry and the SRPM index, and returns the nodes which were
+// detached. Detaching a run or remote node removes its whole entry, so the paired build node (if any) is detached
+// and returned after it. Entries left empty are dropped.
+func (g *PkgGraph) detachFromLookup(pkgNode *PkgNode) (detached []*PkgNode) {
+	pkgName := pkgNode.VersionedPkg.Name
+	lookupBucket := g.lookupTable()[pkgName]
+
+	for i, entry := range lookupBucket {
+		switch pkgNode.This {
+		case entry.RunNode:
+			detached = append(detached, entry.RunNode)
+			if entry.BuildNode != nil {
+				detached = append(detached, entry.BuildNode)
+			}
+			g.lookupTable()[pkgName] = append(lookupBucket[:i], lookupBucket[i+1:]...)
+		case entry.BuildNode:
+			detached = append(detached, entry.BuildNode)
+			entry.BuildNode = nil
+		default:
+			continue
+		}
+		break
+	}
+
+	for _, n := range detached {
+		g.removeFromPathIndexes(n)
+	}
+	if len(g.lookupTable()[pkgName]) == 0 {
+		delete(g.lookupTable(), pkgName)
+	}
+
+	return
+}
+
 // AddEdge creates a new edge between the provided nodes.
+// Returns an error wrapping ErrSelfLoop if both nodes are the same, or ErrDuplicateEdge if the edge already exists.
 func (g *PkgGraph) AddEdge(from *PkgNode, to *PkgNode) (err error) {
 	logger.Log.Tracef("Adding edge: %s -> %s", from.FriendlyName(), to.FriendlyName())
 
+	if from.ID() == to.ID() {
+		err = fmt.Errorf("failed to add edge for '%s': %w", from.FriendlyName(), ErrSelfLoop)
+		return
+	}
+	if g.HasEdgeFromTo(from.ID(), to.ID()) {
+		err = fmt.Errorf("failed to add edge '%s' -> '%s': %w", from.FriendlyName(), to.FriendlyName(), ErrDuplicateEdge)
+		return
+	}
+
 	newEdge := g.NewEdge(from, to)
 	defer func() {
 		if r := recover(); r != nil {
@@ -374,6 +813,85 @@ func (g *PkgGraph) AddEdge(from *PkgNode, to *PkgNode) (err error) {
 	return
 }
 
+// AddEdges adds a batch of edges, each a (from, to) pair, as a single transaction. Every pair is validated before
+// any edge is added: both nodes must be part of the graph, and the edge must not be a self loop, already exist, or
+// be repeated in the batch. All problems are reported together in one error and the graph is left unchanged. If
+// adding the edges fails part way, the edges already added are removed again.
+func (g *PkgGraph) AddEdges(edges [][2]*PkgNode) (err error) {
+	var problems []string
+
+	inGraph := func(n *PkgNode) bool {
+		if n == nil {
+			return false
+		}
+		graphNode := g.Node(n.ID())
+		return graphNode != nil && graphNode.(*PkgNode).This == n.This
+	}
+
+	batch := make(map[edgeID]bool, len(edges))
+	for i, edge := range edges {
+		from, to := edge[0], edge[1]
+		if !inGraph(from) || !inGraph(to) {
+			problems = append(problems, fmt.Sprintf("edge %d references a node which is not part of the graph", i))
+			continue
+		}
+
+		id := edgeID{from: from.ID(), to: to.ID()}
+		switch {
+		case from.ID() == to.ID():
+			problems = append(problems, fmt.Sprintf("edge %d for '%s': %s", i, from.FriendlyName(), ErrSelfLoop))
+		case g.HasEdgeFromTo(from.ID(), to.ID()) || batch[id]:
+			problems = append(problems, fmt.Sprintf("edge %d '%s' -> '%s': %s", i, from.FriendlyName(), to.FriendlyName(), ErrDuplicateEdge))
+		}
+		batch[id] = true
+	}
+
+	if len(problems) > 0 {
+		err = fmt.Errorf("unable to add %d edge(s), found %d problem(s):\n%s", len(edges), len(problems), strings.Join(problems, "\n"))
+		return
+	}
+
+	added := 0
+	defer func() {
+		// graph manipulation calls may panic on error
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to add edges, error: %s", r)
+			for _, edge := range edges[:added] {
+				g.RemoveEdge(edge[0].ID(), edge[1].ID())
+			}
+		}
+	}()
+
+	for _, edge := range edges {
+		logger.Log.Tracef("Adding edge: %s -> %s", edge[0].FriendlyName(), edge[1].FriendlyName())
+		g.SetEdge(g.NewEdge(edge[0].This, edge[1].This))
+		added++
+	}
+
+	return
+}
+
+// SetEdgeConstraint records the versioned requirement which caused the existing edge from -> to to be created.
+// Constraints are written to DOT along with their edge, and are dropped when the edge is removed.
+func (g *PkgGraph) SetEdgeConstraint(from *PkgNode, to *PkgNode, constraint *pkgjson.PackageVer) (err error) {
+	if g.Edge(from.ID(), to.ID()) == nil {
+		err = fmt.Errorf("no edge exists between '%s' and '%s'", from.FriendlyName(), to.FriendlyName())
+		return
+	}
+
+	if g.edgeConstraints == nil {
+		g.edgeConstraints = make(map[edgeID]*pkgjson.PackageVer)
+	}
+	g.edgeConstraints[edgeID{from.ID(), to.ID()}] = constraint
+
+	return
+}
+
+// EdgeConstraint returns the versioned requirement recorded for the edge from -> to, or nil if there is none.
+func (g *PkgGraph) EdgeConstraint(from *PkgNode, to *PkgNode) *pkgjson.PackageVer {
+	return g.edgeConstraints[edgeID{from.ID(), to.ID()}]
+}
+
 // NewNode creates a new pkggraph Node for the graph
 func (g *PkgGraph) NewNode() graph.Node {
 	node := g.DirectedGraph.NewNode()
@@ -382,6 +900,101 @@ func (g *PkgGraph) NewNode() graph.Node {
 	return pkgNode
 }
 
+// dependencyEdge is the edge type stored in a PkgGraph. It gives the DOT encoder and decoder access to the
+// constraint recorded for the edge (see SetEdgeConstraint).
+type dependencyEdge struct {
+	simple.Edge
+	g *PkgGraph
+}
+
+// NewEdge creates a new edge for the graph, which can be added with SetEdge.
+func (g *PkgGraph) NewEdge(from, to graph.Node) graph.Edge {
+	return &dependencyEdge{Edge: simple.Edge{F: from, T: to}, g: g}
+}
+
+// SetEdge adds an edge to the graph. Edges created by other graphs are converted to this graph's edge type.
+func (g *PkgGraph) SetEdge(e graph.Edge) {
+	if edge, isOwnEdge := e.(*dependencyEdge); !isOwnEdge || edge.g != g {
+		e = g.NewEdge(e.From(), e.To())
+	}
+	g.DirectedGraph.SetEdge(e)
+}
+
+// RemoveEdge removes the edge from -> to, along with the constraint and weight recorded for it.
+func (g *PkgGraph) RemoveEdge(fid, tid int64) {
+	g.DirectedGraph.RemoveEdge(fid, tid)
+	g.forgetEdge(edgeID{from: fid, to: tid})
+}
+
+// RemoveNode removes a node along with all of its edges and the constraints and weights recorded for them. The
+// graph may hand the ID of a removed node out again, so nothing recorded for the old node can be left behind.
+func (g *PkgGraph) RemoveNode(id int64) {
+	for _, dependency := range graph.NodesOf(g.From(id)) {
+		g.forgetEdge(edgeID{from: id, to: dependency.ID()})
+	}
+	for _, dependent := range graph.NodesOf(g.To(id)) {
+		g.forgetEdge(edgeID{from: dependent.ID(), to: id})
+	}
+	g.DirectedGraph.RemoveNode(id)
+}
+
+// forgetEdge drops the constraint and weight recorded for an edge.
+func (g *PkgGraph) forgetEdge(id edgeID) {
+	delete(g.edgeConstraints, id)
+	delete(g.edgeWeights, id)
+}
+
+// SetAttribute restores the constraint of an edge when parsing a DOT file.
+func (e *dependencyEdge) SetAttribute(attr encoding.Attribute) (err error) {
+	switch attr.Key {
+	case dotKeyConstraintInBase64:
+		var data []byte
+		data, err = base64.StdEncoding.DecodeString(attr.Value)
+		if err != nil {
+			logger.Log.Errorf("Failed to decode base 64 encoding: %s", err.Error())
+			return
+		}
+
+		constraint := &pkgjson.PackageVer{}
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(constraint)
+		if err != nil {
+			logger.Log.Errorf("Failed to decode gob data: %s", err.Error())
+			return
+		}
+
+		if e.g.edgeConstraints == nil {
+			e.g.edgeConstraints = make(map[edgeID]*pkgjson.PackageVer)
+		}
+		e.g.edgeConstraints[edgeID{from: e.From().ID(), to: e.To().ID()}] = constraint
+	default:
+		logger.Log.Warnf(`Unable to unmarshal an unknown edge key "%s".`, attr.Key)
+	}
+
+	return
+}
+
+// Attributes marshals the constraint recorded for the edge, if any, into a DOT graph structure. The constraint is
+// encoded using base64 and gob.
+func (e *dependencyEdge) Attributes() []encoding.Attribute {
+	constraint := e.g.edgeConstraints[edgeID{from: e.From().ID(), to: e.To().ID()}]
+	if constraint == nil {
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(constraint)
+	if err != nil {
+		logger.Log.Panicf("Error when encoding edge attributes: %s", err.Error())
+	}
+
+	return []encoding.Attribute{
+		{
+			Key:   dotKeyConstraintInBase64,
+			Value: base64.StdEncoding.EncodeToString(buffer.Bytes()),
+		},
+	}
+}
+
 // CreateCollapsedNode creates a new run node linked to a given parent node. All nodes in nodesToCollapse will be collapsed into the new node.
 // - When a node is collapsed all of its dependents will be mirrored onto the new node.
 // - The parentNode must be a run node.
@@ -455,7 +1068,32 @@ func (g *PkgGraph) CreateCollapsedNode(versionedPkg *pkgjson.PackageVer, parentN
 	return
 }
 
+// CollapseMatching behaves like CreateCollapsedNode, but collapses every run and remote node for which match returns
+// true instead of an explicit list. The parent node is never collapsed, and build, goal, and meta nodes are never
+// offered to match. Nodes are collapsed in ID order.
+// Returns an error if no node matches.
+func (g *PkgGraph) CollapseMatching(versionedPkg *pkgjson.PackageVer, parentNode *PkgNode, match func(n *PkgNode) bool) (newNode *PkgNode, err error) {
+	var nodesToCollapse []*PkgNode
+	for _, n := range g.AllNodes() {
+		if n == parentNode.This || (n.Type != TypeRun && n.Type != TypeRemote) {
+			continue
+		}
+		if match(n) {
+			nodesToCollapse = append(nodesToCollapse, n)
+		}
+	}
+
+	if len(nodesToCollapse) == 0 {
+		err = fmt.Errorf("no nodes to collapse into (%s)", versionedPkg)
+		return
+	}
+	sortNodesByID(nodesToCollapse)
+
+	return g.CreateCollapsedNode(versionedPkg, parentNode, nodesToCollapse)
+}
+
 // AddPkgNode adds a new node to the package graph. Run, Build, and Unresolved nodes are recorded in the lookup table.
+// If the node can't be recorded in the lookup table (ie it duplicates an existing node) it is not added to the graph.
 func (g *PkgGraph) AddPkgNode(versionedPkg *pkgjson.PackageVer, nodestate NodeState, nodeType NodeType, srpmPath, rpmPath, specPath, sourceDir, architecture, sourceRepo string) (newNode *PkgNode, err error) {
 	newNode = &PkgNode{
 		nodeID:       g.NewNode().ID(),
@@ -484,6 +1122,11 @@ func (g *PkgGraph) AddPkgNode(versionedPkg *pkgjson.PackageVer, nodestate NodeSt
 
 	// Register the package with the lookup table if needed
 	err = g.addToLookup(newNode, false)
+	if err != nil {
+		// Don't leave a node in the graph which the lookup table doesn't know about
+		g.RemoveNode(newNode.ID())
+		newNode = nil
+	}
 
 	return
 }
@@ -496,50 +1139,93 @@ func (g *PkgGraph) RemovePkgNode(pkgNode *PkgNode) {
 
 // FindDoubleConditionalPkgNodeFromPkg has the same behavior as FindConditionalPkgNodeFromPkg but supports two conditionals
 func (g *PkgGraph) FindDoubleConditionalPkgNodeFromPkg(pkgVer *pkgjson.PackageVer) (lookupEntry *LookupNode, err error) {
+	lookupEntry, bestLocalNode, err := g.findSatisfyingPkgNodes(pkgVer)
+	if err != nil {
+		return
+	}
+
+	// If the pkgVer resolves to a remote node, and that node
+	// is never found during the build, we have no way to
+	// fall back to the local package at this time.
+	if bestLocalNode != nil && bestLocalNode != lookupEntry {
+		logger.Log.Warnf("Resolving '%s' to remote node '%s' instead of local node '%s'", pkgVer, lookupEntry.RunNode.String(), bestLocalNode.RunNode.String())
+	}
+	return
+}
+
+// FindBestLocalPkgNode behaves like FindBestPkgNode, but prefers packages which are built locally: it returns the
+// highest version which satisfies the PackageVer structure and has a build node, and only falls back to a remote
+// node if no local node satisfies it. Returns nil if no lookup entry is found.
+func (g *PkgGraph) FindBestLocalPkgNode(pkgVer *pkgjson.PackageVer) (lookupEntry *LookupNode, err error) {
+	lookupEntry, bestLocalNode, err := g.findSatisfyingPkgNodes(pkgVer)
+	if err != nil {
+		return
+	}
+
+	if bestLocalNode != nil {
+		lookupEntry = bestLocalNode
+	}
+	return
+}
+
+// FindAllSatisfying returns every lookup entry which satisfies the PackageVer structure (both conditionals), sorted
+// from the lowest version to the highest. Returns nil if no lookup entry satisfies it.
+func (g *PkgGraph) FindAllSatisfying(pkgVer *pkgjson.PackageVer) (lookupEntries []*LookupNode, err error) {
 	var (
 		requestInterval, nodeInterval pkgjson.PackageVerInterval
-		bestLocalNode                 *LookupNode
 	)
 	requestInterval, err = pkgVer.Interval()
 	if err != nil {
 		return
 	}
 
-	bestLocalNode = nil
+	// The lookup table keeps each package's entries sorted by version
 	packageNodes := g.lookupTable()[pkgVer.Name]
 	for _, node := range packageNodes {
 		if node.RunNode == nil {
 			err = fmt.Errorf("found orphaned build node '%s' for name '%s'", node.BuildNode, pkgVer.Name)
+			lookupEntries = nil
 			return
 		}
 
-		nodeInterval, err = node.RunNode.VersionedPkg.Interval()
+		nodeInterval, err = node.RunInterval()
 		if err != nil {
+			lookupEntries = nil
 			return
 		}
 
 		if nodeInterval.Satisfies(&requestInterval) {
-			// Only local packages will have a build node
-			if node.BuildNode != nil {
-				bestLocalNode = node
-			}
-			// Keep going, we want the highest version which satisfies both conditionals
-			lookupEntry = node
+			lookupEntries = append(lookupEntries, node)
 		}
 	}
 
-	// If the pkgVer resolves to a remote node, and that node
-	// is never found during the build, we have no way to
-	// fall back to the local package at this time.
-	if bestLocalNode != nil && bestLocalNode != lookupEntry {
-		logger.Log.Warnf("Resolving '%s' to remote node '%s' instead of local node '%s'", pkgVer, lookupEntry.RunNode.String(), bestLocalNode.RunNode.String())
+	return
+}
+
+// findSatisfyingPkgNodes returns the highest version lookup entry which satisfies the PackageVer structure, along
+// with the highest version one which also has a build node.
+func (g *PkgGraph) findSatisfyingPkgNodes(pkgVer *pkgjson.PackageVer) (bestNode, bestLocalNode *LookupNode, err error) {
+	lookupEntries, err := g.FindAllSatisfying(pkgVer)
+	if err != nil {
+		return
 	}
+
+	for _, node := range lookupEntries {
+		// Only local packages will have a build node
+		if node.BuildNode != nil {
+			bestLocalNode = node
+		}
+		// Keep going, we want the highest version which satisfies both conditionals
+		bestNode = node
+	}
+
 	return
 }
 
 // FindExactPkgNodeFromPkg attempts to find a LookupNode which has the exactly
 // correct version information listed in the PackageVer structure. Returns nil
-// if no lookup entry is found.
+// if no lookup entry is found. Returns an error if the entry refers to a node
+// which is no longer part of the graph.
 func (g *PkgGraph) FindExactPkgNodeFromPkg(pkgVer *pkgjson.PackageVer) (lookupEntry *LookupNode, err error) {
 	var (
 		requestInterval, nodeInterval pkgjson.PackageVerInterval
@@ -557,7 +1243,7 @@ func (g *PkgGraph) FindExactPkgNodeFromPkg(pkgVer *pkgjson.PackageVer) (lookupEn
 			return
 		}
 
-		nodeInterval, err = node.RunNode.VersionedPkg.Interval()
+		nodeInterval, err = node.RunInterval()
 		if err != nil {
 			return
 		}
@@ -566,6 +1252,29 @@ func (g *PkgGraph) FindExactPkgNodeFromPkg(pkgVer *pkgjson.PackageVer) (lookupEn
 			lookupEntry = node
 		}
 	}
+
+	if lookupEntry != nil {
+		err = g.checkLookupEntryInGraph(lookupEntry)
+		if err != nil {
+			lookupEntry = nil
+		}
+	}
+	return
+}
+
+// checkLookupEntryInGraph returns an error if either node of a lookup entry is no longer part of the graph, which
+// happens if a node is removed directly from the underlying graph instead of through RemovePkgNode.
+func (g *PkgGraph) checkLookupEntryInGraph(lookupEntry *LookupNode) (err error) {
+	for _, pkgNode := range []*PkgNode{lookupEntry.RunNode, lookupEntry.BuildNode} {
+		if pkgNode == nil {
+			continue
+		}
+		graphNode := g.Node(pkgNode.ID())
+		if graphNode == nil || graphNode.(*PkgNode).This != pkgNode {
+			err = fmt.Errorf("lookup entry for '%s' is stale, node %d is no longer in the graph", pkgNode.FriendlyName(), pkgNode.ID())
+			return
+		}
+	}
 	return
 }
 
@@ -578,6 +1287,71 @@ func (g *PkgGraph) FindBestPkgNode(pkgVer *pkgjson.PackageVer) (lookupEntry *Loo
 	return
 }
 
+// FindLatestPkgNode returns the lookup entry with the highest version for the package name, regardless of any
+// conditionals. Returns nil if no lookup entry is found. Returns an error if the entry refers to a node which is no
+// longer part of the graph.
+func (g *PkgGraph) FindLatestPkgNode(pkgName string) (lookupEntry *LookupNode, err error) {
+	packageNodes := g.lookupTable()[pkgName]
+	if len(packageNodes) == 0 {
+		return
+	}
+
+	// Lookup entries are sorted from lowest version to highest version
+	lookupEntry = packageNodes[len(packageNodes)-1]
+	err = g.checkLookupEntryInGraph(lookupEntry)
+	if err != nil {
+		lookupEntry = nil
+	}
+	return
+}
+
+// NodesBySRPM returns the run, build, and remote nodes built from srpmPath, sorted by ID. The index is maintained
+// alongside the lookup table, so it covers the same nodes: other node types (ie 'PreBuilt' nodes) are not included.
+func (g *PkgGraph) NodesBySRPM(srpmPath string) (nodes []*PkgNode) {
+	g.lookupTable()
+
+	for _, n := range g.srpmIndex[srpmPath] {
+		// Skip nodes removed directly from the underlying graph instead of through RemovePkgNode
+		if graphNode := g.Node(n.ID()); graphNode != nil && graphNode.(*PkgNode).This == n {
+			nodes = append(nodes, n)
+		}
+	}
+	sortNodesByID(nodes)
+
+	return
+}
+
+// FindNodeByRPMPath returns the local run node producing the RPM at rpmPath, or nil if no node produces it. The
+// index is maintained alongside the lookup table, so only run nodes recorded in the lookup table are found.
+// Returns an error listing the nodes if more than one run node has the same RPM path, since the producing node
+// is then ambiguous.
+func (g *PkgGraph) FindNodeByRPMPath(rpmPath string) (pkgNode *PkgNode, err error) {
+	g.lookupTable()
+
+	var nodes []*PkgNode
+	for _, n := range g.rpmIndex[rpmPath] {
+		// Skip nodes removed directly from the underlying graph instead of through RemovePkgNode
+		if graphNode := g.Node(n.ID()); graphNode != nil && graphNode.(*PkgNode).This == n {
+			nodes = append(nodes, n)
+		}
+	}
+
+	switch len(nodes) {
+	case 0:
+	case 1:
+		pkgNode = nodes[0]
+	default:
+		sortNodesByID(nodes)
+		names := make([]string, 0, len(nodes))
+		for _, n := range nodes {
+			names = append(names, n.FriendlyName())
+		}
+		err = fmt.Errorf("RPM '%s' is produced by %d nodes: %s", rpmPath, len(nodes), strings.Join(names, ", "))
+	}
+
+	return
+}
+
 // AllNodes returns a list of all nodes in the graph.
 func (g *PkgGraph) AllNodes() []*PkgNode {
 	count := g.Nodes().Len()
@@ -585,20 +1359,75 @@ func (g *PkgGraph) AllNodes() []*PkgNode {
 	for _, n := range graph.NodesOf(g.Nodes()) {
 		nodes = append(nodes, n.(*PkgNode).This)
 	}
-	return nodes
+	return nodes
+}
+
+// AllNodesFrom returns a list of all nodes accessible from a root node
+func (g *PkgGraph) AllNodesFrom(rootNode *PkgNode) []*PkgNode {
+	count := g.Nodes().Len()
+	nodes := make([]*PkgNode, 0, count)
+	g.WalkFrom(rootNode, func(n *PkgNode) bool {
+		nodes = append(nodes, n)
+		// Don't stop early, visit every node
+		return false
+	})
+	return nodes
+}
+
+// contextCheckInterval is how many nodes a context aware traversal visits between checks for cancellation.
+const contextCheckInterval = 256
+
+// AllNodesFromContext behaves like AllNodesFrom, but checks ctx periodically during the traversal and returns
+// ctx.Err() as soon as it notices ctx is done.
+func (g *PkgGraph) AllNodesFromContext(ctx context.Context, rootNode *PkgNode) (nodes []*PkgNode, err error) {
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+
+	g.WalkFrom(rootNode, func(n *PkgNode) bool {
+		nodes = append(nodes, n)
+		if len(nodes)%contextCheckInterval == 0 {
+			err = ctx.Err()
+		}
+		return err != nil
+	})
+	if err != nil {
+		nodes = nil
+	}
+
+	return
 }
 
-// AllNodesFrom returns a list of all nodes accessible from a root node
-func (g *PkgGraph) AllNodesFrom(rootNode *PkgNode) []*PkgNode {
-	count := g.Nodes().Len()
-	nodes := make([]*PkgNode, 0, count)
+// WalkFrom calls visit once for every node accessible from a root node, including the root itself, in depth first
+// order. The walk stops as soon as visit returns true, so callers looking for a single node don't need to collect
+// every reachable node first as AllNodesFrom does.
+func (g *PkgGraph) WalkFrom(rootNode *PkgNode, visit func(n *PkgNode) (stop bool)) {
 	search := traverse.DepthFirst{}
 	search.Walk(g, rootNode, func(n graph.Node) bool {
 		// Visit function of DepthFirst, called once per node
-		nodes = append(nodes, n.(*PkgNode).This)
-		// Don't stop early, visit every node
-		return false
+		return visit(n.(*PkgNode).This)
 	})
+}
+
+// AllNodesTo returns a list of all nodes which can reach a root node (everything that transitively depends on it),
+// including the root node itself. Each node is visited once, so cycles are safe.
+func (g *PkgGraph) AllNodesTo(rootNode *PkgNode) []*PkgNode {
+	nodes := []*PkgNode{rootNode.This}
+	visited := map[int64]bool{rootNode.ID(): true}
+	stack := []int64{rootNode.ID()}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, dependent := range graph.NodesOf(g.To(id)) {
+			if visited[dependent.ID()] {
+				continue
+			}
+			visited[dependent.ID()] = true
+			nodes = append(nodes, dependent.(*PkgNode).This)
+			stack = append(stack, dependent.ID())
+		}
+	}
 	return nodes
 }
 
@@ -705,6 +1534,15 @@ func (n *PkgNode) String() string {
 
 // Equal returns true if these nodes represent the same data
 func (n *PkgNode) Equal(otherNode *PkgNode) bool {
+	if n.This == otherNode.This {
+		return true
+	}
+	return n.State == otherNode.State && n.EqualIgnoringState(otherNode)
+}
+
+// EqualIgnoringState returns true if these nodes represent the same package, regardless of their build state.
+// It compares everything Equal does except State, so it can tell structural changes apart from state changes.
+func (n *PkgNode) EqualIgnoringState(otherNode *PkgNode) bool {
 	if n.This == otherNode.This {
 		return true
 	}
@@ -721,8 +1559,7 @@ func (n *PkgNode) Equal(otherNode *PkgNode) bool {
 			return false
 		}
 	}
-	return n.State == otherNode.State &&
-		n.Type == otherNode.Type &&
+	return n.Type == otherNode.Type &&
 		n.SrpmPath == otherNode.SrpmPath &&
 		n.RpmPath == otherNode.RpmPath &&
 		n.SpecPath == otherNode.SpecPath &&
@@ -730,7 +1567,8 @@ func (n *PkgNode) Equal(otherNode *PkgNode) bool {
 		n.Architecture == otherNode.Architecture &&
 		n.SourceRepo == otherNode.SourceRepo &&
 		n.GoalName == otherNode.GoalName &&
-		n.Implicit == otherNode.Implicit
+		n.Implicit == otherNode.Implicit &&
+		n.Pinned == otherNode.Pinned
 }
 
 func registerTypes() {
@@ -805,6 +1643,14 @@ func (n PkgNode) MarshalBinary() (data []byte, err error) {
 		err = fmt.Errorf("encoding Implicit: %s", err.Error())
 		return
 	}
+	// Pinned is only written when set so unpinned nodes encode exactly as they did before the field existed
+	if n.Pinned {
+		err = encoder.Encode(n.Pinned)
+		if err != nil {
+			err = fmt.Errorf("encoding Pinned: %s", err.Error())
+			return
+		}
+	}
 	return outBuffer.Bytes(), err
 }
 
@@ -874,6 +1720,15 @@ func (n *PkgNode) UnmarshalBinary(inBuffer []byte) (err error) {
 		err = fmt.Errorf("decoding Implicit: %s", err.Error())
 		return
 	}
+	// Pinned is optional, nodes encoded without it are not pinned
+	err = decoder.Decode(&n.Pinned)
+	if err == io.EOF {
+		n.Pinned = false
+		err = nil
+	} else if err != nil {
+		err = fmt.Errorf("decoding Pinned: %s", err.Error())
+		return
+	}
 	n.This = n
 	return
 }
@@ -909,9 +1764,20 @@ func (n *PkgNode) SetAttribute(attr encoding.Attribute) (err error) {
 		}
 		// Restore the ID we were given by the deserializer
 		n.nodeID = newID
+		// The blob overwrote any plaintext SRPM read before it
+		if n.dotSRPM != nil {
+			n.SrpmPath = *n.dotSRPM
+		}
 	case dotKeySRPM:
-		logger.Log.Trace("Ignoring srpm")
-		// No-op, b64encoding should totally overwrite the node.
+		if !PreferDOTPlaintextAttributes {
+			logger.Log.Trace("Ignoring srpm")
+			// No-op, b64encoding should totally overwrite the node.
+			break
+		}
+		// Remember the value so it also wins over a blob which comes after it
+		srpmPath := attr.Value
+		n.dotSRPM = &srpmPath
+		n.SrpmPath = srpmPath
 	case dotKeyColor:
 		logger.Log.Trace("Ignoring color")
 		// No-op, b64encoding should totally overwrite the node.
@@ -949,7 +1815,7 @@ func (n *PkgNode) Attributes() []encoding.Attribute {
 		},
 		{
 			Key:   dotKeyColor,
-			Value: n.DOTColor(),
+			Value: n.outputColor(),
 		},
 		{
 			Key:   dotKeyFill,
@@ -1012,27 +1878,66 @@ func (g *PkgGraph) AddMetaNode(from []*PkgNode, to []*PkgNode) (metaNode *PkgNod
 	return
 }
 
+// FlattenMetaNodes removes every meta node (TypePureMeta) from the graph, replacing each with direct edges from the
+// nodes which depended on it to the nodes it depended on, so reachability between the remaining nodes is preserved.
+// Chains of meta nodes collapse into single edges. A node which reached itself only through a meta node is not given
+// a self loop. Goal nodes are kept, their edges to meta nodes are rewired like any other.
+func (g *PkgGraph) FlattenMetaNodes() (err error) {
+	// graph manipulation calls may panic on error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("flattening meta nodes failed: %s", r)
+		}
+	}()
+
+	var metaNodes []*PkgNode
+	for _, n := range g.AllNodes() {
+		if n.Type == TypePureMeta {
+			metaNodes = append(metaNodes, n)
+		}
+	}
+	sortNodesByID(metaNodes)
+
+	for _, metaNode := range metaNodes {
+		dependents := graph.NodesOf(g.To(metaNode.ID()))
+		dependencies := graph.NodesOf(g.From(metaNode.ID()))
+
+		for _, dependent := range dependents {
+			for _, dependency := range dependencies {
+				if dependent.ID() == dependency.ID() || g.HasEdgeFromTo(dependent.ID(), dependency.ID()) {
+					continue
+				}
+				g.SetEdge(g.NewEdge(dependent, dependency))
+			}
+		}
+
+		// Meta nodes are never part of the lookup table
+		g.RemoveNode(metaNode.ID())
+	}
+
+	logger.Log.Debugf("Flattened %d meta node(s)", len(metaNodes))
+
+	return
+}
+
 // AddGoalNode adds a goal node to the graph which links to existing nodes. An empty package list will add an edge to all nodes
 func (g *PkgGraph) AddGoalNode(goalName string, packages []*pkgjson.PackageVer, strict bool) (goalNode *PkgNode, err error) {
+	goalNode, _, err = g.AddGoalNodeWithUnresolved(goalName, packages, strict)
+	return
+}
+
+// AddGoalNodeWithUnresolved behaves like AddGoalNode, but also returns the goal packages which no node could satisfy.
+// With strict set the returned error lists them as well.
+func (g *PkgGraph) AddGoalNodeWithUnresolved(goalName string, packages []*pkgjson.PackageVer, strict bool) (goalNode *PkgNode, unresolved []*pkgjson.PackageVer, err error) {
 	// Check if we already have a goal node with the requested name
 	if g.FindGoalNode(goalName) != nil {
 		err = fmt.Errorf("can't have two goal nodes named %s", goalName)
 		return
 	}
 
-	goalSet := make(map[*pkgjson.PackageVer]bool)
-	if len(packages) > 0 {
-		logger.Log.Debugf("Adding \"%s\" goal", goalName)
-		for _, pkg := range packages {
-			logger.Log.Tracef("\t%s-%s", pkg.Name, pkg.Version)
-			goalSet[pkg] = true
-		}
-	} else {
-		logger.Log.Debugf("Adding \"%s\" goal for all nodes", goalName)
-		for _, node := range g.AllRunNodes() {
-			logger.Log.Tracef("\t%s-%s %d", node.VersionedPkg.Name, node.VersionedPkg.Version, node.ID())
-			goalSet[node.VersionedPkg] = true
-		}
+	targets, unresolved, err := g.resolveGoalPackages(goalName, packages)
+	if err != nil {
+		return
 	}
 
 	// Handle failures in SetEdge() and AddNode()
@@ -1055,66 +1960,67 @@ func (g *PkgGraph) AddGoalNode(goalName string, packages []*pkgjson.PackageVer,
 	goalNode.This = goalNode
 	g.AddNode(goalNode)
 
-	for pkg := range goalSet {
-		var existingNode *LookupNode
-		// Try to find an exact match first (to make sure we match revision number exactly, if available)
-		existingNode, err = g.FindExactPkgNodeFromPkg(pkg)
-		if err != nil {
-			return
-		}
-		if existingNode == nil {
-			// Try again with a more general search
-			existingNode, err = g.FindBestPkgNode(pkg)
-			if err != nil {
-				return
-			}
-		}
+	for _, target := range targets {
+		goalEdge := g.NewEdge(goalNode, target)
+		g.SetEdge(goalEdge)
+	}
 
-		if existingNode != nil {
-			logger.Log.Tracef("Found %s to satisfy %s", existingNode.RunNode, pkg)
-			goalEdge := g.NewEdge(goalNode, existingNode.RunNode)
-			g.SetEdge(goalEdge)
-			goalSet[pkg] = false
-		} else {
-			logger.Log.Warnf("Could not goal package %+v", pkg)
-			if strict {
-				logger.Log.Warnf("Missing %+v", pkg)
-				err = fmt.Errorf("could not find all goal nodes with strict=true")
-			}
+	if strict && len(unresolved) > 0 {
+		for _, pkg := range unresolved {
+			logger.Log.Warnf("Missing %+v", pkg)
 		}
+		err = fmt.Errorf("could not find all goal nodes with strict=true, missing: %v", unresolved)
 	}
 
 	return
 }
 
 // CreateSubGraph returns a new graph with which only contains the nodes accessible from rootNode.
+// The nodes are copies which keep their original IDs, so changes to the subgraph do not affect this graph.
 func (g *PkgGraph) CreateSubGraph(rootNode *PkgNode) (subGraph *PkgGraph, err error) {
-	search := traverse.DepthFirst{}
-	subGraph = NewPkgGraph()
+	subGraph, err = g.CreateSubGraphFromNodes([]*PkgNode{rootNode})
+	if err != nil {
+		return
+	}
 
-	newRootNode := rootNode
-	subGraph.AddNode(newRootNode)
-	search.Walk(g, rootNode, func(n graph.Node) bool {
-		// Visit function of DepthFirst, called once per node
+	subgraphSize := subGraph.Nodes().Len()
+	logger.Log.Debugf("Created sub graph with %d nodes rooted at \"%s\"", subgraphSize, rootNode.FriendlyName())
 
-		// Add each neighbor of this node. Every connected node is guaranteed to be part of the new graph
-		for _, neighbor := range graph.NodesOf(g.From(n.ID())) {
-			newNeighbor := neighbor.(*PkgNode)
-			if subGraph.Node(neighbor.ID()) == nil {
-				// Make a copy of the node and add it to the subgraph
-				subGraph.AddNode(newNeighbor)
-			}
+	return
+}
 
-			newEdge := g.Edge(n.ID(), newNeighbor.ID())
-			subGraph.SetEdge(newEdge)
+// CreateSubGraphFromNodes returns a new graph which only contains the nodes accessible from any of the roots, along
+// with the edges between them. All roots are walked in a single traversal. The nodes are copies which keep their
+// original IDs, edges keep their recorded constraints and weights, and the lookup table of the new graph is
+// initialized before it is returned.
+func (g *PkgGraph) CreateSubGraphFromNodes(roots []*PkgNode) (subGraph *PkgGraph, err error) {
+	// graph manipulation calls may panic on error (such as duplicate node IDs)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("creating sub graph failed: %s", r)
 		}
+	}()
 
-		// Don't stop early, visit every node
-		return false
-	})
+	search := traverse.DepthFirst{}
+	reachedNodes := []*PkgNode{}
+	for _, root := range roots {
+		if root == nil || g.Node(root.ID()) == nil {
+			err = fmt.Errorf("root node '%v' is not part of the graph", root)
+			return
+		}
 
-	subgraphSize := subGraph.Nodes().Len()
-	logger.Log.Debugf("Created sub graph with %d nodes rooted at \"%s\"", subgraphSize, rootNode.FriendlyName())
+		search.Walk(g, root, func(n graph.Node) bool {
+			reachedNodes = append(reachedNodes, n.(*PkgNode).This)
+			// Don't stop early, visit every node
+			return false
+		})
+	}
+
+	// Every dependency of a reached node was reached as well
+	subGraph = g.copySubGraphPreservingIDs(reachedNodes)
+	subGraph.lookupTable()
+
+	logger.Log.Debugf("Created sub graph with %d nodes from %d roots", subGraph.Nodes().Len(), len(roots))
 
 	return
 }
@@ -1129,21 +2035,33 @@ func IsSRPMPrebuilt(srpmPath string, pkgGraph *PkgGraph, graphMutex *sync.RWMute
 	return
 }
 
-// WriteDOTGraphFile writes the graph to a DOT graph format file
+// WriteDOTGraphFile writes the graph to a DOT graph format file.
+// The DOT encoder marshals the whole graph in memory before anything is written, so peak memory use is the full
+// marshaled graph. The marshaled bytes are written to the file directly, without being copied into a write buffer.
 func WriteDOTGraphFile(g graph.Directed, filename string) (err error) {
 	logger.Log.Infof("Writing DOT graph to %s", filename)
 	f, err := os.Create(filename)
 	if err != nil {
 		return
 	}
-	defer f.Close()
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
 
 	err = WriteDOTGraph(g, f)
 
 	return
 }
 
-// ReadDOTGraphFile reads the graph from a DOT graph format file
+// ReadDOTGraphFile reads the graph from a DOT graph format file.
+// The file's structure is first checked in chunks (see validateDOTStructure) so corrupt or truncated files are
+// rejected without being loaded. The DOT parser requires the whole file in memory, so the file is then read into
+// a single buffer sized to the file, avoiding the extra copies made while growing a buffer of unknown size. Peak
+// memory use is roughly the size of the file plus the parsed syntax tree, and the file's contents can be freed
+// by the garbage collector once parsing is done, before the nodes are decoded into the graph.
 func ReadDOTGraphFile(g graph.DirectedBuilder, filename string) (err error) {
 	logger.Log.Infof("Reading DOT graph from %s", filename)
 
@@ -1153,18 +2071,46 @@ func ReadDOTGraphFile(g graph.DirectedBuilder, filename string) (err error) {
 	}
 	defer f.Close()
 
-	err = ReadDOTGraph(g, f)
+	err = validateDOTStructure(f)
+	if err != nil {
+		return fmt.Errorf("invalid DOT graph file %s: %w", filename, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
+
+	data := make([]byte, info.Size())
+	_, err = io.ReadFull(f, data)
+	if err != nil {
+		return
+	}
+
+	err = dot.Unmarshal(data, g)
 
 	return
 }
 
-// ReadDOTGraph de-serializes a graph from a DOT formatted object
+// ReadDOTGraph de-serializes a graph from a DOT formatted object.
+// The DOT parser requires the whole input in memory, see ReadDOTGraphFile for the memory characteristics. The
+// input's structure is checked before it is parsed, so corrupt or truncated input fails fast.
 func ReadDOTGraph(g graph.DirectedBuilder, input io.Reader) (err error) {
-	bytes, err := ioutil.ReadAll(input)
+	data, err := ioutil.ReadAll(input)
 	if err != nil {
 		return
 	}
-	err = dot.Unmarshal(bytes, g)
+
+	err = validateDOTStructure(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid DOT graph: %w", err)
+	}
+
+	err = dot.Unmarshal(data, g)
 	return
 }
 
@@ -1178,7 +2124,40 @@ func WriteDOTGraph(g graph.Directed, output io.Writer) (err error) {
 	return
 }
 
+// WriteDOTGraphSorted serializes a graph into a DOT formatted object, the same way as WriteDOTGraph, but explicitly
+// emits nodes sorted by ID and edges sorted by (from ID, to ID) so identical graphs always produce byte-identical
+// output. The output can be read back with ReadDOTGraph.
+func WriteDOTGraphSorted(g graph.Directed, output io.Writer) (err error) {
+	return WriteDOTGraph(sortedDirected{g}, output)
+}
+
+// sortedDirected wraps a directed graph so its nodes, and the dependencies of each node, are iterated by ID.
+type sortedDirected struct {
+	graph.Directed
+}
+
+// Nodes returns every node of the graph, sorted by ID.
+func (s sortedDirected) Nodes() graph.Nodes {
+	return sortedNodesIterator(s.Directed.Nodes())
+}
+
+// From returns the nodes id depends on, sorted by ID.
+func (s sortedDirected) From(id int64) graph.Nodes {
+	return sortedNodesIterator(s.Directed.From(id))
+}
+
+// sortedNodesIterator returns an iterator over the given nodes, sorted by ID.
+func sortedNodesIterator(nodes graph.Nodes) graph.Nodes {
+	sortedNodes := graph.NodesOf(nodes)
+	sort.Slice(sortedNodes, func(i, j int) bool {
+		return sortedNodes[i].ID() < sortedNodes[j].ID()
+	})
+	return iterator.NewOrderedNodes(sortedNodes)
+}
+
 // DeepCopy returns a deep copy of the receiver.
+// The copy is made by encoding the graph to DOT and decoding it again, which does not guarantee node IDs are
+// preserved. Use DeepCopyPreservingIDs if the IDs of the copy must match the original.
 // On error, the returned deepCopy is in an invalid state
 func (g *PkgGraph) DeepCopy() (deepCopy *PkgGraph, err error) {
 	var buf bytes.Buffer
@@ -1191,24 +2170,194 @@ func (g *PkgGraph) DeepCopy() (deepCopy *PkgGraph, err error) {
 	return
 }
 
+// DeepCopyPreservingIDs returns a deep copy of the receiver where every node keeps its original ID, so IDs can be
+// used to correlate nodes between the two graphs. Nodes, their package information, and recorded edge constraints
+// and weights are all copied, so changes to the copy never affect the original.
+func (g *PkgGraph) DeepCopyPreservingIDs() (deepCopy *PkgGraph, err error) {
+	deepCopy, err = g.copyGraphPreservingIDs()
+	if err != nil {
+		return
+	}
+
+	for _, n := range deepCopy.AllNodes() {
+		if n.VersionedPkg != nil {
+			pkgCopy := *n.VersionedPkg
+			n.VersionedPkg = &pkgCopy
+		}
+	}
+	for id, constraint := range deepCopy.edgeConstraints {
+		constraintCopy := *constraint
+		deepCopy.edgeConstraints[id] = &constraintCopy
+	}
+
+	return
+}
+
 // MakeDAG ensures the graph is a directed acyclic graph (DAG).
 // If the graph is not a DAG, this routine will attempt to resolve any cycles to make the graph a DAG.
+// Cycles are resolved with the default resolvers, see DefaultCycleResolvers.
 func (g *PkgGraph) MakeDAG() (err error) {
+	return g.MakeDAGWithResolvers()
+}
+
+// MakeDAGWithResolvers ensures the graph is a directed acyclic graph (DAG), resolving each cycle with the first of
+// the given resolvers which manages to fix it. Resolvers are tried in order. If no resolvers are given the default
+// ones are used (see DefaultCycleResolvers).
+func (g *PkgGraph) MakeDAGWithResolvers(resolvers ...CycleResolver) (err error) {
+	return g.makeDAG(context.Background(), resolvers)
+}
+
+// MakeDAGContext behaves like MakeDAG, but checks ctx before searching for each cycle and returns ctx.Err() as soon
+// as it notices ctx is done. Cycles fixed before then stay fixed, so the graph may be left only partially acyclic.
+func (g *PkgGraph) MakeDAGContext(ctx context.Context) (err error) {
+	return g.makeDAG(ctx, nil)
+}
+
+// makeDAG implements MakeDAGWithResolvers and MakeDAGContext.
+func (g *PkgGraph) makeDAG(ctx context.Context, resolvers []CycleResolver) (err error) {
 	var cycle []*PkgNode
 
+	if len(resolvers) == 0 {
+		resolvers = DefaultCycleResolvers()
+	}
+
 	for {
+		err = ctx.Err()
+		if err != nil {
+			return
+		}
+
 		cycle, err = g.FindAnyDirectedCycle()
 		if err != nil || len(cycle) == 0 {
 			return
 		}
 
-		err = g.fixCycle(cycle)
+		_, err = g.resolveCycle(cycle, resolvers)
 		if err != nil {
 			return formatCycleErrorMessage(cycle, err)
 		}
 	}
 }
 
+// DefaultCycleResolvers returns the resolvers used by MakeDAG: first replacing the interdependencies of a cycle
+// within a single spec by a meta node (CycleFixIntraSpec), then redirecting build edges into the cycle to a
+// 'PreBuilt' node (CycleFixPrebuilt).
+// Callers may extend the returned slice and pass it to MakeDAGWithResolvers.
+func DefaultCycleResolvers() []CycleResolver {
+	return []CycleResolver{
+		func(g *PkgGraph, cycle []*PkgNode) (fixed bool, err error) {
+			_, err = g.fixIntraSpecCycle(cycle[1:])
+			fixed = err == nil
+			return
+		},
+		func(g *PkgGraph, cycle []*PkgNode) (fixed bool, err error) {
+			_, err = g.fixPrebuiltSRPMsCycle(cycle[1:])
+			fixed = err == nil
+			return
+		},
+	}
+}
+
+// resolveCycle tries each resolver in order until one fixes the cycle, and returns the index of that resolver. If none
+// do, the reason given by the last resolver blocked by a pinned node is returned, or the reason given by the last
+// resolver otherwise.
+func (g *PkgGraph) resolveCycle(cycle []*PkgNode, resolvers []CycleResolver) (fixedBy int, err error) {
+	var (
+		fixed     bool
+		lastErr   error
+		pinnedErr error
+	)
+
+	logger.Log.Debugf("Found cycle: %v", cycle)
+
+	for i, resolver := range resolvers {
+		fixed, err = resolver(g, cycle)
+		if fixed {
+			return i, nil
+		}
+
+		if err != nil {
+			lastErr = err
+			if errors.Is(err, errPinnedNode) {
+				pinnedErr = err
+			}
+		}
+	}
+
+	switch {
+	case pinnedErr != nil:
+		err = pinnedErr
+	case lastErr != nil:
+		err = lastErr
+	default:
+		err = fmt.Errorf("no resolver could fix the cycle")
+	}
+
+	return
+}
+
+// PlanDAG reports the fixes MakeDAG would apply to the graph without modifying it.
+func (g *PkgGraph) PlanDAG() (actions []CycleFixAction, err error) {
+	return g.PlanDAGWithResolvers()
+}
+
+// PlanDAGWithResolvers reports the fixes MakeDAGWithResolvers would apply to the graph with the same resolvers,
+// without modifying it. The fixes are computed on a copy of the graph, so the 'AddedNode' of each action only exists
+// in that copy. Cycle members which exist in the original graph are reported as the original nodes. Fixes made by
+// the default resolvers are reported with their CycleFixStrategy, fixes made by custom resolvers as CycleFixCustom.
+// If a cycle can't be fixed the actions planned so far are returned along with an error.
+func (g *PkgGraph) PlanDAGWithResolvers(resolvers ...CycleResolver) (actions []CycleFixAction, err error) {
+	var cycle []*PkgNode
+
+	// The default resolvers are listed in CycleFixStrategy order
+	customResolvers := len(resolvers) > 0
+	if !customResolvers {
+		resolvers = DefaultCycleResolvers()
+	}
+
+	planGraph, err := g.copyGraphPreservingIDs()
+	if err != nil {
+		return
+	}
+
+	for {
+		cycle, err = planGraph.FindAnyDirectedCycle()
+		if err != nil || len(cycle) == 0 {
+			return
+		}
+
+		existingNodes := make(map[int64]bool, planGraph.Nodes().Len())
+		for _, n := range planGraph.AllNodes() {
+			existingNodes[n.ID()] = true
+		}
+
+		action := CycleFixAction{Cycle: append([]*PkgNode(nil), cycle...)}
+		action.Resolver, err = planGraph.resolveCycle(cycle, resolvers)
+		if err != nil {
+			err = fmt.Errorf("unfixable circular dependency found: %s, error: %w", cycleString(cycle), err)
+			return
+		}
+
+		action.Strategy = CycleFixCustom
+		if !customResolvers {
+			action.Strategy = CycleFixStrategy(action.Resolver)
+		}
+		for _, n := range planGraph.AllNodes() {
+			if !existingNodes[n.ID()] && (action.AddedNode == nil || n.ID() < action.AddedNode.ID()) {
+				action.AddedNode = n
+			}
+		}
+
+		// Report the original nodes where possible
+		for i, node := range action.Cycle {
+			if originalNode := g.Node(node.ID()); originalNode != nil {
+				action.Cycle[i] = originalNode.(*PkgNode).This
+			}
+		}
+		actions = append(actions, action)
+	}
+}
+
 // CloneNode creates a clone of the input node with a new, unique ID.
 // The clone doesn't have any edges attached to it.
 func (g *PkgGraph) CloneNode(pkgNode *PkgNode) (newNode *PkgNode) {
@@ -1224,38 +2373,42 @@ func (g *PkgGraph) CloneNode(pkgNode *PkgNode) (newNode *PkgNode) {
 		Architecture: pkgNode.Architecture,
 		SourceRepo:   pkgNode.SourceRepo,
 		Implicit:     pkgNode.Implicit,
+		Pinned:       pkgNode.Pinned,
 	}
 	newNode.This = newNode
 
 	return
 }
 
-// fixCycle attempts to fix a cycle. Cycles may be acceptable if:
-// - all nodes are from the same spec file or
-// - at least one of the nodes of the cycle represents a pre-built SRPM.
-func (g *PkgGraph) fixCycle(cycle []*PkgNode) (err error) {
-	logger.Log.Debugf("Found cycle: %v", cycle)
-
-	// Omit the first element of the cycle, since it is repeated as the last element
-	trimmedCycle := cycle[1:]
-
-	err = g.fixIntraSpecCycle(trimmedCycle)
-	if err == nil {
-		return
-	}
-
-	return g.fixPrebuiltSRPMsCycle(trimmedCycle)
+// pinnedNodeError returns an error explaining that fixing a cycle would require modifying a pinned node.
+func pinnedNodeError(pkgNode *PkgNode) error {
+	return fmt.Errorf("%w: fixing the cycle would modify '%s'", errPinnedNode, pkgNode.FriendlyName())
 }
 
 // fixIntraSpecCycle attempts to fix a cycle if none of the cycle nodes are build nodes.
 // If a cycle can be fixed an additional meta node will be added to represent the interdependencies of the cycle.
-func (g *PkgGraph) fixIntraSpecCycle(trimmedCycle []*PkgNode) (err error) {
+func (g *PkgGraph) fixIntraSpecCycle(trimmedCycle []*PkgNode) (metaNode *PkgNode, err error) {
 	logger.Log.Debug("Checking if cycle contains build nodes.")
 
 	for _, currentNode := range trimmedCycle {
 		if currentNode.Type == TypeBuild {
 			logger.Log.Debug("Cycle contains build dependencies, cannot be solved this way.")
-			return fmt.Errorf("cycle contains build dependencies, unresolvable")
+			err = fmt.Errorf("cycle contains build dependencies, unresolvable")
+			return
+		}
+	}
+
+	// Every cycle node, and every node depending on one, has its edges rewritten
+	for _, currentNode := range trimmedCycle {
+		if currentNode.Pinned {
+			err = pinnedNodeError(currentNode)
+			return
+		}
+		for _, parentNode := range graph.NodesOf(g.To(currentNode.ID())) {
+			if parentNode.(*PkgNode).This.Pinned {
+				err = pinnedNodeError(parentNode.(*PkgNode).This)
+				return
+			}
 		}
 	}
 
@@ -1307,16 +2460,18 @@ func (g *PkgGraph) fixIntraSpecCycle(trimmedCycle []*PkgNode) (err error) {
 		dependencyNodes = append(dependencyNodes, g.Node(id).(*PkgNode).This)
 	}
 
-	g.AddMetaNode(dependencyNodes, trimmedCycle)
+	metaNode = g.AddMetaNode(dependencyNodes, trimmedCycle)
 
 	return
 }
 
 // fixPrebuiltSRPMsCycle attempts to fix a cycle if at least one node is a pre-built SRPM.
 // If a cycle can be fixed, edges representing the build dependencies of the pre-built SRPM will be removed.
-func (g *PkgGraph) fixPrebuiltSRPMsCycle(trimmedCycle []*PkgNode) (err error) {
+func (g *PkgGraph) fixPrebuiltSRPMsCycle(trimmedCycle []*PkgNode) (preBuiltNode *PkgNode, err error) {
 	logger.Log.Debug("Checking if cycle contains pre-built SRPMs.")
 
+	var pinnedNode *PkgNode
+
 	currentNode := trimmedCycle[len(trimmedCycle)-1]
 	for _, previousNode := range trimmedCycle {
 		// Why we're targetting only "build node -> run node" edges:
@@ -1326,10 +2481,17 @@ func (g *PkgGraph) fixPrebuiltSRPMsCycle(trimmedCycle []*PkgNode) (err error) {
 		//    These edges represent the 'BuildRequires' from the .spec file. If the cycle is breakable, the run node comes from a pre-built SRPM.
 		buildToRunEdge := previousNode.Type == TypeBuild && currentNode.Type == TypeRun
 		if isPrebuilt, _, _ := IsSRPMPrebuilt(currentNode.SrpmPath, g, nil); buildToRunEdge && isPrebuilt {
+			if blockingNode := g.pinnedPrebuiltFixNode(currentNode, previousNode); blockingNode != nil {
+				logger.Log.Debugf("Not replacing edges to pre-built SRPM '%s', '%s' is pinned.", currentNode.SrpmPath, blockingNode.FriendlyName())
+				pinnedNode = blockingNode
+				currentNode = previousNode
+				continue
+			}
+
 			logger.Log.Debugf("Cycle contains pre-built SRPM '%s'. Replacing edges from build nodes associated with '%s' with an edge to a new 'PreBuilt' node.",
 				currentNode.SrpmPath, previousNode.SrpmPath)
 
-			preBuiltNode := g.CloneNode(currentNode)
+			preBuiltNode = g.CloneNode(currentNode)
 			preBuiltNode.State = StateUpToDate
 			preBuiltNode.Type = TypePreBuilt
 
@@ -1355,7 +2517,28 @@ func (g *PkgGraph) fixPrebuiltSRPMsCycle(trimmedCycle []*PkgNode) (err error) {
 		currentNode = previousNode
 	}
 
-	return fmt.Errorf("cycle contains no pre-build SRPMs, unresolvable")
+	if pinnedNode != nil {
+		err = pinnedNodeError(pinnedNode)
+		return
+	}
+
+	err = fmt.Errorf("cycle contains no pre-build SRPMs, unresolvable")
+	return
+}
+
+// pinnedPrebuiltFixNode returns the first pinned node which fixPrebuiltSRPMsCycle would have to modify to replace
+// the edges from previousNode's build nodes to runNode, or nil if none of them are pinned.
+func (g *PkgGraph) pinnedPrebuiltFixNode(runNode, previousNode *PkgNode) *PkgNode {
+	if runNode.Pinned {
+		return runNode
+	}
+	for _, parentNode := range graph.NodesOf(g.To(runNode.ID())) {
+		parentPkgNode := parentNode.(*PkgNode).This
+		if parentPkgNode.Pinned && parentPkgNode.Type == TypeBuild && parentPkgNode.SrpmPath == previousNode.SrpmPath {
+			return parentPkgNode
+		}
+	}
+	return nil
 }
 
 // removePkgNodeFromLookup removes a node from the lookup tables.
@@ -1369,16 +2552,51 @@ func (g *PkgGraph) removePkgNodeFromLookup(pkgNode *PkgNode) {
 			break
 		}
 	}
+
+	g.removeFromPathIndexes(pkgNode)
+}
+
+// removeFromPathIndexes removes a single node from the SRPM and RPM indexes.
+func (g *PkgGraph) removeFromPathIndexes(pkgNode *PkgNode) {
+	removeFromPathIndex(g.srpmIndex, pkgNode.SrpmPath, pkgNode)
+	if hasRPMIndexEntry(pkgNode) {
+		removeFromPathIndex(g.rpmIndex, pkgNode.RpmPath, pkgNode)
+	}
+}
+
+// removeFromPathIndex removes a single node from the entry for path in a path index.
+func removeFromPathIndex(index map[string][]*PkgNode, path string, pkgNode *PkgNode) {
+	pathNodes := index[path]
+	for i, n := range pathNodes {
+		if n == pkgNode.This {
+			index[path] = append(pathNodes[:i], pathNodes[i+1:]...)
+			break
+		}
+	}
+	if len(index[path]) == 0 {
+		delete(index, path)
+	}
 }
 
-func formatCycleErrorMessage(cycle []*PkgNode, err error) error {
+// hasRPMIndexEntry checks if a node is tracked by the RPM index: only local run nodes with an RPM path are.
+func hasRPMIndexEntry(pkgNode *PkgNode) bool {
+	return pkgNode.Type == TypeRun && pkgNode.RpmPath != "" && pkgNode.RpmPath != "<NO_RPM_PATH>"
+}
+
+// cycleString formats a cycle as a human readable chain of node names.
+func cycleString(cycle []*PkgNode) string {
 	var cycleStringBuilder strings.Builder
 
 	fmt.Fprintf(&cycleStringBuilder, "{%s}", cycle[0].FriendlyName())
 	for _, node := range cycle[1:] {
 		fmt.Fprintf(&cycleStringBuilder, " --> {%s}", node.FriendlyName())
 	}
-	logger.Log.Errorf("Unfixable circular dependency found:\t%s\terror: %s", cycleStringBuilder.String(), err)
+
+	return cycleStringBuilder.String()
+}
+
+func formatCycleErrorMessage(cycle []*PkgNode, err error) error {
+	logger.Log.Errorf("Unfixable circular dependency found:\t%s\terror: %s", cycleString(cycle), err)
 
 	// This is a common error for developers, print this so they can try to fix it themselves.
 	// Circular dependencies in the core repo may be resolved by using toolchain RPMs which won't be rebuilt, BUT
@@ -5,9 +5,13 @@ package pkggraph
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/logger"
@@ -15,6 +19,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/encoding"
 )
 
 // Note about test data:
@@ -318,6 +323,29 @@ func TestNodeTypeString(t *testing.T) {
 	}
 }
 
+// TestParseNodeStateAndType checks that parsing is the inverse of String()
+func TestParseNodeStateAndType(t *testing.T) {
+	for s := StateUnknown + 1; s <= StateMAX; s++ {
+		parsed, err := ParseNodeState(s.String())
+		assert.NoError(t, err)
+		assert.Equal(t, s, parsed)
+	}
+	for tp := TypeUnknown + 1; tp <= TypePreBuilt; tp++ {
+		parsed, err := ParseNodeType(tp.String())
+		assert.NoError(t, err)
+		assert.Equal(t, tp, parsed)
+	}
+
+	_, err := ParseNodeState("Unknown")
+	assert.Error(t, err)
+	_, err = ParseNodeState("build")
+	assert.Error(t, err)
+	_, err = ParseNodeType("")
+	assert.Error(t, err)
+	_, err = ParseNodeType("Prebuilt")
+	assert.Error(t, err)
+}
+
 // TestDOTColor checks that every combination of state and type give a color
 func TestDOTColor(t *testing.T) {
 	var (
@@ -335,6 +363,61 @@ func TestDOTColor(t *testing.T) {
 	assert.Panics(t, func() { n.DOTColor() })
 }
 
+// TestDOTColorFunc checks that a custom color scheme replaces the built-in one when writing graphs out.
+func TestDOTColorFunc(t *testing.T) {
+	n := PkgNode{State: StateBuild, Type: TypeBuild}
+	n.This = &n
+	colorOf := func(attributes []encoding.Attribute) string {
+		for _, attribute := range attributes {
+			if attribute.Key == dotKeyColor {
+				return attribute.Value
+			}
+		}
+		return ""
+	}
+	assert.Equal(t, n.DOTColor(), colorOf(n.Attributes()))
+
+	DOTColorFunc = func(*PkgNode) string { return "blue" }
+	defer func() { DOTColorFunc = nil }()
+	assert.Equal(t, "blue", colorOf(n.Attributes()))
+	assert.Equal(t, "gold", n.DOTColor())
+}
+
+// TestDOTPlaintextAttributes checks that hand edited SRPM attributes are only honored when requested, no matter
+// where they appear relative to the base64 blob.
+func TestDOTPlaintextAttributes(t *testing.T) {
+	attributes := pkgARun.Attributes()
+	for i := range attributes {
+		if attributes[i].Key == dotKeySRPM {
+			attributes[i].Value = "edited.src.rpm"
+		}
+	}
+	reversed := make([]encoding.Attribute, 0, len(attributes))
+	for i := len(attributes) - 1; i >= 0; i-- {
+		reversed = append(reversed, attributes[i])
+	}
+
+	decode := func(attributes []encoding.Attribute) *PkgNode {
+		n := &PkgNode{}
+		for _, attribute := range attributes {
+			assert.NoError(t, n.SetAttribute(attribute))
+		}
+		return n
+	}
+
+	assert.Equal(t, pkgARun.SrpmPath, decode(attributes).SrpmPath)
+	assert.Equal(t, pkgARun.SrpmPath, decode(reversed).SrpmPath)
+
+	PreferDOTPlaintextAttributes = true
+	defer func() { PreferDOTPlaintextAttributes = false }()
+	for _, order := range [][]encoding.Attribute{attributes, reversed} {
+		n := decode(order)
+		assert.Equal(t, "edited.src.rpm", n.SrpmPath)
+		assert.Equal(t, pkgARun.VersionedPkg, n.VersionedPkg)
+		assert.Equal(t, pkgARun.RpmPath, n.RpmPath)
+	}
+}
+
 // TestDOTID checks that nodes will generate the correct DOTID for serialization.
 func TestDOTID(t *testing.T) {
 	for _, n := range allNodes {
@@ -378,6 +461,20 @@ func TestNodeInequality(t *testing.T) {
 	assert.False(t, pkgARun.Equal(pkgBRun))
 }
 
+// TestNodeEqualityIgnoringState checks that only the state is ignored
+func TestNodeEqualityIgnoringState(t *testing.T) {
+	pkgAAlt := buildRunNodeHelper(&pkgA)
+	pkgAAlt.State = StateUpToDate
+	assert.False(t, pkgAAlt.Equal(pkgARun))
+	assert.True(t, pkgAAlt.EqualIgnoringState(pkgARun))
+	assert.True(t, pkgARun.EqualIgnoringState(pkgAAlt))
+
+	pkgAAlt.SrpmPath = "other.src.rpm"
+	assert.False(t, pkgAAlt.EqualIgnoringState(pkgARun))
+	assert.False(t, pkgARun.EqualIgnoringState(pkgABuild))
+	assert.False(t, pkgARun.EqualIgnoringState(pkgBRun))
+}
+
 // Add a single Run node to the graph
 func TestAddNode(t *testing.T) {
 	g := NewPkgGraph()
@@ -652,6 +749,31 @@ func TestFindNewest(t *testing.T) {
 	assert.True(t, lu.RunNode.Equal(n3Run))
 }
 
+// Make sure a custom version comparator decides which node is the best match
+func TestVersionComparator(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	lu, err := g.FindBestPkgNode(&pkgjson.PackageVer{Name: "C"})
+	assert.NoError(t, err)
+	assert.True(t, lu.RunNode.Equal(pkgC2Run))
+
+	// Reverse the default ordering so the lowest version wins
+	g.SetVersionComparator(func(a, b *pkgjson.PackageVer) int {
+		intervalA, _ := a.Interval()
+		intervalB, _ := b.Interval()
+		return intervalB.Compare(&intervalA)
+	})
+	lu, err = g.FindBestPkgNode(&pkgjson.PackageVer{Name: "C"})
+	assert.NoError(t, err)
+	assert.True(t, lu.RunNode.Equal(pkgCRun))
+
+	g.SetVersionComparator(nil)
+	lu, err = g.FindBestPkgNode(&pkgjson.PackageVer{Name: "C"})
+	assert.NoError(t, err)
+	assert.True(t, lu.RunNode.Equal(pkgC2Run))
+}
+
 // Make sure we can't add a duplicate node
 func TestAddDuplicateRunNode(t *testing.T) {
 	g := NewPkgGraph()
@@ -661,6 +783,9 @@ func TestAddDuplicateRunNode(t *testing.T) {
 
 	_, err = addNodeToGraphHelper(g, pkgARun)
 	assert.Error(t, err)
+
+	// The rejected node must not be left behind in the graph
+	assert.Equal(t, len(allNodes), g.Nodes().Len())
 }
 
 // Make sure we can't add a duplicate node
@@ -672,6 +797,9 @@ func TestAddDuplicateBuildNode(t *testing.T) {
 
 	_, err = addNodeToGraphHelper(g, pkgABuild)
 	assert.Error(t, err)
+
+	// The rejected node must not be left behind in the graph
+	assert.Equal(t, len(allNodes), g.Nodes().Len())
 }
 
 // Make sure that we can't successfully search when we are missing run nodes
@@ -744,6 +872,48 @@ func TestStrictGoalNodes(t *testing.T) {
 }
 
 // Add a meta node which should link the two disconnected graph components in the test graph
+func TestCollapseMatching(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	collapse := map[int64]bool{}
+	for _, n := range []*PkgNode{pkgD1Unresolved, pkgD2Unresolved, pkgD3Unresolved} {
+		collapse[findTestNode(t, g, n).ID()] = true
+	}
+	c2Run := findTestNode(t, g, pkgC2Run)
+	dependents := []*PkgNode{findTestNode(t, g, pkgARun), findTestNode(t, g, pkgBRun), findTestNode(t, g, pkgCRun)}
+
+	// Nothing matches
+	_, err = g.CollapseMatching(&pkgjson.PackageVer{Name: "E", Version: "1"}, c2Run, func(*PkgNode) bool { return false })
+	assert.Error(t, err)
+	assert.Equal(t, len(allNodes), g.Nodes().Len())
+
+	// Build nodes and the parent are never offered
+	offered := 0
+	newNode, err := g.CollapseMatching(&pkgjson.PackageVer{Name: "E", Version: "1"}, c2Run, func(n *PkgNode) bool {
+		assert.NotEqual(t, TypeBuild, n.Type)
+		assert.NotEqual(t, c2Run, n)
+		offered++
+		return collapse[n.ID()]
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, len(runNodes)+len(unresolvedNodes)-1, offered)
+
+	assert.Equal(t, len(allNodes)-len(collapse)+1, g.Nodes().Len())
+	for id := range collapse {
+		assert.Nil(t, g.Node(id))
+	}
+	assert.True(t, g.HasEdgeFromTo(newNode.ID(), c2Run.ID()))
+	for _, dependent := range dependents {
+		assert.True(t, g.HasEdgeFromTo(dependent.ID(), newNode.ID()))
+	}
+
+	lookup, err := g.FindExactPkgNodeFromPkg(&pkgjson.PackageVer{Name: "E", Version: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, newNode, lookup.RunNode)
+	assert.Equal(t, 3, len(g.lookupTable()["D"]))
+}
+
 func TestMetaNode(t *testing.T) {
 	g, err := buildTestGraphHelper()
 	assert.NoError(t, err)
@@ -840,6 +1010,49 @@ func TestMetaNodeAddPkg(t *testing.T) {
 	assert.Equal(t, 5, len(g.AllNodesFrom(c.RunNode)))
 }
 
+// Make sure flattening meta nodes keeps every dependency they carried
+func TestFlattenMetaNodes(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	aRun := findTestNode(t, g, pkgARun)
+	c2Run := findTestNode(t, g, pkgC2Run)
+	d4 := findTestNode(t, g, pkgD4Unresolved)
+
+	// A chain of meta nodes: A run -> meta -> meta -> C2 run
+	innerMeta := g.AddMetaNode(nil, []*PkgNode{c2Run})
+	outerMeta := g.AddMetaNode([]*PkgNode{aRun}, []*PkgNode{innerMeta})
+	goalNode, err := g.AddGoalNode("test", nil, false)
+	assert.NoError(t, err)
+	// A cycle through a meta node: C2 run -> meta -> C2 run
+	g.AddMetaNode([]*PkgNode{c2Run}, []*PkgNode{c2Run, d4})
+
+	reachableBefore := make(map[int64][]*PkgNode)
+	for _, n := range g.AllNodes() {
+		if n.Type != TypePureMeta {
+			for _, reached := range g.AllNodesFrom(n) {
+				if reached.Type != TypePureMeta {
+					reachableBefore[n.ID()] = append(reachableBefore[n.ID()], reached)
+				}
+			}
+		}
+	}
+
+	err = g.FlattenMetaNodes()
+	assert.NoError(t, err)
+
+	assert.Equal(t, len(allNodes)+1, len(g.AllNodes()))
+	assert.Nil(t, g.Node(outerMeta.ID()))
+	assert.NotNil(t, g.Node(goalNode.ID()))
+	assert.True(t, g.HasEdgeFromTo(aRun.ID(), c2Run.ID()))
+	assert.True(t, g.HasEdgeFromTo(c2Run.ID(), d4.ID()))
+	assert.False(t, g.HasEdgeFromTo(c2Run.ID(), c2Run.ID()))
+	for _, n := range g.AllNodes() {
+		assert.NotEqual(t, TypePureMeta, n.Type)
+		assert.ElementsMatch(t, reachableBefore[n.ID()], g.AllNodesFrom(n))
+	}
+}
+
 // Test encoding and decoding a DOT formatted graph
 func TestEncodeDecodeDOT(t *testing.T) {
 
@@ -916,6 +1129,50 @@ func TestReadWriteGraph(t *testing.T) {
 	assert.Error(t, err)
 }
 
+// Make sure the file writer produces the same output as the stream writer
+func TestWriteDOTGraphFileMatchesStream(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	var expected bytes.Buffer
+	assert.NoError(t, WriteDOTGraph(g, &expected))
+
+	path := filepath.Join(t.TempDir(), "test_graph.dot")
+	assert.NoError(t, WriteDOTGraphFile(g, path))
+	written, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Bytes(), written)
+}
+
+// Make sure the sorted output doesn't depend on the order the graph was built in, and can be read back
+func TestWriteDOTGraphSorted(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Rebuild the same graph, adding nodes and edges in reverse order
+	nodes := g.AllNodes()
+	sortNodesByID(nodes)
+	reversed := NewPkgGraph()
+	for i := len(nodes) - 1; i >= 0; i-- {
+		reversed.AddNode(copyNode(nodes[i]))
+	}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		dependencies := graph.NodesOf(g.From(nodes[i].ID()))
+		for j := len(dependencies) - 1; j >= 0; j-- {
+			reversed.SetEdge(reversed.NewEdge(reversed.Node(nodes[i].ID()), reversed.Node(dependencies[j].ID())))
+		}
+	}
+
+	var expected, actual bytes.Buffer
+	assert.NoError(t, WriteDOTGraphSorted(g, &expected))
+	assert.NoError(t, WriteDOTGraphSorted(reversed, &actual))
+	assert.Equal(t, expected.String(), actual.String())
+
+	gIn := NewPkgGraph()
+	assert.NoError(t, ReadDOTGraph(gIn, &expected))
+	checkTestGraph(t, gIn)
+}
+
 // Validate the reference graph is valid, and that it matches the output of the test graph.
 func TestReferenceDOTFile(t *testing.T) {
 	gIn := NewPkgGraph()
@@ -1008,6 +1265,31 @@ func TestEncodingSubGraph(t *testing.T) {
 	assert.Equal(t, len(component), len(gCopy.AllNodes()))
 }
 
+// Make sure changes to a subgraph don't leak back into the original graph
+func TestCreateSubGraphCopiesNodes(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	root, err := g.FindBestPkgNode(&pkgjson.PackageVer{Name: "C", Version: "3-3"})
+	assert.NoError(t, err)
+	subGraph, err := g.CreateSubGraph(root.RunNode)
+	assert.NoError(t, err)
+
+	subRoot := subGraph.Node(root.RunNode.ID()).(*PkgNode)
+	assert.True(t, subRoot == subRoot.This)
+	assert.True(t, root.RunNode.Equal(subRoot))
+
+	for _, n := range subGraph.AllNodes() {
+		n.State = StateUpToDate
+	}
+	subRoot.SrpmPath = "changed.src.rpm"
+
+	assert.Equal(t, StateMeta, root.RunNode.State)
+	assert.Equal(t, StateBuild, root.BuildNode.State)
+	assert.Equal(t, pkgCRun.SrpmPath, root.RunNode.SrpmPath)
+	checkTestGraph(t, g)
+}
+
 func TestShouldSucceedMakeDAGWithGoalNode(t *testing.T) {
 	gOut, err := buildTestGraphHelper()
 	assert.NoError(t, err)
@@ -1070,3 +1352,611 @@ func TestShouldGetSRPMNameFromEmptySRPMPath(t *testing.T) {
 
 	assert.Equal(t, ".", node.SRPMFileName())
 }
+
+// Make sure PlanDAG reports the fixes MakeDAG would make without modifying the graph
+func TestPlanDAG(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Create a cycle without build nodes: C2 -> D4 -> C2
+	err = addEdgeHelper(g, *pkgD4Unresolved, *pkgC2Run)
+	assert.NoError(t, err)
+
+	actions, err := g.PlanDAG()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(actions))
+	assert.Equal(t, CycleFixIntraSpec, actions[0].Strategy)
+	assert.Equal(t, TypePureMeta, actions[0].AddedNode.Type)
+	assert.Equal(t, 3, len(actions[0].Cycle))
+	for _, n := range actions[0].Cycle {
+		assert.Same(t, n, g.Node(n.ID()).(*PkgNode))
+	}
+
+	// The original graph still has its cycle
+	assert.Equal(t, len(allNodes), len(g.AllNodes()))
+	cycle, err := g.FindAnyDirectedCycle()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cycle)
+
+	assert.NoError(t, g.MakeDAG())
+	assert.Equal(t, len(allNodes)+len(actions), len(g.AllNodes()))
+}
+
+// Make sure PlanDAGWithResolvers uses the same resolvers as MakeDAGWithResolvers
+func TestPlanDAGWithResolvers(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Create a cycle without build nodes: C2 -> D4 -> C2
+	err = addEdgeHelper(g, *pkgD4Unresolved, *pkgC2Run)
+	assert.NoError(t, err)
+	d4 := findTestNode(t, g, pkgD4Unresolved)
+	c2Run := findTestNode(t, g, pkgC2Run)
+
+	// A resolver which just drops the edge closing the cycle, without adding any node
+	refuse := func(g *PkgGraph, cycle []*PkgNode) (bool, error) {
+		return false, fmt.Errorf("not this one")
+	}
+	dropEdge := func(g *PkgGraph, cycle []*PkgNode) (bool, error) {
+		g.RemoveEdge(d4.ID(), c2Run.ID())
+		return true, nil
+	}
+
+	actions, err := g.PlanDAGWithResolvers(refuse, dropEdge)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(actions))
+	assert.Equal(t, CycleFixCustom, actions[0].Strategy)
+	assert.Equal(t, 1, actions[0].Resolver)
+	assert.Nil(t, actions[0].AddedNode)
+	assert.True(t, g.HasEdgeFromTo(d4.ID(), c2Run.ID()))
+
+	_, err = g.PlanDAGWithResolvers(refuse)
+	assert.Error(t, err)
+
+	assert.NoError(t, g.MakeDAGWithResolvers(refuse, dropEdge))
+	assert.Equal(t, len(allNodes), len(g.AllNodes()))
+	assert.False(t, g.HasEdgeFromTo(d4.ID(), c2Run.ID()))
+}
+
+func TestPlanDAGUnfixable(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Create a build cycle: A -> B -> C -> A
+	err = addEdgeHelper(g, *pkgCBuild, *pkgARun)
+	assert.NoError(t, err)
+
+	_, err = g.PlanDAG()
+	assert.Error(t, err)
+	assert.Equal(t, len(allNodes), len(g.AllNodes()))
+}
+
+// Make sure cycle fixes refuse to touch pinned nodes
+func TestMakeDAGPinnedNode(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Create a cycle without build nodes: C2 -> D4 -> C2
+	err = addEdgeHelper(g, *pkgD4Unresolved, *pkgC2Run)
+	assert.NoError(t, err)
+	findTestNode(t, g, pkgD4Unresolved).Pinned = true
+
+	_, err = g.PlanDAG()
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, errPinnedNode))
+
+	err = g.MakeDAG()
+	assert.Error(t, err)
+	assert.Equal(t, len(allNodes), len(g.AllNodes()))
+}
+
+// Make sure the pinned flag survives serialization, and unpinned nodes encode as they always have
+func TestEncodeDecodePinned(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	aRun := findTestNode(t, g, pkgARun)
+	unpinnedBytes, err := aRun.MarshalBinary()
+	assert.NoError(t, err)
+
+	aRun.Pinned = true
+	pinnedBytes, err := aRun.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, unpinnedBytes, pinnedBytes[:len(unpinnedBytes)])
+
+	decoded := &PkgNode{}
+	assert.NoError(t, decoded.UnmarshalBinary(unpinnedBytes))
+	assert.False(t, decoded.Pinned)
+	assert.NoError(t, decoded.UnmarshalBinary(pinnedBytes))
+	assert.True(t, decoded.Pinned)
+
+	gCopy, err := g.DeepCopy()
+	assert.NoError(t, err)
+	assert.True(t, findTestNode(t, gCopy, pkgARun).Pinned)
+	assert.True(t, g.CloneNode(aRun).Pinned)
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteGraphColumnar(g, &buf))
+	gColumnar, err := ReadGraphColumnar(&buf)
+	assert.NoError(t, err)
+	assert.True(t, findTestNode(t, gColumnar, pkgARun).Pinned)
+	assert.False(t, findTestNode(t, gColumnar, pkgABuild).Pinned)
+}
+
+// Make sure reverse traversal finds every dependent, including the root, even with a cycle present
+func TestAllNodesTo(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	c, err := g.FindBestPkgNode(&pkgjson.PackageVer{Name: "C", Version: "3-3", Condition: "="})
+	assert.NoError(t, err)
+	dependents := g.AllNodesTo(c.RunNode)
+	assert.Equal(t, c.RunNode, dependents[0])
+	for _, mustHave := range []*PkgNode{pkgCRun, pkgBBuild, pkgBRun, pkgABuild, pkgARun} {
+		found := false
+		for _, n := range dependents {
+			found = found || mustHave.Equal(n)
+		}
+		assert.True(t, found)
+	}
+	assert.Equal(t, 5, len(dependents))
+
+	// A -> B -> C -> A
+	err = addEdgeHelper(g, *pkgCBuild, *pkgARun)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(g.AllNodesTo(c.RunNode)))
+}
+
+func TestWalkFrom(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	aRun := findTestNode(t, g, pkgARun)
+	visited := []*PkgNode{}
+	g.WalkFrom(aRun, func(n *PkgNode) bool {
+		visited = append(visited, n)
+		return false
+	})
+	assert.ElementsMatch(t, g.AllNodesFrom(aRun), visited)
+	assert.Equal(t, aRun, visited[0])
+
+	// Stop at the first build node
+	visited = []*PkgNode{}
+	var found *PkgNode
+	g.WalkFrom(aRun, func(n *PkgNode) bool {
+		visited = append(visited, n)
+		if n.Type == TypeBuild {
+			found = n
+			return true
+		}
+		return false
+	})
+	assert.NotNil(t, found)
+	assert.Equal(t, found, visited[len(visited)-1])
+	assert.Less(t, len(visited), len(g.AllNodesFrom(aRun)))
+}
+
+func TestAllNodesFromContext(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	aRun := findTestNode(t, g, pkgARun)
+	nodes, err := g.AllNodesFromContext(context.Background(), aRun)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, g.AllNodesFrom(aRun), nodes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	nodes, err = g.AllNodesFromContext(ctx, aRun)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, nodes)
+}
+
+func TestMakeDAGContext(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Create a cycle without build nodes: C2 -> D4 -> C2
+	err = addEdgeHelper(g, *pkgD4Unresolved, *pkgC2Run)
+	assert.NoError(t, err)
+
+	// A cancelled context stops before any cycle is fixed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = g.MakeDAGContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	cycle, err := g.FindAnyDirectedCycle()
+	assert.NoError(t, err)
+	assert.NotNil(t, cycle)
+
+	err = g.MakeDAGContext(context.Background())
+	assert.NoError(t, err)
+	cycle, err = g.FindAnyDirectedCycle()
+	assert.NoError(t, err)
+	assert.Nil(t, cycle)
+}
+
+func TestMakeDAGWithResolvers(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Create a cycle: A run -> ... -> C build -> A run
+	err = addEdgeHelper(g, *pkgCBuild, *pkgARun)
+	assert.NoError(t, err)
+
+	var calls []string
+	skipResolver := func(g *PkgGraph, cycle []*PkgNode) (fixed bool, err error) {
+		calls = append(calls, "skip")
+		err = fmt.Errorf("not applicable")
+		return
+	}
+	removeEdgeResolver := func(g *PkgGraph, cycle []*PkgNode) (fixed bool, err error) {
+		calls = append(calls, "remove")
+		assert.Equal(t, cycle[0], cycle[len(cycle)-1])
+		// Each node depends on the one before it
+		g.RemoveEdge(cycle[1].ID(), cycle[0].ID())
+		fixed = true
+		return
+	}
+
+	err = g.MakeDAGWithResolvers(skipResolver, removeEdgeResolver)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"skip", "remove"}, calls)
+	assert.Equal(t, len(allNodes), len(g.AllNodes()))
+
+	cycle, err := g.FindAnyDirectedCycle()
+	assert.NoError(t, err)
+	assert.Nil(t, cycle)
+}
+
+func TestMakeDAGWithResolversUnfixable(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	err = addEdgeHelper(g, *pkgCBuild, *pkgARun)
+	assert.NoError(t, err)
+
+	noopResolver := func(g *PkgGraph, cycle []*PkgNode) (fixed bool, err error) {
+		return
+	}
+
+	err = g.MakeDAGWithResolvers(noopResolver)
+	assert.Error(t, err)
+	assert.Equal(t, len(allNodes), len(g.AllNodes()))
+}
+
+func TestMakeDAGWithDefaultResolvers(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Create a cycle without build nodes: C2 -> D4 -> C2, which is fixed with a meta node
+	err = addEdgeHelper(g, *pkgD4Unresolved, *pkgC2Run)
+	assert.NoError(t, err)
+
+	err = g.MakeDAGWithResolvers(DefaultCycleResolvers()...)
+	assert.NoError(t, err)
+	assert.Equal(t, len(allNodes)+1, len(g.AllNodes()))
+}
+
+func TestFindBestLocalPkgNode(t *testing.T) {
+	g := NewPkgGraph()
+	localE := pkgjson.PackageVer{Name: "E", Version: "1"}
+	remoteE := pkgjson.PackageVer{Name: "E", Version: "2"}
+	err := addNodesHelper(g, []*PkgNode{buildRunNodeHelper(&localE), buildBuildNodeHelper(&localE), buildUnresolvedNodeHelper(&remoteE)})
+	assert.NoError(t, err)
+
+	// By default the highest version wins, even if it is remote
+	lookup, err := g.FindBestPkgNode(&pkgjson.PackageVer{Name: "E"})
+	assert.NoError(t, err)
+	assert.Equal(t, TypeRemote, lookup.RunNode.Type)
+
+	lookup, err = g.FindBestLocalPkgNode(&pkgjson.PackageVer{Name: "E"})
+	assert.NoError(t, err)
+	assert.Equal(t, TypeRun, lookup.RunNode.Type)
+	assert.Equal(t, "1", lookup.RunNode.VersionedPkg.Version)
+	assert.NotNil(t, lookup.BuildNode)
+
+	// Remote nodes are used when no local node satisfies the request
+	lookup, err = g.FindBestLocalPkgNode(&pkgjson.PackageVer{Name: "E", Condition: ">=", Version: "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, TypeRemote, lookup.RunNode.Type)
+
+	lookup, err = g.FindBestLocalPkgNode(&pkgjson.PackageVer{Name: "F"})
+	assert.NoError(t, err)
+	assert.Nil(t, lookup)
+}
+
+func TestFindAllSatisfying(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	cRun := findTestNode(t, g, pkgCRun)
+	c2Run := findTestNode(t, g, pkgC2Run)
+
+	lookupEntries, err := g.FindAllSatisfying(&pkgjson.PackageVer{Name: "C"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(lookupEntries))
+	assert.Equal(t, cRun, lookupEntries[0].RunNode)
+	assert.Equal(t, c2Run, lookupEntries[1].RunNode)
+
+	// The best match is always the last one
+	best, err := g.FindBestPkgNode(&pkgjson.PackageVer{Name: "C"})
+	assert.NoError(t, err)
+	assert.Equal(t, lookupEntries[len(lookupEntries)-1], best)
+
+	lookupEntries, err = g.FindAllSatisfying(&pkgjson.PackageVer{Name: "C", Condition: ">", Version: "3-3"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(lookupEntries))
+	assert.Equal(t, c2Run, lookupEntries[0].RunNode)
+
+	lookupEntries, err = g.FindAllSatisfying(&pkgjson.PackageVer{Name: "C", Condition: ">", Version: "3-4"})
+	assert.NoError(t, err)
+	assert.Nil(t, lookupEntries)
+
+	lookupEntries, err = g.FindAllSatisfying(&pkgjson.PackageVer{Name: "missing"})
+	assert.NoError(t, err)
+	assert.Nil(t, lookupEntries)
+}
+
+func TestRefreshLookupForNode(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Bump the version of C2 in place, the test nodes share their package information so replace it
+	c2Run := findTestNode(t, g, pkgC2Run)
+	c2Build := findTestNode(t, g, pkgC2Build)
+	c2Run.VersionedPkg = &pkgjson.PackageVer{Name: "C", Version: "3-5"}
+	c2Build.VersionedPkg = &pkgjson.PackageVer{Name: "C", Version: "3-5"}
+	assert.NoError(t, g.RefreshLookupForNode(c2Run))
+
+	lookup, err := g.FindExactPkgNodeFromPkg(&pkgjson.PackageVer{Name: "C", Version: "3-5"})
+	assert.NoError(t, err)
+	assert.Equal(t, c2Run, lookup.RunNode)
+	assert.Equal(t, c2Build, lookup.BuildNode)
+	lookup, err = g.FindExactPkgNodeFromPkg(&pkgC2)
+	assert.NoError(t, err)
+	assert.Nil(t, lookup)
+	lookup, err = g.FindBestPkgNode(&pkgjson.PackageVer{Name: "C"})
+	assert.NoError(t, err)
+	assert.Equal(t, c2Run, lookup.RunNode)
+
+	// Drop entries for nodes removed directly from the underlying graph
+	d4 := findTestNode(t, g, pkgD4Unresolved)
+	g.RemoveNode(d4.ID())
+	_, err = g.FindExactPkgNodeFromPkg(&pkgD4)
+	assert.Error(t, err)
+	assert.NoError(t, g.RefreshLookupForNode(d4))
+	lookup, err = g.FindExactPkgNodeFromPkg(&pkgD4)
+	assert.NoError(t, err)
+	assert.Nil(t, lookup)
+	assert.Equal(t, 5, len(g.lookupTable()["D"]))
+}
+
+func TestRefreshLookupForNodeOrphanedBuild(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	cRun := findTestNode(t, g, pkgCRun)
+	cBuild := findTestNode(t, g, pkgCBuild)
+	g.RemoveNode(cRun.ID())
+
+	assert.Error(t, g.RefreshLookupForNode(cBuild))
+	// The build node is reported, not removed
+	assert.NotNil(t, g.Node(cBuild.ID()))
+}
+
+func TestUpdateNodeVersion(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	c2Run := findTestNode(t, g, pkgC2Run)
+	c2Build := findTestNode(t, g, pkgC2Build)
+	srpmNodes := len(g.NodesBySRPM(c2Run.SrpmPath))
+
+	// Bumping a run node moves its build node along with it
+	bumped := &pkgjson.PackageVer{Name: "C", Version: "3-5"}
+	assert.NoError(t, g.UpdateNodeVersion(c2Run, bumped))
+	assert.Equal(t, bumped, c2Run.VersionedPkg)
+	assert.Equal(t, bumped, c2Build.VersionedPkg)
+	assert.Equal(t, srpmNodes, len(g.NodesBySRPM(c2Run.SrpmPath)))
+
+	lookup, err := g.FindExactPkgNodeFromPkg(bumped)
+	assert.NoError(t, err)
+	assert.Equal(t, c2Run, lookup.RunNode)
+	assert.Equal(t, c2Build, lookup.BuildNode)
+	assert.True(t, lookup.intervalPkg == bumped)
+	lookup, err = g.FindExactPkgNodeFromPkg(&pkgC2)
+	assert.NoError(t, err)
+	assert.Nil(t, lookup)
+	assert.Equal(t, 2, len(g.lookupTable()["C"]))
+
+	// Moving below the other version keeps the bucket sorted
+	lowered := &pkgjson.PackageVer{Name: "C", Version: "3-1"}
+	assert.NoError(t, g.UpdateNodeVersion(c2Run, lowered))
+	lookup, err = g.FindBestPkgNode(&pkgjson.PackageVer{Name: "C"})
+	assert.NoError(t, err)
+	assert.Equal(t, findTestNode(t, g, pkgCRun), lookup.RunNode)
+	assert.Equal(t, c2Run, g.lookupTable()["C"][0].RunNode)
+
+	// A duplicate version is rejected and the old version is restored
+	assert.Error(t, g.UpdateNodeVersion(c2Run, &pkgjson.PackageVer{Name: "C", Version: "3-3"}))
+	assert.Equal(t, lowered, c2Run.VersionedPkg)
+	assert.Equal(t, lowered, c2Build.VersionedPkg)
+	lookup, err = g.FindExactPkgNodeFromPkg(lowered)
+	assert.NoError(t, err)
+	assert.Equal(t, c2Run, lookup.RunNode)
+	assert.Equal(t, c2Build, lookup.BuildNode)
+	assert.Equal(t, 2, len(g.lookupTable()["C"]))
+	assert.Equal(t, srpmNodes, len(g.NodesBySRPM(c2Run.SrpmPath)))
+
+	// A build node can't move to a version without a matching run node
+	assert.Error(t, g.UpdateNodeVersion(c2Build, &pkgjson.PackageVer{Name: "C", Version: "3-9"}))
+	assert.Equal(t, lowered, c2Build.VersionedPkg)
+	lookup, err = g.FindExactPkgNodeFromPkg(lowered)
+	assert.NoError(t, err)
+	assert.Equal(t, c2Build, lookup.BuildNode)
+
+	// Remote nodes can be renamed
+	d4 := findTestNode(t, g, pkgD4Unresolved)
+	renamed := &pkgjson.PackageVer{Name: "E"}
+	assert.NoError(t, g.UpdateNodeVersion(d4, renamed))
+	lookup, err = g.FindExactPkgNodeFromPkg(renamed)
+	assert.NoError(t, err)
+	assert.Equal(t, d4, lookup.RunNode)
+	assert.Equal(t, 5, len(g.lookupTable()["D"]))
+
+	assert.Error(t, g.UpdateNodeVersion(buildRunNodeHelper(&pkgA), bumped))
+	assert.Error(t, g.UpdateNodeVersion(c2Run, nil))
+}
+
+func TestLookupRunIntervalCache(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	lookup, err := g.FindExactPkgNodeFromPkg(&pkgC2)
+	assert.NoError(t, err)
+	expected, err := pkgC2.Interval()
+	assert.NoError(t, err)
+	interval, err := lookup.RunInterval()
+	assert.NoError(t, err)
+	assert.True(t, expected.Equal(&interval))
+	assert.True(t, lookup.intervalPkg == lookup.RunNode.VersionedPkg)
+
+	// Replacing the package information bypasses the stale cache until the lookup is refreshed
+	bumped := &pkgjson.PackageVer{Name: "C", Version: "3-5"}
+	expected, err = bumped.Interval()
+	assert.NoError(t, err)
+	lookup.RunNode.VersionedPkg = bumped
+	interval, err = lookup.RunInterval()
+	assert.NoError(t, err)
+	assert.True(t, expected.Equal(&interval))
+
+	lookup.BuildNode.VersionedPkg = bumped
+	assert.NoError(t, g.RefreshLookupForNode(lookup.RunNode))
+	lookup, err = g.FindExactPkgNodeFromPkg(bumped)
+	assert.NoError(t, err)
+	assert.True(t, lookup.intervalPkg == bumped)
+
+	_, err = (&LookupNode{}).RunInterval()
+	assert.Error(t, err)
+}
+
+// Make sure concurrent readers of a graph with a lazily built lookup table all see the same, complete table
+func TestConcurrentLookupInitialization(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, WriteDOTGraph(g, &buf))
+	gIn := NewPkgGraph()
+	assert.NoError(t, ReadDOTGraph(gIn, &buf))
+	assert.Nil(t, gIn.nodeLookup)
+
+	const readers = 8
+	results := make(chan *LookupNode, readers)
+	var waitGroup sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			lookup, lookupErr := gIn.FindBestPkgNode(&pkgjson.PackageVer{Name: "C"})
+			assert.NoError(t, lookupErr)
+			results <- lookup
+		}()
+	}
+	waitGroup.Wait()
+	close(results)
+
+	expected, err := gIn.FindExactPkgNodeFromPkg(&pkgC2)
+	assert.NoError(t, err)
+	for lookup := range results {
+		assert.True(t, expected == lookup)
+	}
+	checkTestGraph(t, gIn)
+}
+
+func TestDeepCopyPreservingIDs(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	aBuild := findTestNode(t, g, pkgABuild)
+	bRun := findTestNode(t, g, pkgBRun)
+	assert.NoError(t, g.SetEdgeConstraint(aBuild, bRun, &pkgjson.PackageVer{Name: "B", Version: "2", Condition: ">="}))
+	// Remove a node so IDs are no longer contiguous
+	g.RemovePkgNode(findTestNode(t, g, pkgD1Unresolved))
+
+	gCopy, err := g.DeepCopyPreservingIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, g.Nodes().Len(), gCopy.Nodes().Len())
+	assert.Equal(t, g.Edges().Len(), gCopy.Edges().Len())
+
+	for _, n := range g.AllNodes() {
+		copied := gCopy.Node(n.ID()).(*PkgNode)
+		assert.True(t, n.Equal(copied))
+		assert.False(t, n == copied)
+		assert.True(t, copied == copied.This)
+		assert.False(t, n.VersionedPkg == copied.VersionedPkg)
+	}
+	for _, edge := range graph.EdgesOf(g.Edges()) {
+		assert.True(t, gCopy.HasEdgeFromTo(edge.From().ID(), edge.To().ID()))
+	}
+
+	copiedConstraint := gCopy.EdgeConstraint(aBuild, bRun)
+	assert.Equal(t, g.EdgeConstraint(aBuild, bRun), copiedConstraint)
+	copiedConstraint.Version = "3"
+	assert.Equal(t, "2", g.EdgeConstraint(aBuild, bRun).Version)
+
+	gCopy.Node(bRun.ID()).(*PkgNode).VersionedPkg.Version = "3"
+	assert.Equal(t, "2", bRun.VersionedPkg.Version)
+}
+
+func TestAddEdgeRejectsSelfLoopsAndDuplicates(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	aRun := findTestNode(t, g, pkgARun)
+	aBuild := findTestNode(t, g, pkgABuild)
+
+	err = g.AddEdge(aRun, aRun)
+	assert.True(t, errors.Is(err, ErrSelfLoop))
+	assert.False(t, g.HasEdgeFromTo(aRun.ID(), aRun.ID()))
+
+	err = g.AddEdge(aRun, aBuild)
+	assert.True(t, errors.Is(err, ErrDuplicateEdge))
+
+	// The reverse edge is a different edge
+	assert.NoError(t, g.AddEdge(aBuild, aRun))
+	assert.Equal(t, len(edges)+1, g.Edges().Len())
+}
+
+func TestAddEdges(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	aRun := findTestNode(t, g, pkgARun)
+	aBuild := findTestNode(t, g, pkgABuild)
+	c2Run := findTestNode(t, g, pkgC2Run)
+	d1 := findTestNode(t, g, pkgD1Unresolved)
+	d4 := findTestNode(t, g, pkgD4Unresolved)
+	edgeCount := g.Edges().Len()
+
+	// Every problem is reported and nothing is added
+	err = g.AddEdges([][2]*PkgNode{
+		{c2Run, d1},
+		{aRun, aRun},
+		{aRun, aBuild},
+		{aBuild, d4},
+		{aBuild, d4},
+		{aRun, buildRunNodeHelper(&pkgB)},
+		{nil, d1},
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "5 problem(s)")
+	assert.Equal(t, edgeCount, g.Edges().Len())
+	assert.False(t, g.HasEdgeFromTo(c2Run.ID(), d1.ID()))
+
+	assert.NoError(t, g.AddEdges([][2]*PkgNode{{c2Run, d1}, {aBuild, d4}}))
+	assert.Equal(t, edgeCount+2, g.Edges().Len())
+	assert.True(t, g.HasEdgeFromTo(c2Run.ID(), d1.ID()))
+	assert.True(t, g.HasEdgeFromTo(aBuild.ID(), d4.ID()))
+
+	assert.NoError(t, g.AddEdges(nil))
+}
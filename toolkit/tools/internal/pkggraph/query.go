@@ -0,0 +1,460 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+// ResolvedPackageList returns the deduplicated list of package versions provided by the run nodes reachable
+// from the named goal node. The list is sorted by package name, then by version interval.
+func (g *PkgGraph) ResolvedPackageList(goalName string) (packages []*pkgjson.PackageVer, err error) {
+	goalNode := g.FindGoalNode(goalName)
+	if goalNode == nil {
+		err = fmt.Errorf("could not find goal node '%s'", goalName)
+		return
+	}
+
+	seen := make(map[pkgjson.PackageVer]bool)
+	for _, node := range g.AllNodesFrom(goalNode) {
+		if node.Type != TypeRun && node.Type != TypeRemote {
+			continue
+		}
+
+		if seen[*node.VersionedPkg] {
+			continue
+		}
+		seen[*node.VersionedPkg] = true
+		packages = append(packages, node.VersionedPkg)
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Name != packages[j].Name {
+			return packages[i].Name < packages[j].Name
+		}
+		intervalI, _ := packages[i].Interval()
+		intervalJ, _ := packages[j].Interval()
+		return intervalI.Compare(&intervalJ) < 0
+	})
+
+	return
+}
+
+// PrebuiltShadowedBuilds returns the build nodes whose package is also represented by a 'PreBuilt' node.
+// Such packages are both scheduled to be built and treated as already available by the cycle fixing logic.
+// The nodes are sorted by ID.
+func (g *PkgGraph) PrebuiltShadowedBuilds() (shadowedBuilds []*PkgNode) {
+	for _, node := range g.AllNodes() {
+		if node.Type != TypePreBuilt {
+			continue
+		}
+
+		lookupEntry, err := g.FindExactPkgNodeFromPkg(node.VersionedPkg)
+		if err != nil || lookupEntry == nil || lookupEntry.BuildNode == nil {
+			continue
+		}
+
+		shadowedBuilds = append(shadowedBuilds, lookupEntry.BuildNode)
+	}
+
+	sortNodesByID(shadowedBuilds)
+	shadowedBuilds = dedupSortedNodes(shadowedBuilds)
+
+	return
+}
+
+// sortNodesByID sorts a list of nodes by their IDs.
+func sortNodesByID(nodes []*PkgNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID() < nodes[j].ID()
+	})
+}
+
+// sortEdgesByID sorts edges by the ID of the node they come from, then by the ID of the node they lead to.
+func sortEdgesByID(edges []graph.Edge) {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From().ID() != edges[j].From().ID() {
+			return edges[i].From().ID() < edges[j].From().ID()
+		}
+		return edges[i].To().ID() < edges[j].To().ID()
+	})
+}
+
+// dedupSortedNodes removes repeated nodes from a list sorted by ID.
+func dedupSortedNodes(nodes []*PkgNode) []*PkgNode {
+	if len(nodes) == 0 {
+		return nodes
+	}
+
+	endOfUniqueNodes := 1
+	for _, n := range nodes[1:] {
+		if n.ID() != nodes[endOfUniqueNodes-1].ID() {
+			nodes[endOfUniqueNodes] = n
+			endOfUniqueNodes++
+		}
+	}
+
+	return nodes[:endOfUniqueNodes]
+}
+
+// NodesByCondition returns the nodes whose primary version condition (ie ">=", "=") matches condition exactly.
+// Nodes without package information are skipped. The nodes are sorted by ID.
+func (g *PkgGraph) NodesByCondition(condition string) (nodes []*PkgNode) {
+	for _, node := range g.AllNodes() {
+		if node.VersionedPkg != nil && node.VersionedPkg.Condition == condition {
+			nodes = append(nodes, node)
+		}
+	}
+	sortNodesByID(nodes)
+	return
+}
+
+// FindNodesByState returns the nodes in the given state, sorted by ID.
+func (g *PkgGraph) FindNodesByState(state NodeState) (nodes []*PkgNode) {
+	for _, node := range g.AllNodes() {
+		if node.State == state {
+			nodes = append(nodes, node)
+		}
+	}
+	sortNodesByID(nodes)
+	return
+}
+
+// FindNodesByType returns the nodes of the given type, sorted by ID.
+func (g *PkgGraph) FindNodesByType(nodeType NodeType) (nodes []*PkgNode) {
+	for _, node := range g.AllNodes() {
+		if node.Type == nodeType {
+			nodes = append(nodes, node)
+		}
+	}
+	sortNodesByID(nodes)
+	return
+}
+
+// NodesCanonical returns every node in the graph sorted into a canonical order, to be used whenever output must
+// not depend on the internal layout of the graph. Nodes are ordered by:
+//   - package name (goal name for goal nodes, nodes with neither sort first)
+//   - version interval (nodes without package information sort first)
+//   - architecture, type, then state
+//   - as a tiebreak: SRPM path, RPM path, spec path, source dir, source repo, implicit, pinned, then node ID
+func (g *PkgGraph) NodesCanonical() (nodes []*PkgNode) {
+	nodes = g.AllNodes()
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return compareNodesCanonical(nodes[i], nodes[j]) < 0
+	})
+	return
+}
+
+// edgesCanonical returns every edge in the graph sorted by the NodesCanonical position of the node it comes from,
+// then of the node it leads to.
+func (g *PkgGraph) edgesCanonical() (edges []graph.Edge) {
+	rank := make(map[int64]int, g.Nodes().Len())
+	for i, n := range g.NodesCanonical() {
+		rank[n.ID()] = i
+	}
+
+	edges = graph.EdgesOf(g.Edges())
+	sort.Slice(edges, func(i, j int) bool {
+		if rank[edges[i].From().ID()] != rank[edges[j].From().ID()] {
+			return rank[edges[i].From().ID()] < rank[edges[j].From().ID()]
+		}
+		return rank[edges[i].To().ID()] < rank[edges[j].To().ID()]
+	})
+	return
+}
+
+// compareNodesCanonical compares two nodes using the ordering documented on NodesCanonical.
+// Returns a negative value if a sorts first, a positive value if b sorts first, and 0 if they are the same node.
+func compareNodesCanonical(a, b *PkgNode) int {
+	if result := compareNodesIgnoringID(a, b); result != 0 {
+		return result
+	}
+	return compareInt64(a.ID(), b.ID())
+}
+
+// compareNodesIgnoringID compares two nodes by content alone using the ordering documented on NodesCanonical.
+func compareNodesIgnoringID(a, b *PkgNode) int {
+	if result := strings.Compare(canonicalName(a), canonicalName(b)); result != 0 {
+		return result
+	}
+
+	switch {
+	case a.VersionedPkg == nil && b.VersionedPkg != nil:
+		return -1
+	case a.VersionedPkg != nil && b.VersionedPkg == nil:
+		return 1
+	case a.VersionedPkg != nil && b.VersionedPkg != nil:
+		intervalA, _ := a.VersionedPkg.Interval()
+		intervalB, _ := b.VersionedPkg.Interval()
+		if result := intervalA.Compare(&intervalB); result != 0 {
+			return result
+		}
+		// Distinct version strings may produce equal intervals, keep them apart.
+		if result := strings.Compare(versionConstraintString(a.VersionedPkg), versionConstraintString(b.VersionedPkg)); result != 0 {
+			return result
+		}
+	}
+
+	if result := strings.Compare(a.Architecture, b.Architecture); result != 0 {
+		return result
+	}
+	if result := compareInt64(int64(a.Type), int64(b.Type)); result != 0 {
+		return result
+	}
+	if result := compareInt64(int64(a.State), int64(b.State)); result != 0 {
+		return result
+	}
+
+	for _, fields := range [][2]string{
+		{a.SrpmPath, b.SrpmPath},
+		{a.RpmPath, b.RpmPath},
+		{a.SpecPath, b.SpecPath},
+		{a.SourceDir, b.SourceDir},
+		{a.SourceRepo, b.SourceRepo},
+	} {
+		if result := strings.Compare(fields[0], fields[1]); result != 0 {
+			return result
+		}
+	}
+
+	switch {
+	case !a.Implicit && b.Implicit:
+		return -1
+	case a.Implicit && !b.Implicit:
+		return 1
+	}
+
+	switch {
+	case !a.Pinned && b.Pinned:
+		return -1
+	case a.Pinned && !b.Pinned:
+		return 1
+	}
+
+	return 0
+}
+
+// canonicalName returns the name a node is sorted by: the package name, the goal name for goal nodes,
+// or an empty string if the node has neither.
+func canonicalName(n *PkgNode) string {
+	switch {
+	case n.Type == TypeGoal:
+		return n.GoalName
+	case n.VersionedPkg != nil:
+		return n.VersionedPkg.Name
+	default:
+		return ""
+	}
+}
+
+// compareInt64 returns -1, 0, or 1 if a is less than, equal to, or greater than b.
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Architectures returns the sorted set of distinct, non-empty architectures of the nodes in the graph.
+func (g *PkgGraph) Architectures() (architectures []string) {
+	seen := make(map[string]bool)
+	for _, n := range g.AllNodes() {
+		if n.Architecture != "" && !seen[n.Architecture] {
+			seen[n.Architecture] = true
+			architectures = append(architectures, n.Architecture)
+		}
+	}
+	sort.Strings(architectures)
+
+	return
+}
+
+// SRPMBuildPrerequisites returns the nodes which must be installed to build the given SRPM: the run, remote, and
+// prebuilt nodes its build nodes directly depend on. Meta nodes are looked through to the nodes they stand for.
+// Nodes from the same SRPM are excluded. The nodes are deduplicated and sorted by ID.
+func (g *PkgGraph) SRPMBuildPrerequisites(srpmPath string) (prerequisites []*PkgNode, err error) {
+	var (
+		buildNodes []*PkgNode
+		visited    = make(map[int64]bool)
+	)
+
+	for _, n := range g.AllBuildNodes() {
+		if n.SrpmPath == srpmPath {
+			buildNodes = append(buildNodes, n)
+		}
+	}
+	if len(buildNodes) == 0 {
+		err = fmt.Errorf("could not find any build nodes for SRPM '%s'", srpmPath)
+		return
+	}
+
+	for _, buildNode := range buildNodes {
+		for _, dependency := range g.packageDependencies(buildNode, visited) {
+			if dependency.SrpmPath != srpmPath {
+				prerequisites = append(prerequisites, dependency)
+			}
+		}
+	}
+	sortNodesByID(prerequisites)
+
+	return
+}
+
+// SRPMToRPMs maps each distinct SRPM of the local run nodes to the sorted, deduplicated list of RPMs it produces.
+// Run nodes without an RPM path (empty or "<NO_RPM_PATH>") are skipped.
+func (g *PkgGraph) SRPMToRPMs() (srpmRPMs map[string][]string) {
+	srpmRPMs = make(map[string][]string)
+	seen := make(map[string]map[string]bool)
+
+	for _, n := range g.AllRunNodes() {
+		if n.Type != TypeRun || n.SrpmPath == "" || n.SrpmPath == "<NO_SRPM_PATH>" {
+			continue
+		}
+		if n.RpmPath == "" || n.RpmPath == "<NO_RPM_PATH>" {
+			continue
+		}
+
+		if seen[n.SrpmPath] == nil {
+			seen[n.SrpmPath] = make(map[string]bool)
+		}
+		if seen[n.SrpmPath][n.RpmPath] {
+			continue
+		}
+		seen[n.SrpmPath][n.RpmPath] = true
+		srpmRPMs[n.SrpmPath] = append(srpmRPMs[n.SrpmPath], n.RpmPath)
+	}
+
+	for _, rpmPaths := range srpmRPMs {
+		sort.Strings(rpmPaths)
+	}
+
+	return
+}
+
+// LeafNodes returns the nodes which don't depend on anything, sorted by ID. Goal and meta nodes are only included
+// if includeMeta is set.
+func (g *PkgGraph) LeafNodes(includeMeta bool) (leaves []*PkgNode) {
+	for _, n := range g.AllNodes() {
+		if !includeMeta && (n.Type == TypeGoal || n.Type == TypePureMeta) {
+			continue
+		}
+		if g.From(n.ID()).Len() == 0 {
+			leaves = append(leaves, n)
+		}
+	}
+	sortNodesByID(leaves)
+
+	return
+}
+
+// RootNodes returns the nodes nothing depends on, sorted by ID. Goal nodes are never included since they are roots
+// by design, and meta nodes are only included if includeMeta is set.
+func (g *PkgGraph) RootNodes(includeMeta bool) (roots []*PkgNode) {
+	for _, n := range g.AllNodes() {
+		if n.Type == TypeGoal || (!includeMeta && n.Type == TypePureMeta) {
+			continue
+		}
+		if g.To(n.ID()).Len() == 0 {
+			roots = append(roots, n)
+		}
+	}
+	sortNodesByID(roots)
+
+	return
+}
+
+// DependsOn returns true if a directly depends on b, ie there is an edge from a to b.
+func (g *PkgGraph) DependsOn(a, b *PkgNode) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return g.HasEdgeFromTo(a.ID(), b.ID())
+}
+
+// DirectDependencies returns the nodes n directly depends on, sorted by ID.
+func (g *PkgGraph) DirectDependencies(n *PkgNode) (dependencies []*PkgNode) {
+	if n == nil {
+		return
+	}
+	for _, dependency := range graph.NodesOf(g.From(n.ID())) {
+		dependencies = append(dependencies, dependency.(*PkgNode).This)
+	}
+	sortNodesByID(dependencies)
+
+	return
+}
+
+// ResolveAll resolves every package in pkgVers through FindBestPkgNode. Packages which resolve are returned in
+// resolved, and those which can't be satisfied by any node are returned in unresolved, in their original order.
+// Returns an error only if a lookup fails outright (ie a malformed version), in which case no results are returned.
+func (g *PkgGraph) ResolveAll(pkgVers []*pkgjson.PackageVer) (resolved map[*pkgjson.PackageVer]*LookupNode, unresolved []*pkgjson.PackageVer, err error) {
+	resolved = make(map[*pkgjson.PackageVer]*LookupNode, len(pkgVers))
+	for _, pkgVer := range pkgVers {
+		var lookupEntry *LookupNode
+		lookupEntry, err = g.FindBestPkgNode(pkgVer)
+		if err != nil {
+			err = fmt.Errorf("failed to resolve '%s': %w", pkgVer, err)
+			return nil, nil, err
+		}
+
+		if lookupEntry == nil {
+			unresolved = append(unresolved, pkgVer)
+		} else {
+			resolved[pkgVer] = lookupEntry
+		}
+	}
+
+	return
+}
+
+// LocallyBuildableRemotes returns the remote nodes whose package name also has a local build node in the lookup
+// table, meaning the package could be built locally instead of being fetched. Version constraints are not
+// considered. The nodes are sorted by ID.
+func (g *PkgGraph) LocallyBuildableRemotes() (remotes []*PkgNode) {
+	for _, n := range g.AllNodes() {
+		if n.Type != TypeRemote {
+			continue
+		}
+		for _, lookupEntry := range g.lookupTable()[n.VersionedPkg.Name] {
+			if lookupEntry.BuildNode != nil {
+				remotes = append(remotes, n)
+				break
+			}
+		}
+	}
+	sortNodesByID(remotes)
+
+	return
+}
+
+// packageDependencies returns the run, remote, and prebuilt nodes n directly depends on, looking through meta nodes
+// to the nodes they stand for. Nodes already in visited are skipped, and every node encountered is added to it,
+// so visited may be shared to collect the dependencies of several nodes without duplicates.
+func (g *PkgGraph) packageDependencies(n *PkgNode, visited map[int64]bool) (dependencies []*PkgNode) {
+	for _, dependency := range graph.NodesOf(g.From(n.ID())) {
+		dependencyNode := dependency.(*PkgNode).This
+		if visited[dependencyNode.ID()] {
+			continue
+		}
+		visited[dependencyNode.ID()] = true
+
+		switch dependencyNode.Type {
+		case TypePureMeta:
+			dependencies = append(dependencies, g.packageDependencies(dependencyNode, visited)...)
+		case TypeRun, TypeRemote, TypePreBuilt:
+			dependencies = append(dependencies, dependencyNode)
+		}
+	}
+	return
+}
@@ -0,0 +1,345 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Make sure the resolved package list for a goal is deduplicated and sorted
+func TestResolvedPackageList(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	_, err = g.AddGoalNode("test", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+
+	packages, err := g.ResolvedPackageList("test")
+	assert.NoError(t, err)
+
+	expected := []*pkgjson.PackageVer{&pkgA, &pkgB, &pkgC, &pkgD1, &pkgD2, &pkgD3}
+	assert.Equal(t, len(expected), len(packages))
+	for i := range expected {
+		assert.Equal(t, *expected[i], *packages[i])
+	}
+}
+
+func TestResolvedPackageListMissingGoal(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	_, err = g.ResolvedPackageList("no_such_goal")
+	assert.Error(t, err)
+}
+
+// Make sure build nodes which also have a 'PreBuilt' node are reported
+func TestPrebuiltShadowedBuilds(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.Empty(t, g.PrebuiltShadowedBuilds())
+
+	c, err := g.FindExactPkgNodeFromPkg(&pkgC)
+	assert.NoError(t, err)
+
+	preBuiltNode := g.CloneNode(c.RunNode)
+	preBuiltNode.State = StateUpToDate
+	preBuiltNode.Type = TypePreBuilt
+	g.AddNode(preBuiltNode)
+
+	shadowed := g.PrebuiltShadowedBuilds()
+	assert.Equal(t, []*PkgNode{c.BuildNode}, shadowed)
+}
+
+// Make sure nodes can be found by their version condition
+func TestNodesByCondition(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	nodes := g.NodesByCondition(">=")
+	assert.Equal(t, 1, len(nodes))
+	assert.True(t, nodes[0].Equal(pkgD4Unresolved))
+
+	nodes = g.NodesByCondition(">")
+	assert.Equal(t, 2, len(nodes))
+	assert.Less(t, nodes[0].ID(), nodes[1].ID())
+
+	// Run and build nodes for A, B, C, C2 plus D2 which only has a secondary condition
+	assert.Equal(t, len(runNodes)+len(buildNodes)+1, len(g.NodesByCondition("")))
+	assert.Empty(t, g.NodesByCondition("<="))
+}
+
+func TestFindNodesByStateAndType(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	assert.Equal(t, len(unresolvedNodes), len(g.FindNodesByState(StateUnresolved)))
+	assert.Equal(t, len(unresolvedNodes), len(g.FindNodesByType(TypeRemote)))
+	assert.Equal(t, len(buildNodes), len(g.FindNodesByType(TypeBuild)))
+	assert.Empty(t, g.FindNodesByState(StateBuildError))
+	assert.Empty(t, g.FindNodesByType(TypeGoal))
+
+	bBuild := findTestNode(t, g, pkgBBuild)
+	aBuild := findTestNode(t, g, pkgABuild)
+	bBuild.State = StateBuildError
+	aBuild.State = StateBuildError
+	expected := []*PkgNode{aBuild, bBuild}
+	sortNodesByID(expected)
+	assert.Equal(t, expected, g.FindNodesByState(StateBuildError))
+	assert.Equal(t, len(buildNodes)-2, len(g.FindNodesByState(StateBuild)))
+}
+
+// Make sure the canonical node order only depends on node contents
+func TestNodesCanonical(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("test", nil, false)
+	assert.NoError(t, err)
+
+	nodes := g.NodesCanonical()
+	assert.Equal(t, len(allNodes)+1, len(nodes))
+
+	expected := []*PkgNode{
+		pkgABuild, pkgARun, pkgBBuild, pkgBRun, pkgCBuild, pkgCRun, pkgC2Build, pkgC2Run,
+		pkgD1Unresolved, pkgD2Unresolved, pkgD3Unresolved, pkgD4Unresolved, pkgD5Unresolved, pkgD6Unresolved,
+	}
+	for i, n := range expected {
+		assert.True(t, n.Equal(nodes[i]), "expected %s at position %d, got %s", n, i, nodes[i])
+	}
+	assert.Equal(t, TypeGoal, nodes[len(nodes)-1].Type)
+
+	// Build the same graph with the nodes inserted in reverse order, the canonical order must not change
+	reversed := NewPkgGraph()
+	for i := len(allNodes) - 1; i >= 0; i-- {
+		if allNodes[i].Type == TypeBuild {
+			continue
+		}
+		_, err = addNodeToGraphHelper(reversed, allNodes[i])
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, addNodesHelper(reversed, buildNodes))
+	_, err = reversed.AddGoalNode("test", nil, false)
+	assert.NoError(t, err)
+
+	reversedNodes := reversed.NodesCanonical()
+	for i := range nodes {
+		assert.True(t, nodes[i].Equal(reversedNodes[i]))
+	}
+}
+
+// Make sure architectures are deduplicated and sorted, ignoring nodes without one
+func TestArchitectures(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("test", nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test_arch"}, g.Architectures())
+
+	_, err = g.AddPkgNode(&pkgjson.PackageVer{Name: "E", Version: "1"}, StateMeta, TypeRun, "E.src.rpm", "E.rpm", "E.spec", "", "aarch64", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"aarch64", "test_arch"}, g.Architectures())
+
+	assert.Empty(t, NewPkgGraph().Architectures())
+}
+
+// Make sure an SRPM's direct build dependencies are resolved through meta nodes, skipping its own nodes
+func TestSRPMBuildPrerequisites(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	aBuild := findTestNode(t, g, pkgABuild)
+	bRun := findTestNode(t, g, pkgBRun)
+	c2Run := findTestNode(t, g, pkgC2Run)
+
+	prerequisites, err := g.SRPMBuildPrerequisites(pkgABuild.SrpmPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []*PkgNode{bRun}, prerequisites)
+
+	g.AddMetaNode([]*PkgNode{aBuild}, []*PkgNode{c2Run, bRun, findTestNode(t, g, pkgARun)})
+
+	prerequisites, err = g.SRPMBuildPrerequisites(pkgABuild.SrpmPath)
+	assert.NoError(t, err)
+	expected := []*PkgNode{bRun, c2Run}
+	sortNodesByID(expected)
+	assert.Equal(t, expected, prerequisites)
+
+	_, err = g.SRPMBuildPrerequisites("missing.src.rpm")
+	assert.Error(t, err)
+}
+
+// Make sure a batch reports every unresolved package instead of stopping at the first
+func TestResolveAll(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	missing1 := &pkgjson.PackageVer{Name: "missing1"}
+	missing2 := &pkgjson.PackageVer{Name: "C", Version: "5", Condition: ">="}
+	resolved, unresolved, err := g.ResolveAll([]*pkgjson.PackageVer{&pkgA, missing1, &pkgC, missing2})
+	assert.NoError(t, err)
+	assert.Equal(t, []*pkgjson.PackageVer{missing1, missing2}, unresolved)
+	assert.Equal(t, 2, len(resolved))
+	assert.True(t, resolved[&pkgA].RunNode.Equal(pkgARun))
+	assert.True(t, resolved[&pkgC].RunNode.Equal(pkgCRun))
+
+	_, _, err = g.ResolveAll([]*pkgjson.PackageVer{&pkgA, {Name: "A", Version: "1", Condition: "bad"}})
+	assert.Error(t, err)
+}
+
+// Make sure remote nodes are reported only when a local build of the same package exists
+func TestLocallyBuildableRemotes(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.Empty(t, g.LocallyBuildableRemotes())
+
+	remoteB, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "B", Version: "5", Condition: ">="}, StateUnresolved, TypeRemote, "", "", "", "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []*PkgNode{remoteB}, g.LocallyBuildableRemotes())
+}
+
+func TestSRPMToRPMs(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	findTestNode(t, g, pkgC2Run).RpmPath = "C2.rpm"
+	eRun, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "E", Version: "1"}, StateMeta, TypeRun, "E.src.rpm", "<NO_RPM_PATH>", "E.spec", "", "test_arch", "")
+	assert.NoError(t, err)
+	assert.NotNil(t, eRun)
+
+	// Remote nodes and nodes without RPMs are skipped, C and C2 share an SRPM
+	assert.Equal(t, map[string][]string{
+		"A.src.rpm": {"A.rpm"},
+		"B.src.rpm": {"B.rpm"},
+		"C.src.rpm": {"C.rpm", "C2.rpm"},
+	}, g.SRPMToRPMs())
+}
+
+func TestNodesBySRPM(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	expected := []*PkgNode{
+		findTestNode(t, g, pkgCRun), findTestNode(t, g, pkgC2Run), findTestNode(t, g, pkgCBuild), findTestNode(t, g, pkgC2Build),
+	}
+	sortNodesByID(expected)
+	assert.Equal(t, expected, g.NodesBySRPM("C.src.rpm"))
+	assert.Empty(t, g.NodesBySRPM("E.src.rpm"))
+
+	// Added and removed nodes are tracked
+	eRun, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "E", Version: "1"}, StateMeta, TypeRun, "E.src.rpm", "E.rpm", "E.spec", "", "test_arch", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []*PkgNode{eRun}, g.NodesBySRPM("E.src.rpm"))
+	g.RemovePkgNode(eRun)
+	assert.Empty(t, g.NodesBySRPM("E.src.rpm"))
+
+	// Collapsed nodes are replaced by the new node
+	aRun := findTestNode(t, g, pkgARun)
+	bRun := findTestNode(t, g, pkgBRun)
+	bBuild := findTestNode(t, g, pkgBBuild)
+	collapsed, err := g.CreateCollapsedNode(&pkgjson.PackageVer{Name: "B2", Version: "1"}, aRun, []*PkgNode{bRun})
+	assert.NoError(t, err)
+	assert.Equal(t, []*PkgNode{bBuild}, g.NodesBySRPM("B.src.rpm"))
+	assert.Contains(t, g.NodesBySRPM("A.src.rpm"), collapsed)
+
+	// Graphs read from DOT build the index with the lookup table
+	var buf bytes.Buffer
+	gOut, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.NoError(t, WriteDOTGraph(gOut, &buf))
+	gIn := NewPkgGraph()
+	assert.NoError(t, ReadDOTGraph(gIn, &buf))
+	assert.Equal(t, 4, len(gIn.NodesBySRPM("C.src.rpm")))
+}
+
+func TestFindNodeByRPMPath(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	aRun := findTestNode(t, g, pkgARun)
+	n, err := g.FindNodeByRPMPath("A.rpm")
+	assert.NoError(t, err)
+	assert.Equal(t, aRun, n)
+
+	n, err = g.FindNodeByRPMPath("E.rpm")
+	assert.NoError(t, err)
+	assert.Nil(t, n)
+
+	// C and C2 claim the same RPM
+	_, err = g.FindNodeByRPMPath("C.rpm")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), findTestNode(t, g, pkgC2Run).FriendlyName())
+
+	// Added and removed nodes are tracked
+	eRun, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "E", Version: "1"}, StateMeta, TypeRun, "E.src.rpm", "E.rpm", "E.spec", "", "test_arch", "")
+	assert.NoError(t, err)
+	n, err = g.FindNodeByRPMPath("E.rpm")
+	assert.NoError(t, err)
+	assert.Equal(t, eRun, n)
+	g.RemovePkgNode(eRun)
+	n, err = g.FindNodeByRPMPath("E.rpm")
+	assert.NoError(t, err)
+	assert.Nil(t, n)
+
+	// Remote nodes are never found
+	n, err = g.FindNodeByRPMPath("<NO_RPM_PATH>")
+	assert.NoError(t, err)
+	assert.Nil(t, n)
+}
+
+func TestLeafAndRootNodes(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	leaves := []*PkgNode{
+		findTestNode(t, g, pkgCBuild), findTestNode(t, g, pkgC2Build),
+		findTestNode(t, g, pkgD1Unresolved), findTestNode(t, g, pkgD2Unresolved), findTestNode(t, g, pkgD3Unresolved),
+		findTestNode(t, g, pkgD4Unresolved), findTestNode(t, g, pkgD5Unresolved), findTestNode(t, g, pkgD6Unresolved),
+	}
+	sortNodesByID(leaves)
+	assert.Equal(t, leaves, g.LeafNodes(false))
+	roots := []*PkgNode{findTestNode(t, g, pkgARun), findTestNode(t, g, pkgC2Run)}
+	sortNodesByID(roots)
+	assert.Equal(t, roots, g.RootNodes(false))
+
+	// Meta and goal nodes are only reported on request, and goal nodes are never roots. A is no longer a root once
+	// the goal depends on it.
+	roots = []*PkgNode{findTestNode(t, g, pkgC2Run)}
+	metaNode := g.AddMetaNode(nil, []*PkgNode{findTestNode(t, g, pkgD1Unresolved)})
+	emptyMetaNode := g.AddMetaNode(nil, nil)
+	_, err = g.AddGoalNode("test", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+
+	assert.Equal(t, leaves, g.LeafNodes(false))
+	assert.Equal(t, roots, g.RootNodes(false))
+	assert.Equal(t, append(leaves, emptyMetaNode), g.LeafNodes(true))
+	assert.Equal(t, append(roots, metaNode, emptyMetaNode), g.RootNodes(true))
+}
+
+func TestDependsOnAndDirectDependencies(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	c2Run := findTestNode(t, g, pkgC2Run)
+	expected := []*PkgNode{
+		findTestNode(t, g, pkgC2Build),
+		findTestNode(t, g, pkgD4Unresolved),
+		findTestNode(t, g, pkgD5Unresolved),
+		findTestNode(t, g, pkgD6Unresolved),
+	}
+	sortNodesByID(expected)
+	assert.Equal(t, expected, g.DirectDependencies(c2Run))
+	assert.Empty(t, g.DirectDependencies(expected[0]))
+	assert.Empty(t, g.DirectDependencies(nil))
+
+	aRun := findTestNode(t, g, pkgARun)
+	aBuild := findTestNode(t, g, pkgABuild)
+	assert.True(t, g.DependsOn(aRun, aBuild))
+	assert.False(t, g.DependsOn(aBuild, aRun))
+	// Only direct dependencies count
+	assert.False(t, g.DependsOn(aRun, findTestNode(t, g, pkgBRun)))
+	assert.False(t, g.DependsOn(aRun, nil))
+}
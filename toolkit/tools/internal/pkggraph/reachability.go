@@ -0,0 +1,335 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/topo"
+	"gonum.org/v1/gonum/graph/traverse"
+)
+
+// TransitiveClosure returns, for every node ID, the set of node IDs reachable from that node (excluding itself).
+// The graph must be a DAG (see MakeDAG). Each node's set is computed once by merging the sets of its direct
+// dependencies, in reverse topological order.
+func (g *PkgGraph) TransitiveClosure() (closure map[int64]map[int64]bool, err error) {
+	sorted, err := topo.Sort(g)
+	if err != nil {
+		err = fmt.Errorf("unable to compute transitive closure, graph is not a DAG: %s", err)
+		return
+	}
+
+	closure = make(map[int64]map[int64]bool, len(sorted))
+	// Dependencies are sorted after their dependents, walk backwards so they are always resolved first.
+	for i := len(sorted) - 1; i >= 0; i-- {
+		id := sorted[i].ID()
+		reachable := make(map[int64]bool)
+		for _, dependency := range graph.NodesOf(g.From(id)) {
+			dependencyID := dependency.ID()
+			reachable[dependencyID] = true
+			for transitiveID := range closure[dependencyID] {
+				reachable[transitiveID] = true
+			}
+		}
+		closure[id] = reachable
+	}
+
+	return
+}
+
+// TransitiveClosureGraph returns a new graph with the same nodes as this one (copied, keeping their IDs) where each
+// node has a direct edge to every node reachable from it in this graph, so "does A transitively depend on B" can be
+// answered with a single HasEdgeFromTo call. Unlike TransitiveClosure the graph may contain cycles; nodes in a
+// cycle never get an edge to themselves. Recorded edge constraints are kept on the original edges, the added edges
+// have none.
+// This runs a full traversal from every node, so it takes O(V*E) time and the result may have O(V^2) edges. Only
+// use it when the graph will be queried many times.
+func (g *PkgGraph) TransitiveClosureGraph() (closureGraph *PkgGraph, err error) {
+	closureGraph, err = g.copyGraphPreservingIDs()
+	if err != nil {
+		return
+	}
+
+	// graph manipulation calls may panic on error
+	defer func() {
+		if r := recover(); r != nil {
+			closureGraph = nil
+			err = fmt.Errorf("building transitive closure graph failed: %s", r)
+		}
+	}()
+
+	for _, n := range g.AllNodes() {
+		from := closureGraph.Node(n.ID())
+		search := traverse.DepthFirst{}
+		search.Walk(g, n, func(reached graph.Node) bool {
+			if reached.ID() != n.ID() && !closureGraph.HasEdgeFromTo(n.ID(), reached.ID()) {
+				closureGraph.SetEdge(closureGraph.NewEdge(from, closureGraph.Node(reached.ID())))
+			}
+			return false
+		})
+	}
+
+	return
+}
+
+// ReachableFrom returns the set of IDs of every node reachable from any of the roots, including the roots
+// themselves. All roots share a single traversal, so each node is visited at most once.
+func (g *PkgGraph) ReachableFrom(roots []*PkgNode) (reachable map[int64]bool) {
+	reachable = make(map[int64]bool)
+	search := traverse.DepthFirst{}
+	for _, root := range roots {
+		search.Walk(g, root, func(n graph.Node) bool {
+			reachable[n.ID()] = true
+			return false
+		})
+	}
+	return
+}
+
+// ReachableSet returns every node reachable from root, including root itself, keyed by ID. It finds the same nodes
+// as AllNodesFrom, but walks the graph breadth first and expands each level of the search across a pool of 'workers'
+// goroutines. The set of visited nodes is only read while a level is expanded: each worker collects the new nodes it
+// finds on its own and merges them once it is done. If workers is 1 or less the graph is walked serially. The graph
+// must not be modified while the search runs.
+func (g *PkgGraph) ReachableSet(root *PkgNode, workers int) (reachable map[int64]*PkgNode) {
+	var (
+		mutex      sync.Mutex
+		candidates []*PkgNode
+	)
+
+	reachable = map[int64]*PkgNode{root.ID(): root.This}
+
+	// expand appends the dependencies of n which were not reached by a previous level to found
+	expand := func(n *PkgNode, found []*PkgNode) []*PkgNode {
+		for _, dependency := range graph.NodesOf(g.From(n.ID())) {
+			if _, visited := reachable[dependency.ID()]; !visited {
+				found = append(found, dependency.(*PkgNode).This)
+			}
+		}
+		return found
+	}
+
+	level := []*PkgNode{root.This}
+	for len(level) > 0 {
+		candidates = nil
+
+		if workers <= 1 || len(level) == 1 {
+			for _, n := range level {
+				candidates = expand(n, candidates)
+			}
+		} else {
+			var waitGroup sync.WaitGroup
+			pending := make(chan *PkgNode)
+			for i := 0; i < workers; i++ {
+				waitGroup.Add(1)
+				go func() {
+					defer waitGroup.Done()
+					var found []*PkgNode
+					for n := range pending {
+						found = expand(n, found)
+					}
+
+					mutex.Lock()
+					candidates = append(candidates, found...)
+					mutex.Unlock()
+				}()
+			}
+			for _, n := range level {
+				pending <- n
+			}
+			close(pending)
+			waitGroup.Wait()
+		}
+
+		// Several nodes of the level may share a dependency, only keep the first one
+		level = nil
+		for _, n := range candidates {
+			if _, visited := reachable[n.ID()]; !visited {
+				reachable[n.ID()] = n
+				level = append(level, n)
+			}
+		}
+	}
+
+	return
+}
+
+// DependencyPath returns one of the shortest chains of dependencies leading from 'from' to 'to', starting with
+// 'from' and ending with 'to', so each node depends on the one after it. Returns nil if 'to' is not reachable
+// from 'from'. A node's path to itself is just the node.
+func (g *PkgGraph) DependencyPath(from, to *PkgNode) (path []*PkgNode, err error) {
+	if from == nil || to == nil {
+		err = fmt.Errorf("can't find a dependency path without both ends")
+		return
+	}
+	if g.Node(from.ID()) == nil {
+		err = fmt.Errorf("node '%s' is not in the graph", from.FriendlyName())
+		return
+	}
+	if g.Node(to.ID()) == nil {
+		err = fmt.Errorf("node '%s' is not in the graph", to.FriendlyName())
+		return
+	}
+
+	parents := make(map[int64]int64)
+	search := traverse.BreadthFirst{
+		Traverse: func(e graph.Edge) bool {
+			// Breadth first search reaches each node through one of its shortest paths first
+			if _, found := parents[e.To().ID()]; !found && e.To().ID() != from.ID() {
+				parents[e.To().ID()] = e.From().ID()
+			}
+			return true
+		},
+	}
+	found := search.Walk(g, from, func(n graph.Node, _ int) bool {
+		return n.ID() == to.ID()
+	})
+	if found == nil {
+		return
+	}
+
+	for id := to.ID(); id != from.ID(); id = parents[id] {
+		path = append(path, g.Node(id).(*PkgNode).This)
+	}
+	path = append(path, g.Node(from.ID()).(*PkgNode).This)
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	return
+}
+
+// LongestUnresolvedChain returns the longest chain of dependencies ending at a node in the unresolved state,
+// starting with the node furthest from it, so each node depends on the one after it. The chain shows how the
+// build came to need the missing package, which is usually the most useful context when triaging it. Ties are
+// broken towards lower node IDs so identical graphs always produce the same chain. Returns nil if no node is
+// unresolved, or an error if the graph contains a cycle, in which case MakeDAG should be run first.
+func (g *PkgGraph) LongestUnresolvedChain() (chain []*PkgNode, err error) {
+	// length is the number of edges in the longest chain from a node to an unresolved node, nodes which can't reach
+	// one have no entry. next is the node that chain continues with.
+	length := make(map[int64]int)
+	next := make(map[int64]int64)
+	var (
+		start    int64
+		hasChain bool
+	)
+	unvisited := g.walkDependenciesFirst(func(n *PkgNode) {
+		id := n.ID()
+		if n.State == StateUnresolved {
+			length[id] = 0
+		} else {
+			for _, dependency := range graph.NodesOf(g.From(id)) {
+				dependencyLength, reachesUnresolved := length[dependency.ID()]
+				if !reachesUnresolved {
+					continue
+				}
+				currentLength, found := length[id]
+				if !found || dependencyLength+1 > currentLength || (dependencyLength+1 == currentLength && dependency.ID() < next[id]) {
+					length[id] = dependencyLength + 1
+					next[id] = dependency.ID()
+				}
+			}
+		}
+
+		chainLength, found := length[id]
+		if found && (!hasChain || chainLength > length[start] || (chainLength == length[start] && id < start)) {
+			start = id
+			hasChain = true
+		}
+	})
+
+	if unvisited > 0 {
+		err = fmt.Errorf("unable to find the longest unresolved chain, graph contains %d node(s) in or depending on a cycle, run MakeDAG first", unvisited)
+		return
+	}
+
+	if !hasChain {
+		return
+	}
+
+	for id := start; ; {
+		chain = append(chain, g.Node(id).(*PkgNode).This)
+		nextID, hasNext := next[id]
+		if !hasNext {
+			break
+		}
+		id = nextID
+	}
+
+	return
+}
+
+// FullBuildClosure returns every build node which must be built to build pkgName from scratch, ordered so that each
+// node appears after everything it depends on. The package is resolved through the lookup table (the best
+// available version is used). Node states are ignored and prebuilt nodes are followed back to the run node they
+// replaced, so packages which are already available are still included.
+// Returns an error if the closure contains a circular dependency, since it could not be built from scratch.
+func (g *PkgGraph) FullBuildClosure(pkgName string) (buildNodes []*PkgNode, err error) {
+	lookupEntry, err := g.FindBestPkgNode(&pkgjson.PackageVer{Name: pkgName})
+	if err != nil {
+		return
+	}
+	if lookupEntry == nil {
+		err = fmt.Errorf("could not find package '%s'", pkgName)
+		return
+	}
+	if lookupEntry.BuildNode == nil {
+		err = fmt.Errorf("package '%s' is not built from a local SRPM", pkgName)
+		return
+	}
+
+	const (
+		inProgress = iota + 1
+		done
+	)
+	visitState := make(map[int64]int)
+
+	var visit func(node *PkgNode) error
+	visit = func(node *PkgNode) (err error) {
+		switch visitState[node.ID()] {
+		case inProgress:
+			return fmt.Errorf("'%s' can't be built from scratch, it is part of a circular dependency", node.FriendlyName())
+		case done:
+			return
+		}
+		visitState[node.ID()] = inProgress
+
+		dependencies := graph.NodesOf(g.From(node.ID()))
+		if node.Type == TypePreBuilt {
+			var original *LookupNode
+			original, err = g.FindExactPkgNodeFromPkg(node.VersionedPkg)
+			if err != nil {
+				return
+			}
+			if original != nil && original.RunNode != nil {
+				dependencies = append(dependencies, original.RunNode)
+			}
+		}
+
+		for _, dependency := range dependencies {
+			err = visit(dependency.(*PkgNode).This)
+			if err != nil {
+				return
+			}
+		}
+
+		visitState[node.ID()] = done
+		if node.Type == TypeBuild {
+			buildNodes = append(buildNodes, node)
+		}
+		return
+	}
+
+	err = visit(lookupEntry.RunNode)
+	if err != nil {
+		buildNodes = nil
+	}
+
+	return
+}
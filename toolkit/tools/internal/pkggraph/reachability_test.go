@@ -0,0 +1,236 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Make sure the transitive closure matches a direct traversal of every node
+func TestTransitiveClosure(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	closure, err := g.TransitiveClosure()
+	assert.NoError(t, err)
+	assert.Equal(t, len(allNodes), len(closure))
+
+	for _, n := range g.AllNodes() {
+		reachable := g.AllNodesFrom(n)
+		// AllNodesFrom includes the root itself
+		assert.Equal(t, len(reachable)-1, len(closure[n.ID()]))
+		for _, r := range reachable {
+			if r != n {
+				assert.True(t, closure[n.ID()][r.ID()])
+			}
+		}
+	}
+}
+
+func TestTransitiveClosureGraph(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	err = addEdgeHelper(g, *pkgCBuild, *pkgARun)
+	assert.NoError(t, err)
+
+	closureGraph, err := g.TransitiveClosureGraph()
+	assert.NoError(t, err)
+	assert.Equal(t, g.Nodes().Len(), closureGraph.Nodes().Len())
+	// The original graph is left untouched
+	assert.Equal(t, 13, g.Edges().Len())
+
+	for _, n := range g.AllNodes() {
+		copied := closureGraph.Node(n.ID()).(*PkgNode)
+		assert.False(t, copied == n)
+		assert.Equal(t, n.VersionedPkg, copied.VersionedPkg)
+
+		reachable := g.AllNodesFrom(n)
+		// Every node in the cycle reaches itself, but never gets a self loop
+		assert.False(t, closureGraph.HasEdgeFromTo(n.ID(), n.ID()))
+		expected := 0
+		for _, r := range reachable {
+			if r != n {
+				expected++
+				assert.True(t, closureGraph.HasEdgeFromTo(n.ID(), r.ID()))
+			}
+		}
+		assert.Equal(t, expected, closureGraph.From(n.ID()).Len())
+	}
+
+	aRun := findTestNode(t, g, pkgARun)
+	d3 := findTestNode(t, g, pkgD3Unresolved)
+	assert.True(t, closureGraph.HasEdgeFromTo(aRun.ID(), d3.ID()))
+	assert.False(t, closureGraph.HasEdgeFromTo(d3.ID(), aRun.ID()))
+}
+
+func TestTransitiveClosureWithCycle(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	err = addEdgeHelper(g, *pkgCBuild, *pkgARun)
+	assert.NoError(t, err)
+
+	_, err = g.TransitiveClosure()
+	assert.Error(t, err)
+}
+
+// Make sure the from-scratch closure is in build order and looks through prebuilt nodes
+func TestFullBuildClosure(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	aBuild := findTestNode(t, g, pkgABuild)
+	bBuild := findTestNode(t, g, pkgBBuild)
+	bRun := findTestNode(t, g, pkgBRun)
+	cBuild := findTestNode(t, g, pkgCBuild)
+	expected := []*PkgNode{cBuild, bBuild, aBuild}
+
+	closure, err := g.FullBuildClosure("A")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, closure)
+
+	// Replace A's dependency on B with a prebuilt node, B must still be built from scratch
+	prebuiltB := g.CloneNode(bRun)
+	prebuiltB.Type = TypePreBuilt
+	prebuiltB.State = StateUpToDate
+	g.RemoveEdge(aBuild.ID(), bRun.ID())
+	assert.NoError(t, g.AddEdge(aBuild, prebuiltB))
+
+	closure, err = g.FullBuildClosure("A")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, closure)
+
+	_, err = g.FullBuildClosure("missing")
+	assert.Error(t, err)
+	_, err = g.FullBuildClosure("D")
+	assert.Error(t, err)
+
+	assert.NoError(t, g.AddEdge(cBuild, findTestNode(t, g, pkgARun)))
+	_, err = g.FullBuildClosure("A")
+	assert.Error(t, err)
+}
+
+// Make sure reachability from several roots is the union of their individual traversals
+func TestReachableFrom(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	assert.Empty(t, g.ReachableFrom(nil))
+
+	bRun := findTestNode(t, g, pkgBRun)
+	c2Run := findTestNode(t, g, pkgC2Run)
+	reachable := g.ReachableFrom([]*PkgNode{bRun, c2Run, findTestNode(t, g, pkgCRun)})
+
+	expected := make(map[int64]bool)
+	for _, root := range []*PkgNode{bRun, c2Run} {
+		for _, n := range g.AllNodesFrom(root) {
+			expected[n.ID()] = true
+		}
+	}
+	assert.Equal(t, expected, reachable)
+	assert.Equal(t, 6+5, len(reachable))
+}
+
+func TestDependencyPath(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	aRun := findTestNode(t, g, pkgARun)
+	cBuild := findTestNode(t, g, pkgCBuild)
+
+	path, err := g.DependencyPath(aRun, cBuild)
+	assert.NoError(t, err)
+	assert.Equal(t, []*PkgNode{
+		aRun,
+		findTestNode(t, g, pkgABuild),
+		findTestNode(t, g, pkgBRun),
+		findTestNode(t, g, pkgBBuild),
+		findTestNode(t, g, pkgCRun),
+		cBuild,
+	}, path)
+
+	// A shortcut should be preferred over the long chain
+	err = addEdgeHelper(g, *pkgARun, *pkgCRun)
+	assert.NoError(t, err)
+	path, err = g.DependencyPath(aRun, cBuild)
+	assert.NoError(t, err)
+	assert.Equal(t, []*PkgNode{aRun, findTestNode(t, g, pkgCRun), cBuild}, path)
+
+	path, err = g.DependencyPath(aRun, aRun)
+	assert.NoError(t, err)
+	assert.Equal(t, []*PkgNode{aRun}, path)
+}
+
+func TestDependencyPathUnreachable(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	path, err := g.DependencyPath(findTestNode(t, g, pkgCBuild), findTestNode(t, g, pkgARun))
+	assert.NoError(t, err)
+	assert.Nil(t, path)
+
+	path, err = g.DependencyPath(findTestNode(t, g, pkgARun), findTestNode(t, g, pkgC2Run))
+	assert.NoError(t, err)
+	assert.Nil(t, path)
+
+	_, err = g.DependencyPath(nil, findTestNode(t, g, pkgARun))
+	assert.Error(t, err)
+}
+
+// Make sure the parallel search finds the same nodes as AllNodesFrom, with any number of workers
+func TestReachableSet(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("test", nil, false)
+	assert.NoError(t, err)
+	// Add a cycle, each node must still be found once
+	assert.NoError(t, g.AddEdge(findTestNode(t, g, pkgCBuild), findTestNode(t, g, pkgARun)))
+
+	for _, root := range g.AllNodes() {
+		expected := make(map[int64]*PkgNode)
+		for _, n := range g.AllNodesFrom(root) {
+			expected[n.ID()] = n
+		}
+
+		for _, workers := range []int{0, 1, 4} {
+			assert.Equal(t, expected, g.ReachableSet(root, workers))
+		}
+	}
+}
+
+func TestLongestUnresolvedChain(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// C run's dependency on D3 ends the longest chain through A, B, and C
+	chain, err := g.LongestUnresolvedChain()
+	assert.NoError(t, err)
+	assert.Equal(t, []*PkgNode{
+		findTestNode(t, g, pkgARun), findTestNode(t, g, pkgABuild), findTestNode(t, g, pkgBRun),
+		findTestNode(t, g, pkgBBuild), findTestNode(t, g, pkgCRun), findTestNode(t, g, pkgD3Unresolved),
+	}, chain)
+
+	// Resolved nodes don't end chains
+	findTestNode(t, g, pkgD3Unresolved).State = StateCached
+	chain, err = g.LongestUnresolvedChain()
+	assert.NoError(t, err)
+	assert.Equal(t, []*PkgNode{
+		findTestNode(t, g, pkgARun), findTestNode(t, g, pkgABuild), findTestNode(t, g, pkgBRun), findTestNode(t, g, pkgD2Unresolved),
+	}, chain)
+
+	for _, n := range g.AllNodes() {
+		if n.State == StateUnresolved {
+			n.State = StateCached
+		}
+	}
+	chain, err = g.LongestUnresolvedChain()
+	assert.NoError(t, err)
+	assert.Nil(t, chain)
+
+	assert.NoError(t, addEdgeHelper(g, *pkgCBuild, *pkgARun))
+	_, err = g.LongestUnresolvedChain()
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+// BuildReport summarizes the outcome of a build based on the states of the nodes in the graph.
+type BuildReport struct {
+	StateCounts map[NodeState]int    // Number of nodes in each state
+	UpToDate    []*PkgNode           // Nodes which are built and available, sorted by ID
+	BuildErrors []*PkgNode           // Nodes which failed to build, sorted by ID
+	Blocked     map[int64][]*PkgNode // For each failed node ID, the transitive dependents which could not be built because of it, sorted by ID
+}
+
+// BuildReport aggregates the current node states into a BuildReport. A dependent of a failed node is counted as
+// blocked by it unless the dependent is already available (StateUpToDate or StateCached) or failed itself.
+// Goal and meta nodes don't represent build artifacts and are never reported as blocked.
+func (g *PkgGraph) BuildReport() (report BuildReport) {
+	report.StateCounts = make(map[NodeState]int)
+	report.Blocked = make(map[int64][]*PkgNode)
+
+	for _, n := range g.AllNodes() {
+		report.StateCounts[n.State]++
+		switch n.State {
+		case StateUpToDate:
+			report.UpToDate = append(report.UpToDate, n)
+		case StateBuildError:
+			report.BuildErrors = append(report.BuildErrors, n)
+		}
+	}
+	sortNodesByID(report.UpToDate)
+	sortNodesByID(report.BuildErrors)
+
+	for _, errorNode := range report.BuildErrors {
+		report.Blocked[errorNode.ID()] = g.blockedBy(errorNode)
+	}
+
+	return
+}
+
+// blockedBy returns the transitive dependents of a failed node which can't be built because of it, sorted by ID.
+// See BuildReport for which dependents count as blocked.
+func (g *PkgGraph) blockedBy(errorNode *PkgNode) (blocked []*PkgNode) {
+	for _, dependent := range g.AllNodesTo(errorNode) {
+		if dependent == errorNode || dependent.Type == TypeGoal || dependent.Type == TypePureMeta {
+			continue
+		}
+		switch dependent.State {
+		case StateUpToDate, StateCached, StateBuildError:
+			continue
+		}
+		blocked = append(blocked, dependent)
+	}
+	sortNodesByID(blocked)
+
+	return
+}
+
+// GraphStats is a quantitative summary of a graph, see Stats. States and types are keyed by name so the
+// summary can be serialized as is.
+type GraphStats struct {
+	Nodes           int            `json:"Nodes"`           // Total number of nodes
+	Edges           int            `json:"Edges"`           // Total number of edges
+	NodesByType     map[string]int `json:"NodesByType"`     // Number of nodes of each type
+	NodesByState    map[string]int `json:"NodesByState"`    // Number of nodes in each state
+	SRPMs           int            `json:"SRPMs"`           // Number of distinct local SRPMs
+	GoalNodes       int            `json:"GoalNodes"`       // Number of goal nodes
+	UnresolvedNodes int            `json:"UnresolvedNodes"` // Number of nodes in the unresolved state
+}
+
+// Stats summarizes the content of the graph. SRPMs are counted from the paths of local run and build nodes.
+// Nodes with an invalid state or type are counted under "Unknown".
+func (g *PkgGraph) Stats() (stats GraphStats) {
+	const unknownName = "Unknown"
+
+	stats.NodesByType = make(map[string]int)
+	stats.NodesByState = make(map[string]int)
+	srpms := make(map[string]bool)
+
+	for _, n := range g.AllNodes() {
+		stats.Nodes++
+
+		typeName := unknownName
+		if n.Type > TypeUnknown && n.Type <= TypePreBuilt {
+			typeName = n.Type.String()
+		}
+		stats.NodesByType[typeName]++
+
+		stateName := unknownName
+		if n.State > StateUnknown && n.State <= StateMAX {
+			stateName = n.State.String()
+		}
+		stats.NodesByState[stateName]++
+
+		switch n.Type {
+		case TypeRun, TypeBuild:
+			srpms[n.SrpmPath] = true
+		case TypeGoal:
+			stats.GoalNodes++
+		}
+
+		if n.State == StateUnresolved {
+			stats.UnresolvedNodes++
+		}
+	}
+
+	stats.Edges = g.Edges().Len()
+	stats.SRPMs = len(srpms)
+
+	return
+}
@@ -0,0 +1,67 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Make sure failures are reported along with the dependents they block
+func TestBuildReport(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("test", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+
+	report := g.BuildReport()
+	assert.Empty(t, report.BuildErrors)
+	assert.Empty(t, report.UpToDate)
+	assert.Empty(t, report.Blocked)
+
+	cBuild := findTestNode(t, g, pkgCBuild)
+	aRun := findTestNode(t, g, pkgARun)
+	cBuild.State = StateBuildError
+	aRun.State = StateUpToDate
+
+	report = g.BuildReport()
+	assert.Equal(t, []*PkgNode{cBuild}, report.BuildErrors)
+	assert.Equal(t, []*PkgNode{aRun}, report.UpToDate)
+	assert.Equal(t, 1, report.StateCounts[StateBuildError])
+	assert.Equal(t, 1, report.StateCounts[StateUpToDate])
+
+	expected := []*PkgNode{
+		findTestNode(t, g, pkgCRun), findTestNode(t, g, pkgBBuild), findTestNode(t, g, pkgBRun), findTestNode(t, g, pkgABuild),
+	}
+	sortNodesByID(expected)
+	assert.Equal(t, expected, report.Blocked[cBuild.ID()])
+
+	total := 0
+	for _, count := range report.StateCounts {
+		total += count
+	}
+	assert.Equal(t, g.Nodes().Len(), total)
+}
+
+func TestStats(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("test", []*pkgjson.PackageVer{&pkgA}, true)
+	assert.NoError(t, err)
+
+	stats := g.Stats()
+	assert.Equal(t, len(allNodes)+1, stats.Nodes)
+	assert.Equal(t, 12+1, stats.Edges)
+	assert.Equal(t, map[string]int{"Run": 4, "Build": 4, "Remote": 6, "Goal": 1}, stats.NodesByType)
+	assert.Equal(t, map[string]int{"Meta": 5, "Build": 4, "Unresolved": 6}, stats.NodesByState)
+	// C and C2 share an SRPM
+	assert.Equal(t, 3, stats.SRPMs)
+	assert.Equal(t, 1, stats.GoalNodes)
+	assert.Equal(t, 6, stats.UnresolvedNodes)
+
+	assert.Equal(t, GraphStats{NodesByType: map[string]int{}, NodesByState: map[string]int{}}, NewPkgGraph().Stats())
+}
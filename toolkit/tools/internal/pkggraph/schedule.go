@@ -0,0 +1,267 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/graph"
+)
+
+// MaxParallelism returns the largest number of build nodes which could ever be built at the same time, the size of
+// the largest set of build nodes where no node (transitively) depends on another.
+// By Dilworth's theorem this equals the number of build nodes minus the size of a maximum matching between build
+// nodes u and v where v is reachable from u. The graph must be a DAG (see MakeDAG).
+func (g *PkgGraph) MaxParallelism() (parallelism int, err error) {
+	closure, err := g.TransitiveClosure()
+	if err != nil {
+		return
+	}
+
+	buildNodes := g.AllBuildNodes()
+	sortNodesByID(buildNodes)
+	index := make(map[int64]int, len(buildNodes))
+	for i, n := range buildNodes {
+		index[n.ID()] = i
+	}
+
+	// successors[i] lists the build nodes which build node i (transitively) depends on
+	successors := make([][]int, len(buildNodes))
+	for i, n := range buildNodes {
+		for reachableID := range closure[n.ID()] {
+			if j, isBuildNode := index[reachableID]; isBuildNode {
+				successors[i] = append(successors[i], j)
+			}
+		}
+	}
+
+	// Find a maximum matching using augmenting paths (Kuhn's algorithm)
+	matchedTo := make([]int, len(buildNodes))
+	for i := range matchedTo {
+		matchedTo[i] = -1
+	}
+	var augment func(u int, visited []bool) bool
+	augment = func(u int, visited []bool) bool {
+		for _, v := range successors[u] {
+			if visited[v] {
+				continue
+			}
+			visited[v] = true
+			if matchedTo[v] == -1 || augment(matchedTo[v], visited) {
+				matchedTo[v] = u
+				return true
+			}
+		}
+		return false
+	}
+
+	matching := 0
+	for u := range buildNodes {
+		if augment(u, make([]bool, len(buildNodes))) {
+			matching++
+		}
+	}
+
+	parallelism = len(buildNodes) - matching
+
+	return
+}
+
+// TopologicalSort returns the build nodes of the graph in build order: every build node comes after all of the
+// build nodes it (transitively) depends on. Ties are broken by node ID so identical graphs always produce the same
+// order. Returns an error if the graph contains a cycle, in which case MakeDAG should be run first.
+func (g *PkgGraph) TopologicalSort() (buildOrder []*PkgNode, err error) {
+	unvisited := g.walkDependenciesFirst(func(n *PkgNode) {
+		if n.Type == TypeBuild {
+			buildOrder = append(buildOrder, n)
+		}
+	})
+
+	if unvisited > 0 {
+		buildOrder = nil
+		err = fmt.Errorf("unable to sort graph, it contains %d node(s) in or depending on a cycle, run MakeDAG first", unvisited)
+	}
+
+	return
+}
+
+// BuildLayers partitions the build nodes into waves which can each be built concurrently: every build node in wave N
+// only (transitively) depends on build nodes in waves before N, and each node is placed in the earliest wave it
+// can go in. Run, meta, and goal nodes are not part of any wave but the dependencies passing through them are
+// honored. Each wave is sorted by ID. Returns an error if the graph contains a cycle, in which case MakeDAG should
+// be run first.
+func (g *PkgGraph) BuildLayers() (layers [][]*PkgNode, err error) {
+	// buildDepth is the largest number of build nodes found on a single chain of dependencies below a node, which
+	// is also the wave a build node belongs to
+	buildDepth := make(map[int64]int, g.Nodes().Len())
+	unvisited := g.walkDependenciesFirst(func(n *PkgNode) {
+		depth := 0
+		for _, dependency := range graph.NodesOf(g.From(n.ID())) {
+			depthAbove := buildDepth[dependency.ID()]
+			if dependency.(*PkgNode).Type == TypeBuild {
+				depthAbove++
+			}
+			if depthAbove > depth {
+				depth = depthAbove
+			}
+		}
+		buildDepth[n.ID()] = depth
+
+		if n.Type == TypeBuild {
+			for len(layers) <= depth {
+				layers = append(layers, nil)
+			}
+			layers[depth] = append(layers[depth], n)
+		}
+	})
+
+	if unvisited > 0 {
+		layers = nil
+		err = fmt.Errorf("unable to compute build layers, graph contains %d node(s) in or depending on a cycle, run MakeDAG first", unvisited)
+		return
+	}
+
+	for _, layer := range layers {
+		sortNodesByID(layer)
+	}
+
+	return
+}
+
+// walkDependenciesFirst runs Kahn's algorithm against the dependency direction, calling visit on every node only
+// after it was called on all of the node's dependencies. Nodes which are ready at the same time are visited in ID
+// order, so identical graphs are always walked the same way. Returns the number of nodes which were never visited
+// because they are in, or depend on, a cycle.
+func (g *PkgGraph) walkDependenciesFirst(visit func(n *PkgNode)) (unvisited int) {
+	remainingDependencies := make(map[int64]int, g.Nodes().Len())
+	ready := &idHeap{}
+	for _, n := range graph.NodesOf(g.Nodes()) {
+		count := g.From(n.ID()).Len()
+		remainingDependencies[n.ID()] = count
+		if count == 0 {
+			heap.Push(ready, n.ID())
+		}
+	}
+
+	unvisited = len(remainingDependencies)
+	for ready.Len() > 0 {
+		id := heap.Pop(ready).(int64)
+		unvisited--
+
+		visit(g.Node(id).(*PkgNode).This)
+
+		for _, dependent := range graph.NodesOf(g.To(id)) {
+			remainingDependencies[dependent.ID()]--
+			if remainingDependencies[dependent.ID()] == 0 {
+				heap.Push(ready, dependent.ID())
+			}
+		}
+	}
+
+	return
+}
+
+// defaultEdgeWeight is the weight of edges which were never given one with SetEdgeWeight.
+const defaultEdgeWeight = 1.0
+
+// SetEdgeWeight records an estimated cost (ie build time) for the dependency of from on to, used by CriticalPath.
+// Edges without a recorded weight have a weight of 1. Returns an error if the edge doesn't exist or the weight is
+// negative or not a number.
+func (g *PkgGraph) SetEdgeWeight(from *PkgNode, to *PkgNode, weight float64) (err error) {
+	if g.Edge(from.ID(), to.ID()) == nil {
+		err = fmt.Errorf("no edge exists between '%s' and '%s'", from.FriendlyName(), to.FriendlyName())
+		return
+	}
+	if math.IsNaN(weight) || weight < 0 {
+		err = fmt.Errorf("invalid weight %v for edge between '%s' and '%s'", weight, from.FriendlyName(), to.FriendlyName())
+		return
+	}
+
+	if g.edgeWeights == nil {
+		g.edgeWeights = make(map[edgeID]float64)
+	}
+	g.edgeWeights[edgeID{from.ID(), to.ID()}] = weight
+
+	return
+}
+
+// EdgeWeight returns the weight recorded for the dependency of from on to, or 1 if none was recorded.
+func (g *PkgGraph) EdgeWeight(from *PkgNode, to *PkgNode) float64 {
+	if weight, found := g.edgeWeights[edgeID{from.ID(), to.ID()}]; found {
+		return weight
+	}
+	return defaultEdgeWeight
+}
+
+// CriticalPath returns the heaviest chain of dependencies in the graph, along with its total weight (the sum of the
+// weights of its edges, see SetEdgeWeight). Each node in the path depends on the node after it, so the last node
+// has to be built first. Ties are broken towards lower node IDs so identical graphs always produce the same path.
+// A graph without edges has a path made of its lowest ID node, with a weight of 0. Returns an error if the graph
+// contains a cycle, in which case MakeDAG should be run first.
+func (g *PkgGraph) CriticalPath() (path []*PkgNode, weight float64, err error) {
+	// heaviest is the weight of the heaviest chain starting at a node, next is the node that chain continues with
+	heaviest := make(map[int64]float64, g.Nodes().Len())
+	next := make(map[int64]int64, g.Nodes().Len())
+	var (
+		start   int64
+		visited bool
+	)
+	unvisited := g.walkDependenciesFirst(func(n *PkgNode) {
+		id := n.ID()
+		for _, dependency := range graph.NodesOf(g.From(id)) {
+			candidate := g.EdgeWeight(n, dependency.(*PkgNode).This) + heaviest[dependency.ID()]
+			currentNext, hasNext := next[id]
+			if !hasNext || candidate > heaviest[id] || (candidate == heaviest[id] && dependency.ID() < currentNext) {
+				heaviest[id] = candidate
+				next[id] = dependency.ID()
+			}
+		}
+
+		if !visited || heaviest[id] > heaviest[start] || (heaviest[id] == heaviest[start] && id < start) {
+			start = id
+			visited = true
+		}
+	})
+
+	if unvisited > 0 {
+		err = fmt.Errorf("unable to compute critical path, graph contains %d node(s) in or depending on a cycle, run MakeDAG first", unvisited)
+		return
+	}
+
+	if !visited {
+		return
+	}
+
+	weight = heaviest[start]
+	for id := start; ; {
+		path = append(path, g.Node(id).(*PkgNode).This)
+		nextID, hasNext := next[id]
+		if !hasNext {
+			break
+		}
+		id = nextID
+	}
+
+	return
+}
+
+// idHeap is a min-heap of node IDs, implementing heap.Interface.
+type idHeap []int64
+
+func (h idHeap) Len() int           { return len(h) }
+func (h idHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h idHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *idHeap) Push(x interface{}) {
+	*h = append(*h, x.(int64))
+}
+
+func (h *idHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
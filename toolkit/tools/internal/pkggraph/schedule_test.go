@@ -0,0 +1,171 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Make sure the parallelism bound counts independent build nodes only
+func TestMaxParallelism(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// A -> B -> C form a single chain, C2 is independent of it
+	parallelism, err := g.MaxParallelism()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, parallelism)
+
+	// Two more packages which only depend on C can build alongside each other and C2
+	for _, name := range []string{"E", "F"} {
+		pkg := &pkgjson.PackageVer{Name: name, Version: "1"}
+		run, err := g.AddPkgNode(pkg, StateMeta, TypeRun, name+".src.rpm", name+".rpm", name+".spec", "", "test_arch", "")
+		assert.NoError(t, err)
+		build, err := g.AddPkgNode(pkg, StateBuild, TypeBuild, name+".src.rpm", name+".rpm", name+".spec", "", "test_arch", "")
+		assert.NoError(t, err)
+		assert.NoError(t, g.AddEdge(run, build))
+		assert.NoError(t, g.AddEdge(build, findTestNode(t, g, pkgCRun)))
+	}
+	parallelism, err = g.MaxParallelism()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, parallelism)
+
+	parallelism, err = NewPkgGraph().MaxParallelism()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, parallelism)
+
+	assert.NoError(t, addEdgeHelper(g, *pkgCBuild, *pkgARun))
+	_, err = g.MaxParallelism()
+	assert.Error(t, err)
+}
+
+// Make sure build nodes come after their dependencies, in a stable order
+func TestTopologicalSort(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	order, err := g.TopologicalSort()
+	assert.NoError(t, err)
+	assert.Equal(t, len(buildNodes), len(order))
+
+	position := make(map[int64]int)
+	for i, n := range order {
+		assert.Equal(t, TypeBuild, n.Type)
+		position[n.ID()] = i
+	}
+	cBuild := findTestNode(t, g, pkgCBuild)
+	bBuild := findTestNode(t, g, pkgBBuild)
+	aBuild := findTestNode(t, g, pkgABuild)
+	assert.Less(t, position[cBuild.ID()], position[bBuild.ID()])
+	assert.Less(t, position[bBuild.ID()], position[aBuild.ID()])
+
+	again, err := g.TopologicalSort()
+	assert.NoError(t, err)
+	assert.Equal(t, order, again)
+
+	assert.NoError(t, addEdgeHelper(g, *pkgCBuild, *pkgARun))
+	_, err = g.TopologicalSort()
+	assert.Error(t, err)
+}
+
+// Make sure build nodes are placed in the earliest wave after all of their dependencies
+func TestBuildLayers(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	_, err = g.AddGoalNode("test", nil, false)
+	assert.NoError(t, err)
+
+	cBuilds := []*PkgNode{findTestNode(t, g, pkgCBuild), findTestNode(t, g, pkgC2Build)}
+	sortNodesByID(cBuilds)
+	expected := [][]*PkgNode{
+		cBuilds,
+		{findTestNode(t, g, pkgBBuild)},
+		{findTestNode(t, g, pkgABuild)},
+	}
+
+	layers, err := g.BuildLayers()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, layers)
+
+	assert.NoError(t, addEdgeHelper(g, *pkgCBuild, *pkgARun))
+	_, err = g.BuildLayers()
+	assert.Error(t, err)
+}
+
+// Make sure the critical path follows the heaviest chain of dependencies
+func TestCriticalPath(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	aRun := findTestNode(t, g, pkgARun)
+	cRun := findTestNode(t, g, pkgCRun)
+	cBuild := findTestNode(t, g, pkgCBuild)
+	d1 := findTestNode(t, g, pkgD1Unresolved)
+	d3 := findTestNode(t, g, pkgD3Unresolved)
+
+	// Unweighted edges count as 1, C run depends on both C build and D3 so the tie goes to the lower ID
+	last := cBuild
+	if d3.ID() < last.ID() {
+		last = d3
+	}
+	path, weight, err := g.CriticalPath()
+	assert.NoError(t, err)
+	assert.Equal(t, 5.0, weight)
+	assert.Equal(t, []*PkgNode{
+		aRun, findTestNode(t, g, pkgABuild), findTestNode(t, g, pkgBRun), findTestNode(t, g, pkgBBuild), cRun, last,
+	}, path)
+
+	assert.NoError(t, g.SetEdgeWeight(aRun, d1, 10))
+	assert.Equal(t, 10.0, g.EdgeWeight(aRun, d1))
+	assert.Equal(t, 1.0, g.EdgeWeight(cRun, d3))
+	path, weight, err = g.CriticalPath()
+	assert.NoError(t, err)
+	assert.Equal(t, 10.0, weight)
+	assert.Equal(t, []*PkgNode{aRun, d1}, path)
+
+	// Weights are kept by copies
+	graphCopy, err := g.DeepCopyPreservingIDs()
+	assert.NoError(t, err)
+	_, weight, err = graphCopy.CriticalPath()
+	assert.NoError(t, err)
+	assert.Equal(t, 10.0, weight)
+
+	assert.Error(t, g.SetEdgeWeight(d1, aRun, 1))
+	assert.Error(t, g.SetEdgeWeight(aRun, d1, -1))
+
+	assert.NoError(t, addEdgeHelper(g, *pkgCBuild, *pkgARun))
+	_, _, err = g.CriticalPath()
+	assert.Error(t, err)
+
+	path, weight, err = NewPkgGraph().CriticalPath()
+	assert.NoError(t, err)
+	assert.Empty(t, path)
+	assert.Equal(t, 0.0, weight)
+}
+
+// Make sure weights are dropped with their edge, so a node reusing a removed node's ID doesn't inherit them
+func TestEdgeWeightRemoved(t *testing.T) {
+	g := NewPkgGraph()
+	eRun, err := addNodeToGraphHelper(g, buildUnresolvedNodeHelper(&pkgjson.PackageVer{Name: "E", Version: "1"}))
+	assert.NoError(t, err)
+	fRun, err := addNodeToGraphHelper(g, buildUnresolvedNodeHelper(&pkgjson.PackageVer{Name: "F", Version: "1"}))
+	assert.NoError(t, err)
+	assert.NoError(t, g.AddEdge(eRun, fRun))
+	assert.NoError(t, g.SetEdgeWeight(eRun, fRun, 42))
+
+	g.RemoveEdge(eRun.ID(), fRun.ID())
+	assert.NoError(t, g.AddEdge(eRun, fRun))
+	assert.Equal(t, 1.0, g.EdgeWeight(eRun, fRun))
+
+	assert.NoError(t, g.SetEdgeWeight(eRun, fRun, 42))
+	g.RemovePkgNode(fRun)
+	gRun, err := addNodeToGraphHelper(g, buildUnresolvedNodeHelper(&pkgjson.PackageVer{Name: "G", Version: "1"}))
+	assert.NoError(t, err)
+	assert.NoError(t, g.AddEdge(eRun, gRun))
+	assert.Equal(t, 1.0, g.EdgeWeight(eRun, gRun))
+}
@@ -0,0 +1,86 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+// SnapshotStates captures the current State of every node in the graph, keyed by node ID.
+func (g *PkgGraph) SnapshotStates() (snapshot map[int64]NodeState) {
+	snapshot = make(map[int64]NodeState, g.Nodes().Len())
+	for _, node := range g.AllNodes() {
+		snapshot[node.ID()] = node.State
+	}
+	return
+}
+
+// RestoreStates reapplies node states captured by SnapshotStates. IDs which are no longer present in the
+// graph are ignored, and nodes missing from the snapshot are left unchanged.
+func (g *PkgGraph) RestoreStates(snapshot map[int64]NodeState) {
+	for id, state := range snapshot {
+		node := g.Node(id)
+		if node == nil {
+			continue
+		}
+		node.(*PkgNode).This.State = state
+	}
+}
+
+// ApplyBuildResults sets the state of every node in results. For each node which failed (StateBuildError), its
+// blocked dependents are computed as in BuildReport, once all results are applied. Returns the dependents which
+// are blocked by this batch of failures but were not already blocked by an earlier failure, sorted by ID.
+func (g *PkgGraph) ApplyBuildResults(results map[*PkgNode]NodeState) (blocked []*PkgNode) {
+	alreadyBlocked := make(map[int64]bool)
+	for _, n := range g.AllNodes() {
+		if n.State == StateBuildError {
+			for _, dependent := range g.blockedBy(n) {
+				alreadyBlocked[dependent.ID()] = true
+			}
+		}
+	}
+
+	var failedNodes []*PkgNode
+	for n, state := range results {
+		n.This.State = state
+		if state == StateBuildError {
+			failedNodes = append(failedNodes, n.This)
+		}
+	}
+
+	newlyBlocked := make(map[int64]*PkgNode)
+	for _, failedNode := range failedNodes {
+		for _, dependent := range g.blockedBy(failedNode) {
+			if !alreadyBlocked[dependent.ID()] {
+				newlyBlocked[dependent.ID()] = dependent
+			}
+		}
+	}
+
+	for _, dependent := range newlyBlocked {
+		blocked = append(blocked, dependent)
+	}
+	sortNodesByID(blocked)
+
+	return
+}
+
+// MarkDirty flags a node for rebuild after its sources changed: the node is set to StateBuild, and every build or
+// run node which transitively depends on it and is currently StateUpToDate is set back to StateBuild as well.
+// Returns the nodes whose state was changed, sorted by ID, so the caller can report what will be rebuilt.
+func (g *PkgGraph) MarkDirty(node *PkgNode) (changed []*PkgNode) {
+	for _, n := range g.AllNodesTo(node) {
+		if n == node.This {
+			if n.State != StateBuild {
+				n.State = StateBuild
+				changed = append(changed, n)
+			}
+			continue
+		}
+
+		if (n.Type == TypeBuild || n.Type == TypeRun) && n.State == StateUpToDate {
+			n.State = StateBuild
+			changed = append(changed, n)
+		}
+	}
+	sortNodesByID(changed)
+
+	return
+}
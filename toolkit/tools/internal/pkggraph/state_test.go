@@ -0,0 +1,91 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Make sure node states can be reverted to a snapshot
+func TestSnapshotRestoreStates(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	snapshot := g.SnapshotStates()
+	assert.Equal(t, len(allNodes), len(snapshot))
+
+	for _, n := range g.AllBuildNodes() {
+		n.State = StateBuildError
+	}
+
+	// Unknown IDs must be ignored
+	snapshot[9999] = StateUpToDate
+	g.RestoreStates(snapshot)
+
+	for _, n := range g.AllBuildNodes() {
+		assert.Equal(t, StateBuild, n.State)
+	}
+	assert.Nil(t, g.Node(9999))
+}
+
+// Make sure only dependents newly blocked by a batch of failures are returned
+func TestApplyBuildResults(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	cBuild := findTestNode(t, g, pkgCBuild)
+	c2Build := findTestNode(t, g, pkgC2Build)
+	bBuild := findTestNode(t, g, pkgBBuild)
+
+	blocked := g.ApplyBuildResults(map[*PkgNode]NodeState{cBuild: StateBuildError, c2Build: StateUpToDate})
+	assert.Equal(t, StateBuildError, cBuild.State)
+	assert.Equal(t, StateUpToDate, c2Build.State)
+
+	expected := []*PkgNode{
+		findTestNode(t, g, pkgCRun), bBuild, findTestNode(t, g, pkgBRun), findTestNode(t, g, pkgABuild), findTestNode(t, g, pkgARun),
+	}
+	sortNodesByID(expected)
+	assert.Equal(t, expected, blocked)
+
+	// Everything depending on B was already blocked by C
+	blocked = g.ApplyBuildResults(map[*PkgNode]NodeState{bBuild: StateBuildError})
+	assert.Empty(t, blocked)
+	assert.Equal(t, StateBuildError, bBuild.State)
+}
+
+// Make sure marking a node dirty only flips up to date build and run nodes which depend on it
+func TestMarkDirty(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	for _, n := range g.AllNodes() {
+		if n.Type == TypeBuild || n.Type == TypeRun {
+			n.State = StateUpToDate
+		}
+	}
+	aRun := findTestNode(t, g, pkgARun)
+	aBuild := findTestNode(t, g, pkgABuild)
+	bRun := findTestNode(t, g, pkgBRun)
+	bBuild := findTestNode(t, g, pkgBBuild)
+	cRun := findTestNode(t, g, pkgCRun)
+	cBuild := findTestNode(t, g, pkgCBuild)
+	aBuild.State = StateBuildError
+
+	changed := g.MarkDirty(cBuild)
+	expected := []*PkgNode{cBuild, cRun, bBuild, bRun, aRun}
+	sortNodesByID(expected)
+	assert.Equal(t, expected, changed)
+
+	for _, n := range expected {
+		assert.Equal(t, StateBuild, n.State)
+	}
+	// Nodes which aren't up to date, or don't depend on C, are left alone
+	assert.Equal(t, StateBuildError, aBuild.State)
+	assert.Equal(t, StateUpToDate, findTestNode(t, g, pkgC2Build).State)
+
+	// Nothing is left to change
+	assert.Empty(t, g.MarkDirty(cBuild))
+}
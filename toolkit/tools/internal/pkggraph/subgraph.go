@@ -0,0 +1,293 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"fmt"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/logger"
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/topo"
+)
+
+// FilterByNamePredicate returns a new graph containing only the nodes whose package name passes the keep predicate.
+// Nodes without package information (goal and meta nodes) are always kept. Edges are rewired around removed nodes,
+// so if a kept node could reach another kept node through removed nodes it will depend on it directly. Edges between
+// kept nodes keep their recorded constraints and weights.
+func (g *PkgGraph) FilterByNamePredicate(keep func(name string) bool) (filteredGraph *PkgGraph, err error) {
+	isKept := func(n *PkgNode) bool {
+		return n.VersionedPkg == nil || keep(n.VersionedPkg.Name)
+	}
+
+	// graph manipulation calls may panic on error (such as duplicate node IDs)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("filtering graph failed: %s", r)
+		}
+	}()
+
+	keptNodes := []*PkgNode{}
+	for _, n := range g.AllNodes() {
+		if isKept(n) {
+			keptNodes = append(keptNodes, n)
+		}
+	}
+	filteredGraph = g.copySubGraphPreservingIDs(keptNodes)
+
+	for _, n := range keptNodes {
+		// Walk through any removed nodes until we reach kept ones, those become the new direct dependencies.
+		visited := map[int64]bool{n.ID(): true}
+		pending := graph.NodesOf(g.From(n.ID()))
+		for len(pending) > 0 {
+			next := pending[len(pending)-1].(*PkgNode).This
+			pending = pending[:len(pending)-1]
+
+			if visited[next.ID()] {
+				continue
+			}
+			visited[next.ID()] = true
+
+			if isKept(next) {
+				if !filteredGraph.HasEdgeFromTo(n.ID(), next.ID()) {
+					filteredGraph.SetEdge(filteredGraph.NewEdge(filteredGraph.Node(n.ID()), filteredGraph.Node(next.ID())))
+				}
+			} else {
+				pending = append(pending, graph.NodesOf(g.From(next.ID()))...)
+			}
+		}
+	}
+
+	logger.Log.Debugf("Filtered graph from %d to %d nodes", g.Nodes().Len(), filteredGraph.Nodes().Len())
+
+	return
+}
+
+// SubGraphByArchitecture returns a new graph containing only the nodes built for arch, along with the goal and meta
+// nodes, and the edges between them. Meta nodes left without any edges are dropped. Node IDs and recorded edge
+// constraints and weights are preserved.
+func (g *PkgGraph) SubGraphByArchitecture(arch string) (subGraph *PkgGraph, err error) {
+	isKept := func(n *PkgNode) bool {
+		return n.Type == TypeGoal || n.Type == TypePureMeta || n.Architecture == arch
+	}
+
+	// graph manipulation calls may panic on error (such as duplicate node IDs)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("filtering graph by architecture failed: %s", r)
+		}
+	}()
+
+	keptNodes := []*PkgNode{}
+	for _, n := range g.AllNodes() {
+		if isKept(n) {
+			keptNodes = append(keptNodes, n)
+		}
+	}
+	subGraph = g.copySubGraphPreservingIDs(keptNodes)
+
+	for _, n := range subGraph.AllNodes() {
+		if n.Type == TypePureMeta && subGraph.From(n.ID()).Len() == 0 && subGraph.To(n.ID()).Len() == 0 {
+			subGraph.RemoveNode(n.ID())
+		}
+	}
+
+	logger.Log.Debugf("Filtered graph from %d to %d nodes for architecture '%s'", g.Nodes().Len(), subGraph.Nodes().Len(), arch)
+
+	return
+}
+
+// FailureReport describes the graph needed to reproduce a failed build, see FailureSubGraph.
+type FailureReport struct {
+	Graph     *PkgGraph  // The failed build node, its run node, and every node the build transitively depends on
+	Available []*PkgNode // Dependencies which are already available (StateUpToDate or StateCached), sorted by ID
+	Needed    []*PkgNode // Dependencies which would still have to be built or found, sorted by ID
+}
+
+// FailureSubGraph returns a standalone graph for reproducing a failed build: the failed build node and every node it
+// transitively depends on. The failed node's run node is included as well so the build node survives lookup
+// initialization, but none of its runtime dependencies are. The copied nodes keep their states, and the report splits
+// the dependencies between those already available (StateUpToDate or StateCached) and those which would still have
+// to be built or found. Both groups are also logged.
+// Returns an error if the node isn't a build node in StateBuildError.
+func (g *PkgGraph) FailureSubGraph(failed *PkgNode) (report *FailureReport, err error) {
+	if failed == nil || g.Node(failed.ID()) == nil {
+		err = fmt.Errorf("failed node '%v' is not part of the graph", failed)
+		return
+	}
+	if failed.Type != TypeBuild || failed.State != StateBuildError {
+		err = fmt.Errorf("node '%s' is not a failed build node", failed.FriendlyName())
+		return
+	}
+
+	lookupEntry, err := g.FindExactPkgNodeFromPkg(failed.VersionedPkg)
+	if err != nil {
+		return
+	}
+	if lookupEntry == nil || lookupEntry.RunNode == nil {
+		err = fmt.Errorf("failed node '%s' has no run node", failed.FriendlyName())
+		return
+	}
+
+	// graph manipulation calls may panic on error (such as duplicate node IDs)
+	defer func() {
+		if r := recover(); r != nil {
+			report = nil
+			err = fmt.Errorf("creating failure sub graph failed: %s", r)
+		}
+	}()
+
+	reachable := g.ReachableFrom([]*PkgNode{failed})
+	nodes := []*PkgNode{lookupEntry.RunNode}
+	for id := range reachable {
+		nodes = append(nodes, g.Node(id).(*PkgNode).This)
+	}
+	sortNodesByID(nodes)
+
+	report = &FailureReport{Graph: g.copySubGraphPreservingIDs(nodes)}
+	report.Graph.lookupTable()
+
+	for _, n := range report.Graph.AllNodes() {
+		if !reachable[n.ID()] || n.ID() == failed.ID() {
+			continue
+		}
+		switch n.State {
+		case StateUpToDate, StateCached:
+			report.Available = append(report.Available, n)
+		default:
+			report.Needed = append(report.Needed, n)
+		}
+	}
+	sortNodesByID(report.Available)
+	sortNodesByID(report.Needed)
+
+	logger.Log.Infof("Failure sub graph for '%s' has %d dependencies already available and %d still needed", failed.FriendlyName(), len(report.Available), len(report.Needed))
+	for _, n := range report.Available {
+		logger.Log.Debugf("\tAvailable: %s", n.FriendlyName())
+	}
+	for _, n := range report.Needed {
+		logger.Log.Infof("\tNeeded: %s (%s)", n.FriendlyName(), n.State.String())
+	}
+
+	return
+}
+
+// copyNode returns a copy of a node which keeps the original node's ID, for use in a different graph.
+// The copy has no edges attached to it.
+func copyNode(pkgNode *PkgNode) (newNode *PkgNode) {
+	copiedNode := *pkgNode
+	newNode = &copiedNode
+	newNode.This = newNode
+	return
+}
+
+// copyGraphPreservingIDs returns an in-memory copy of the graph where every node keeps its original ID.
+// The nodes are copies, so changes to the new graph do not affect the original.
+func (g *PkgGraph) copyGraphPreservingIDs() (graphCopy *PkgGraph, err error) {
+	// graph manipulation calls may panic on error (such as duplicate node IDs)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("copying graph failed: %s", r)
+		}
+	}()
+
+	graphCopy = g.copySubGraphPreservingIDs(g.AllNodes())
+
+	return
+}
+
+// copySubGraphPreservingIDs returns a new graph holding copies of nodes, which keep their original IDs, along with
+// every edge between them and the constraint and weight recorded for it. The new graph uses the same version
+// comparator. Graph manipulation calls may panic, so callers are expected to recover.
+func (g *PkgGraph) copySubGraphPreservingIDs(nodes []*PkgNode) (subGraph *PkgGraph) {
+	subGraph = NewPkgGraph()
+	subGraph.versionComparator = g.versionComparator
+	for _, n := range nodes {
+		subGraph.AddNode(copyNode(n))
+	}
+
+	for _, n := range nodes {
+		from := subGraph.Node(n.ID())
+		for _, dependency := range graph.NodesOf(g.From(n.ID())) {
+			to := subGraph.Node(dependency.ID())
+			if to == nil {
+				continue
+			}
+			subGraph.SetEdge(subGraph.NewEdge(from, to))
+
+			id := edgeID{from: from.ID(), to: to.ID()}
+			if constraint, found := g.edgeConstraints[id]; found {
+				if subGraph.edgeConstraints == nil {
+					subGraph.edgeConstraints = make(map[edgeID]*pkgjson.PackageVer)
+				}
+				subGraph.edgeConstraints[id] = constraint
+			}
+			if weight, found := g.edgeWeights[id]; found {
+				if subGraph.edgeWeights == nil {
+					subGraph.edgeWeights = make(map[edgeID]float64)
+				}
+				subGraph.edgeWeights[id] = weight
+			}
+		}
+	}
+
+	return
+}
+
+// CycleReproducer returns a standalone graph containing only what is needed to reproduce a cycle: the cycle's
+// nodes, the edges between them, and the run node of every build node in the cycle (so the build node survives
+// lookup initialization). The cycle may be given with or without its first node repeated as the last, as returned
+// by FindAnyDirectedCycle. Node IDs and recorded edge constraints and weights are preserved.
+// Returns an error if the nodes aren't part of the graph or don't form a cycle.
+func (g *PkgGraph) CycleReproducer(cycle []*PkgNode) (reproducer *PkgGraph, err error) {
+	if len(cycle) > 1 && cycle[0] == cycle[len(cycle)-1] {
+		cycle = cycle[:len(cycle)-1]
+	}
+	if len(cycle) < 2 {
+		err = fmt.Errorf("a cycle needs at least two nodes, got %d", len(cycle))
+		return
+	}
+
+	included := make(map[int64]*PkgNode)
+	for _, n := range cycle {
+		if g.Node(n.ID()) == nil {
+			err = fmt.Errorf("cycle node '%s' is not part of the graph", n.FriendlyName())
+			return
+		}
+		included[n.ID()] = n
+
+		if n.Type == TypeBuild {
+			var lookupEntry *LookupNode
+			lookupEntry, err = g.FindExactPkgNodeFromPkg(n.VersionedPkg)
+			if err != nil {
+				return
+			}
+			if lookupEntry != nil && lookupEntry.RunNode != nil {
+				included[lookupEntry.RunNode.ID()] = lookupEntry.RunNode
+			}
+		}
+	}
+
+	// graph manipulation calls may panic on error (such as duplicate node IDs)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("building cycle reproducer failed: %s", r)
+		}
+	}()
+
+	includedNodes := make([]*PkgNode, 0, len(included))
+	for _, n := range included {
+		includedNodes = append(includedNodes, n)
+	}
+	sortNodesByID(includedNodes)
+	reproducer = g.copySubGraphPreservingIDs(includedNodes)
+
+	if _, sortErr := topo.Sort(reproducer); sortErr == nil {
+		reproducer = nil
+		err = fmt.Errorf("nodes do not form a cycle")
+	}
+
+	return
+}
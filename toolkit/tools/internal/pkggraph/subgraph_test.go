@@ -0,0 +1,217 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/graph/topo"
+)
+
+// Make sure filtered nodes are removed while reachability between the remaining nodes is preserved
+func TestFilterByNamePredicate(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	aRun := findTestNode(t, g, pkgARun)
+	d1 := findTestNode(t, g, pkgD1Unresolved)
+	constraint := &pkgjson.PackageVer{Name: "D", Condition: ">=", Version: "1"}
+	assert.NoError(t, g.SetEdgeConstraint(aRun, d1, constraint))
+	assert.NoError(t, g.SetEdgeWeight(aRun, d1, 4))
+	g.SetVersionComparator(func(a, b *pkgjson.PackageVer) int { return 0 })
+
+	filtered, err := g.FilterByNamePredicate(func(name string) bool {
+		return name != "B"
+	})
+	assert.NoError(t, err)
+
+	// B run and B build are removed
+	assert.Equal(t, len(allNodes)-2, len(filtered.AllNodes()))
+	for _, n := range filtered.AllNodes() {
+		assert.NotEqual(t, "B", n.VersionedPkg.Name)
+	}
+
+	// A build used to reach C run through B, and D2 is now only reachable through A's build node
+	aBuild := filtered.Node(findTestNode(t, g, pkgABuild).ID())
+	cRun := filtered.Node(findTestNode(t, g, pkgCRun).ID())
+	d2 := filtered.Node(findTestNode(t, g, pkgD2Unresolved).ID())
+	assert.True(t, filtered.HasEdgeFromTo(aBuild.ID(), cRun.ID()))
+	assert.True(t, filtered.HasEdgeFromTo(aBuild.ID(), d2.ID()))
+	assert.True(t, topo.PathExistsIn(filtered, filtered.Node(findTestNode(t, g, pkgARun).ID()), d2))
+
+	// Edges between kept nodes keep their data, rewired edges have none
+	assert.Equal(t, constraint, filtered.EdgeConstraint(aRun, d1))
+	assert.Equal(t, 4.0, filtered.EdgeWeight(aRun, d1))
+	assert.Nil(t, filtered.EdgeConstraint(aBuild.(*PkgNode), cRun.(*PkgNode)))
+	assert.NotNil(t, filtered.versionComparator)
+
+	// The original graph must not change
+	checkTestGraph(t, g)
+	assert.NotSame(t, findTestNode(t, g, pkgARun), filtered.Node(findTestNode(t, g, pkgARun).ID()).(*PkgNode))
+}
+
+func TestSubGraphByArchitecture(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	otherArchNodes := []*PkgNode{pkgCRun, pkgCBuild, pkgC2Run, pkgC2Build, pkgD3Unresolved, pkgD4Unresolved, pkgD5Unresolved, pkgD6Unresolved}
+	for _, reference := range otherArchNodes {
+		findTestNode(t, g, reference).Architecture = "other_arch"
+	}
+	keptMeta := g.AddMetaNode([]*PkgNode{findTestNode(t, g, pkgARun)}, []*PkgNode{findTestNode(t, g, pkgBRun)})
+	droppedMeta := g.AddMetaNode([]*PkgNode{findTestNode(t, g, pkgC2Run)}, []*PkgNode{findTestNode(t, g, pkgD4Unresolved)})
+	goalNode, err := g.AddGoalNode("test", nil, false)
+	assert.NoError(t, err)
+	aRun := findTestNode(t, g, pkgARun)
+	aBuild := findTestNode(t, g, pkgABuild)
+	d1 := findTestNode(t, g, pkgD1Unresolved)
+	constraint := &pkgjson.PackageVer{Name: "D", Condition: ">=", Version: "1"}
+	assert.NoError(t, g.SetEdgeConstraint(aRun, d1, constraint))
+	assert.NoError(t, g.SetEdgeWeight(aRun, aBuild, 3))
+	g.SetVersionComparator(func(a, b *pkgjson.PackageVer) int { return 0 })
+
+	subGraph, err := g.SubGraphByArchitecture("test_arch")
+	assert.NoError(t, err)
+
+	// A, B, D1, D2, the goal, and the meta node between A and B are left
+	assert.Equal(t, len(allNodes)-len(otherArchNodes)+2, len(subGraph.AllNodes()))
+	assert.NotNil(t, subGraph.Node(keptMeta.ID()))
+	assert.Nil(t, subGraph.Node(droppedMeta.ID()))
+	assert.NotNil(t, subGraph.Node(goalNode.ID()))
+	// The goal only keeps its edges to A, B, D1, and D2
+	assert.Equal(t, 4, subGraph.From(goalNode.ID()).Len())
+	for _, n := range subGraph.AllNodes() {
+		assert.NotEqual(t, "other_arch", n.Architecture)
+	}
+
+	// B build no longer depends on C run, and edges are not rewired around it
+	assert.Equal(t, 0, subGraph.From(findTestNode(t, g, pkgBBuild).ID()).Len())
+	assert.True(t, subGraph.HasEdgeFromTo(findTestNode(t, g, pkgBRun).ID(), findTestNode(t, g, pkgD2Unresolved).ID()))
+
+	// Recorded edge data and the version comparator are carried over
+	assert.Equal(t, constraint, subGraph.EdgeConstraint(aRun, d1))
+	assert.Equal(t, 3.0, subGraph.EdgeWeight(aRun, aBuild))
+	assert.NotNil(t, subGraph.versionComparator)
+}
+
+// findTestNode returns the node in the graph matching one of the reference test nodes.
+func findTestNode(t *testing.T, g *PkgGraph, reference *PkgNode) *PkgNode {
+	lookup, err := g.FindExactPkgNodeFromPkg(reference.VersionedPkg)
+	assert.NoError(t, err)
+	assert.NotNil(t, lookup)
+
+	if reference.Type == TypeBuild {
+		return lookup.BuildNode
+	}
+	return lookup.RunNode
+}
+
+// Make sure the reproducer holds just the cycle and still contains it
+func TestCycleReproducer(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Create a build cycle: A -> B -> C -> A
+	assert.NoError(t, addEdgeHelper(g, *pkgCBuild, *pkgARun))
+	cBuild := findTestNode(t, g, pkgCBuild)
+	aRun := findTestNode(t, g, pkgARun)
+	assert.NoError(t, g.SetEdgeWeight(cBuild, aRun, 2))
+	cycle, err := g.FindAnyDirectedCycle()
+	assert.NoError(t, err)
+
+	reproducer, err := g.CycleReproducer(cycle)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, reproducer.Nodes().Len())
+	assert.Equal(t, 6, reproducer.Edges().Len())
+	assert.Equal(t, 2.0, reproducer.EdgeWeight(cBuild, aRun))
+	for _, n := range cycle {
+		assert.True(t, n.Equal(reproducer.Node(n.ID()).(*PkgNode)))
+	}
+
+	// The reproducer must survive serialization with the cycle intact
+	var buf bytes.Buffer
+	assert.NoError(t, WriteDOTGraph(reproducer, &buf))
+	decoded := NewPkgGraph()
+	assert.NoError(t, ReadDOTGraph(decoded, &buf))
+	decodedCycle, err := decoded.FindAnyDirectedCycle()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, decodedCycle)
+
+	_, err = g.CycleReproducer([]*PkgNode{findTestNode(t, g, pkgC2Run), findTestNode(t, g, pkgC2Build)})
+	assert.Error(t, err)
+}
+
+func TestCreateSubGraphFromNodes(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	bRun := findTestNode(t, g, pkgBRun)
+	cRun := findTestNode(t, g, pkgCRun)
+	c2Run := findTestNode(t, g, pkgC2Run)
+
+	// C run is reachable from B run, it is only visited once
+	subGraph, err := g.CreateSubGraphFromNodes([]*PkgNode{bRun, c2Run, cRun})
+	assert.NoError(t, err)
+
+	// B run, B build, D2, C run, C build, D3, and C2 run, C2 build, D4, D5, D6
+	assert.Equal(t, 11, len(subGraph.AllNodes()))
+	assert.Equal(t, 9, subGraph.Edges().Len())
+	assert.NotNil(t, subGraph.nodeLookup)
+
+	for _, n := range subGraph.AllNodes() {
+		assert.True(t, n == n.This)
+		assert.False(t, n == g.Node(n.ID()).(*PkgNode).This)
+	}
+
+	lookup, err := subGraph.FindBestPkgNode(&pkgjson.PackageVer{Name: "C"})
+	assert.NoError(t, err)
+	assert.Equal(t, c2Run.ID(), lookup.RunNode.ID())
+	lookup, err = subGraph.FindExactPkgNodeFromPkg(&pkgA)
+	assert.NoError(t, err)
+	assert.Nil(t, lookup)
+
+	_, err = g.CreateSubGraphFromNodes([]*PkgNode{bRun, nil})
+	assert.Error(t, err)
+}
+
+// Make sure the failure sub graph holds the failed node and its dependencies, with their states
+func TestFailureSubGraph(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	aBuild := findTestNode(t, g, pkgABuild)
+	bRun := findTestNode(t, g, pkgBRun)
+
+	_, err = g.FailureSubGraph(aBuild)
+	assert.Error(t, err)
+	_, err = g.FailureSubGraph(nil)
+	assert.Error(t, err)
+
+	aBuild.State = StateBuildError
+	bRun.State = StateUpToDate
+	report, err := g.FailureSubGraph(aBuild)
+	assert.NoError(t, err)
+	failureGraph := report.Graph
+
+	// A, B, C, D2, and D3, but not D1 which is only needed at runtime
+	assert.Equal(t, 8, len(failureGraph.AllNodes()))
+	assert.Nil(t, failureGraph.Node(findTestNode(t, g, pkgC2Run).ID()))
+	assert.Nil(t, failureGraph.Node(findTestNode(t, g, pkgD1Unresolved).ID()))
+	assert.NotNil(t, failureGraph.Node(findTestNode(t, g, pkgARun).ID()))
+	lookup, err := failureGraph.FindExactPkgNodeFromPkg(&pkgA)
+	assert.NoError(t, err)
+	assert.Equal(t, aBuild.ID(), lookup.BuildNode.ID())
+	assert.Equal(t, StateBuildError, failureGraph.Node(aBuild.ID()).(*PkgNode).State)
+	assert.Equal(t, StateUpToDate, failureGraph.Node(bRun.ID()).(*PkgNode).State)
+	assert.Equal(t, StateUnresolved, failureGraph.Node(findTestNode(t, g, pkgD3Unresolved).ID()).(*PkgNode).State)
+	assert.NotSame(t, bRun, failureGraph.Node(bRun.ID()).(*PkgNode))
+
+	// Only B run is available, the failed node and its run node are not counted
+	assert.Equal(t, 1, len(report.Available))
+	assert.Equal(t, bRun.ID(), report.Available[0].ID())
+	assert.Equal(t, 5, len(report.Needed))
+}
@@ -0,0 +1,428 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/logger"
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/simple"
+)
+
+// noArch is the architecture of packages which can be installed on any architecture.
+const noArch = "noarch"
+
+// EdgeViolation describes a dependency edge whose target node no longer satisfies the versioned requirement
+// recorded for the edge.
+type EdgeViolation struct {
+	From       *PkgNode            // The node holding the requirement
+	To         *PkgNode            // The node the edge currently points at
+	Constraint *pkgjson.PackageVer // The requirement the edge was created for
+}
+
+// VerifyEdgeConstraints checks every edge with a recorded version constraint (see SetEdgeConstraint) and returns
+// the edges whose target node does not satisfy it. Violations are sorted by the IDs of their endpoints.
+func (g *PkgGraph) VerifyEdgeConstraints() (violations []EdgeViolation) {
+	for _, edge := range g.constrainedEdges() {
+		fromNode := edge.From().(*PkgNode).This
+		toNode := edge.To().(*PkgNode).This
+		constraint := g.EdgeConstraint(fromNode, toNode)
+		if !nodeSatisfiesConstraint(toNode, constraint) {
+			logger.Log.Warnf("Edge '%s' -> '%s' does not satisfy requirement '%s'", fromNode.FriendlyName(), toNode.FriendlyName(), constraint)
+			violations = append(violations, EdgeViolation{From: fromNode, To: toNode, Constraint: constraint})
+		}
+	}
+
+	return
+}
+
+// constrainedEdges returns the edges with a recorded version constraint, sorted by the IDs of their endpoints.
+// Constraints recorded for nodes which are no longer part of the graph are skipped.
+func (g *PkgGraph) constrainedEdges() (edges []graph.Edge) {
+	for id, constraint := range g.edgeConstraints {
+		from, to := g.Node(id.from), g.Node(id.to)
+		if from == nil || to == nil {
+			logger.Log.Warnf("Ignoring requirement '%s' recorded for missing edge %d -> %d", constraint, id.from, id.to)
+			continue
+		}
+		edges = append(edges, simple.Edge{F: from, T: to})
+	}
+	sortEdgesByID(edges)
+
+	return
+}
+
+// nodeSatisfiesConstraint returns true if the node provides a package matching the name and version interval
+// of the constraint.
+func nodeSatisfiesConstraint(node *PkgNode, constraint *pkgjson.PackageVer) bool {
+	if node.VersionedPkg == nil || node.VersionedPkg.Name != constraint.Name {
+		return false
+	}
+
+	requestInterval, err := constraint.Interval()
+	if err != nil {
+		return false
+	}
+	nodeInterval, err := node.VersionedPkg.Interval()
+	if err != nil {
+		return false
+	}
+
+	return nodeInterval.Satisfies(&requestInterval)
+}
+
+// SpecsWithoutRunNodes returns the sorted SRPM paths which have build nodes but no run nodes. Such SRPMs
+// produce nothing usable, usually because spec parsing dropped their provides.
+// The graph is scanned directly and the lookup table is not initialized, so build nodes which
+// initLookup would otherwise discard as orphans are still reported.
+func (g *PkgGraph) SpecsWithoutRunNodes() (srpmPaths []string) {
+	hasRunNode := make(map[string]bool)
+	hasBuildNode := make(map[string]bool)
+
+	for _, n := range graph.NodesOf(g.Nodes()) {
+		pkgNode := n.(*PkgNode)
+		switch pkgNode.Type {
+		case TypeRun:
+			hasRunNode[pkgNode.SrpmPath] = true
+		case TypeBuild:
+			hasBuildNode[pkgNode.SrpmPath] = true
+		}
+	}
+
+	for srpmPath := range hasBuildNode {
+		if !hasRunNode[srpmPath] {
+			srpmPaths = append(srpmPaths, srpmPath)
+		}
+	}
+	sort.Strings(srpmPaths)
+
+	return
+}
+
+// PrebuiltNodesWithDependencies returns the prebuilt nodes which still depend on nodes that are not yet available
+// (any state other than StateUpToDate or StateCached), sorted by ID. A prebuilt node represents an artifact which
+// already exists, so such edges are stale and would make the scheduler wait on inputs the node does not need.
+func (g *PkgGraph) PrebuiltNodesWithDependencies() (nodes []*PkgNode) {
+	for _, n := range g.AllNodes() {
+		if n.Type != TypePreBuilt {
+			continue
+		}
+
+		dependencies := g.From(n.ID())
+		for dependencies.Next() {
+			dependency := dependencies.Node().(*PkgNode).This
+			if dependency.State != StateUpToDate && dependency.State != StateCached {
+				logger.Log.Warnf("Prebuilt node '%s' depends on unavailable node '%s'", n.FriendlyName(), dependency.FriendlyName())
+				nodes = append(nodes, n)
+				break
+			}
+		}
+	}
+
+	sortNodesByID(nodes)
+
+	return
+}
+
+// CrossArchEdges returns the edges whose endpoints have different architectures, sorted by the IDs of their
+// endpoints. Each edge is returned as a [from, to] pair.
+// Nodes without an architecture, "noarch" nodes (which are usable from any architecture), and remote nodes
+// (which have no local architecture) are never reported.
+func (g *PkgGraph) CrossArchEdges() (crossArchEdges [][2]*PkgNode) {
+	edges := graph.EdgesOf(g.Edges())
+	sortEdgesByID(edges)
+	for _, edge := range edges {
+		from := edge.From().(*PkgNode).This
+		to := edge.To().(*PkgNode).This
+		if !isArchSpecific(from) || !isArchSpecific(to) {
+			continue
+		}
+
+		if from.Architecture != to.Architecture {
+			crossArchEdges = append(crossArchEdges, [2]*PkgNode{from, to})
+		}
+	}
+
+	return
+}
+
+// isArchSpecific returns true if the node is a local node built for a single architecture.
+func isArchSpecific(n *PkgNode) bool {
+	return n.Type != TypeRemote && n.Architecture != "" && n.Architecture != noArch
+}
+
+// IsolatedNodes returns the run and build nodes which have neither dependencies nor dependents, sorted by ID.
+// Such nodes are never built or used and usually mean a package was added to the graph but never wired up.
+func (g *PkgGraph) IsolatedNodes() (isolated []*PkgNode) {
+	for _, n := range g.AllNodes() {
+		if n.Type != TypeRun && n.Type != TypeBuild {
+			continue
+		}
+		if g.From(n.ID()).Len() == 0 && g.To(n.ID()).Len() == 0 {
+			isolated = append(isolated, n)
+		}
+	}
+	sortNodesByID(isolated)
+
+	return
+}
+
+// UnsatisfiableReport describes a versioned requirement which no node in the graph can satisfy.
+type UnsatisfiableReport struct {
+	From       *PkgNode              // The node holding the requirement
+	To         *PkgNode              // The node the edge currently points at
+	Constraint *pkgjson.PackageVer   // The requirement recorded for the edge
+	Available  []*pkgjson.PackageVer // Every version of the package in the lookup table, lowest first
+}
+
+// UnsatisfiableConstraints checks every edge with a recorded version constraint (see SetEdgeConstraint, the grapher
+// records one for each versioned requirement) and reports the constraints which no node in the lookup table
+// satisfies, sorted by the IDs of the edge endpoints.
+// A report with versions in Available means the constraint falls into a gap between them (ie only versions 1
+// and 3 exist but exactly 2 is required).
+func (g *PkgGraph) UnsatisfiableConstraints() (reports []UnsatisfiableReport) {
+	// Initialize the lookup table first, it may drop orphaned build nodes along with their constraints
+	g.lookupTable()
+
+	for _, edge := range g.constrainedEdges() {
+		from, to := edge.From().(*PkgNode).This, edge.To().(*PkgNode).This
+		constraint := g.EdgeConstraint(from, to)
+
+		lookupEntry, err := g.FindBestPkgNode(constraint)
+		if err == nil && lookupEntry != nil {
+			continue
+		}
+
+		report := UnsatisfiableReport{
+			From:       from,
+			To:         to,
+			Constraint: constraint,
+		}
+		for _, candidate := range g.lookupTable()[constraint.Name] {
+			report.Available = append(report.Available, candidate.RunNode.VersionedPkg)
+		}
+		logger.Log.Warnf("No node satisfies requirement '%s' of '%s' (%d versions available)", constraint, report.From.FriendlyName(), len(report.Available))
+		reports = append(reports, report)
+	}
+
+	return
+}
+
+// Validate checks the integrity of the graph and returns every problem found, without modifying the graph. Unlike
+// the lazy lookup initialization, which removes orphaned build nodes as a side effect, Validate only reports them.
+// The checks are:
+//   - run, build, remote, and 'PreBuilt' nodes must have package information
+//   - versions of run, build, and remote nodes must be valid, and local nodes can't use double conditionals
+//   - no two run (or remote) nodes, and no two build nodes, may share a package version
+//   - every build node must have a run node for the same package version
+//   - every edge must point between nodes of the graph, as must an already initialized lookup table
+//   - 'PreBuilt' nodes may only depend on nodes which are already available (see PrebuiltNodesWithDependencies)
+func (g *PkgGraph) Validate() (problems []error) {
+	type versionedNodes struct {
+		interval pkgjson.PackageVerInterval
+		runNodes []*PkgNode
+		builds   []*PkgNode
+	}
+	packages := make(map[string][]*versionedNodes)
+
+	nodes := g.AllNodes()
+	sortNodesByID(nodes)
+	for _, n := range nodes {
+		switch n.Type {
+		case TypeRun, TypeBuild, TypeRemote, TypePreBuilt:
+		default:
+			continue
+		}
+
+		if n.VersionedPkg == nil {
+			problems = append(problems, fmt.Errorf("%s node %d has no package information", n.Type, n.ID()))
+			continue
+		}
+		if n.Type == TypePreBuilt {
+			continue
+		}
+
+		err := validateLookupVersion(n)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+
+		interval, _ := n.VersionedPkg.Interval()
+		var entry *versionedNodes
+		for _, candidate := range packages[n.VersionedPkg.Name] {
+			if candidate.interval.Equal(&interval) {
+				entry = candidate
+				break
+			}
+		}
+		if entry == nil {
+			entry = &versionedNodes{interval: interval}
+			packages[n.VersionedPkg.Name] = append(packages[n.VersionedPkg.Name], entry)
+		}
+
+		if n.Type == TypeBuild {
+			entry.builds = append(entry.builds, n)
+		} else {
+			entry.runNodes = append(entry.runNodes, n)
+		}
+	}
+
+	pkgNames := make([]string, 0, len(packages))
+	for pkgName := range packages {
+		pkgNames = append(pkgNames, pkgName)
+	}
+	sort.Strings(pkgNames)
+	for _, pkgName := range pkgNames {
+		for _, entry := range packages[pkgName] {
+			if len(entry.runNodes) > 1 {
+				problems = append(problems, fmt.Errorf("%d run nodes share the lookup entry for %s", len(entry.runNodes), entry.runNodes[0]))
+			}
+			if len(entry.builds) > 1 {
+				problems = append(problems, fmt.Errorf("%d build nodes share the lookup entry for %s", len(entry.builds), entry.builds[0]))
+			}
+			if len(entry.runNodes) == 0 {
+				for _, buildNode := range entry.builds {
+					problems = append(problems, fmt.Errorf("build node %s has no run node", buildNode))
+				}
+			}
+		}
+	}
+
+	edges := graph.EdgesOf(g.Edges())
+	sortEdgesByID(edges)
+	for _, edge := range edges {
+		for _, endpoint := range []graph.Node{edge.From(), edge.To()} {
+			if _, isPkgNode := endpoint.(*PkgNode); !isPkgNode || g.Node(endpoint.ID()) == nil {
+				problems = append(problems, fmt.Errorf("edge %d -> %d points at node %d which is not a package node of the graph", edge.From().ID(), edge.To().ID(), endpoint.ID()))
+			}
+		}
+	}
+
+	for _, n := range g.PrebuiltNodesWithDependencies() {
+		problems = append(problems, fmt.Errorf("prebuilt node %s depends on nodes which are not available yet", n))
+	}
+
+	// Don't initialize the lookup table here, that would repair the graph
+	lookupNames := make([]string, 0, len(g.nodeLookup))
+	for pkgName := range g.nodeLookup {
+		lookupNames = append(lookupNames, pkgName)
+	}
+	sort.Strings(lookupNames)
+	for _, pkgName := range lookupNames {
+		for _, lookupEntry := range g.nodeLookup[pkgName] {
+			err := g.checkLookupEntryInGraph(lookupEntry)
+			if err != nil {
+				problems = append(problems, err)
+			}
+		}
+	}
+
+	return
+}
+
+// FindConflictingRPMs returns the RPM paths claimed by local run nodes from more than one SRPM, along with every
+// run node claiming them, sorted by ID. Run nodes without an RPM path (empty or "<NO_RPM_PATH>") are skipped.
+func (g *PkgGraph) FindConflictingRPMs() (conflicts map[string][]*PkgNode) {
+	conflicts = make(map[string][]*PkgNode)
+
+	rpmNodes := make(map[string][]*PkgNode)
+	for _, n := range g.AllRunNodes() {
+		if n.Type != TypeRun || n.RpmPath == "" || n.RpmPath == "<NO_RPM_PATH>" {
+			continue
+		}
+		rpmNodes[n.RpmPath] = append(rpmNodes[n.RpmPath], n)
+	}
+
+	for rpmPath, nodes := range rpmNodes {
+		srpmPaths := make(map[string]bool)
+		for _, n := range nodes {
+			srpmPaths[n.SrpmPath] = true
+		}
+		if len(srpmPaths) < 2 {
+			continue
+		}
+
+		logger.Log.Warnf("RPM '%s' is produced by %d different SRPMs", rpmPath, len(srpmPaths))
+		sortNodesByID(nodes)
+		conflicts[rpmPath] = nodes
+	}
+
+	return
+}
+
+// FindOverlappingVersions returns, for each package name, the groups of local run nodes whose version intervals
+// overlap, which should never happen in a well formed lookup table. Nodes are grouped transitively: if a overlaps b
+// and b overlaps c, all three form one group even if a and c don't overlap. Remote nodes describe requirements
+// rather than provided versions and are expected to overlap, so they are skipped. Each group is sorted by ID and the
+// groups are ordered by their first node's ID.
+func (g *PkgGraph) FindOverlappingVersions() (overlaps map[string][][]*PkgNode) {
+	overlaps = make(map[string][][]*PkgNode)
+
+	for pkgName, lookupBucket := range g.lookupTable() {
+		var (
+			entries   []*LookupNode
+			intervals []pkgjson.PackageVerInterval
+		)
+		for _, entry := range lookupBucket {
+			if entry.RunNode == nil || entry.RunNode.Type != TypeRun {
+				continue
+			}
+			interval, err := entry.RunInterval()
+			if err != nil {
+				logger.Log.Warnf("Skipping %s while checking for overlapping versions: %s", entry.RunNode, err)
+				continue
+			}
+			entries = append(entries, entry)
+			intervals = append(intervals, interval)
+		}
+
+		// Merge overlapping entries into groups, group[i] is the index of the group entry i belongs to
+		group := make([]int, len(entries))
+		for i := range group {
+			group[i] = i
+		}
+		for i := range entries {
+			for j := i + 1; j < len(entries); j++ {
+				if group[i] == group[j] || !(intervals[i].Satisfies(&intervals[j]) || intervals[j].Satisfies(&intervals[i])) {
+					continue
+				}
+				oldGroup := group[j]
+				for k := range group {
+					if group[k] == oldGroup {
+						group[k] = group[i]
+					}
+				}
+			}
+		}
+
+		members := make(map[int][]*PkgNode)
+		for i, entry := range entries {
+			members[group[i]] = append(members[group[i]], entry.RunNode)
+		}
+		var groups [][]*PkgNode
+		for _, nodes := range members {
+			if len(nodes) < 2 {
+				continue
+			}
+			sortNodesByID(nodes)
+			groups = append(groups, nodes)
+		}
+		if len(groups) == 0 {
+			continue
+		}
+
+		sort.Slice(groups, func(i, j int) bool {
+			return groups[i][0].ID() < groups[j][0].ID()
+		})
+		logger.Log.Warnf("Found %d group(s) of overlapping versions for '%s'", len(groups), pkgName)
+		overlaps[pkgName] = groups
+	}
+
+	return
+}
@@ -0,0 +1,370 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/CBL-Mariner/toolkit/tools/internal/pkgjson"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Make sure only edges whose target no longer satisfies the recorded constraint are reported
+func TestVerifyEdgeConstraints(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	a, err := g.FindExactPkgNodeFromPkg(&pkgA)
+	assert.NoError(t, err)
+	b, err := g.FindExactPkgNodeFromPkg(&pkgB)
+	assert.NoError(t, err)
+	c, err := g.FindExactPkgNodeFromPkg(&pkgC)
+	assert.NoError(t, err)
+
+	assert.Empty(t, g.VerifyEdgeConstraints())
+
+	err = g.SetEdgeConstraint(b.BuildNode, c.RunNode, &pkgjson.PackageVer{Name: "C", Version: "3", Condition: ">="})
+	assert.NoError(t, err)
+	err = g.SetEdgeConstraint(a.BuildNode, b.RunNode, &pkgjson.PackageVer{Name: "B", Version: "3", Condition: ">="})
+	assert.NoError(t, err)
+
+	violations := g.VerifyEdgeConstraints()
+	assert.Equal(t, 1, len(violations))
+	assert.Equal(t, a.BuildNode, violations[0].From)
+	assert.Equal(t, b.RunNode, violations[0].To)
+
+	// Removing the edge drops its constraint, it doesn't come back with a new edge
+	g.RemoveEdge(a.BuildNode.ID(), b.RunNode.ID())
+	assert.Nil(t, g.EdgeConstraint(a.BuildNode, b.RunNode))
+	assert.Empty(t, g.VerifyEdgeConstraints())
+	assert.NoError(t, g.AddEdge(a.BuildNode, b.RunNode))
+	assert.Nil(t, g.EdgeConstraint(a.BuildNode, b.RunNode))
+}
+
+// Make sure a node reusing the ID of a removed node doesn't inherit the constraints of its edges
+func TestEdgeConstraintRemovedWithNode(t *testing.T) {
+	g := NewPkgGraph()
+	pkgE := &pkgjson.PackageVer{Name: "E", Version: "1"}
+	pkgF := &pkgjson.PackageVer{Name: "F", Version: "1"}
+	pkgG := &pkgjson.PackageVer{Name: "G", Version: "1"}
+	eRun, err := addNodeToGraphHelper(g, buildUnresolvedNodeHelper(pkgE))
+	assert.NoError(t, err)
+	fRun, err := addNodeToGraphHelper(g, buildUnresolvedNodeHelper(pkgF))
+	assert.NoError(t, err)
+	assert.NoError(t, g.AddEdge(eRun, fRun))
+	assert.NoError(t, g.SetEdgeConstraint(eRun, fRun, &pkgjson.PackageVer{Name: "F", Condition: ">=", Version: "9"}))
+
+	g.RemovePkgNode(fRun)
+	gRun, err := addNodeToGraphHelper(g, buildUnresolvedNodeHelper(pkgG))
+	assert.NoError(t, err)
+	assert.NoError(t, g.AddEdge(eRun, gRun))
+	assert.Nil(t, g.EdgeConstraint(eRun, gRun))
+	assert.Empty(t, g.VerifyEdgeConstraints())
+}
+
+// Make sure pruning an orphaned build node while initializing the lookup table drops the data of its edges
+func TestEdgeConstraintRemovedWithOrphanBuildNode(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	bBuild := findTestNode(t, g, pkgBBuild)
+	cRun := findTestNode(t, g, pkgCRun)
+	assert.NoError(t, g.SetEdgeConstraint(bBuild, cRun, &pkgjson.PackageVer{Name: "C", Condition: ">=", Version: "9"}))
+	assert.NoError(t, g.SetEdgeWeight(bBuild, cRun, 5))
+
+	// Leave B's build node without its run node, the lookup table is not initialized yet
+	nodes := []*PkgNode{}
+	for _, n := range g.AllNodes() {
+		if n.ID() != findTestNode(t, g, pkgBRun).ID() {
+			nodes = append(nodes, n)
+		}
+	}
+	subGraph := g.copySubGraphPreservingIDs(nodes)
+	assert.Nil(t, subGraph.nodeLookup)
+	assert.Equal(t, 1, len(subGraph.edgeConstraints))
+
+	subGraph.lookupTable()
+	assert.Nil(t, subGraph.Node(bBuild.ID()))
+	assert.Empty(t, subGraph.edgeConstraints)
+	assert.Empty(t, subGraph.edgeWeights)
+	assert.Empty(t, subGraph.VerifyEdgeConstraints())
+	assert.Empty(t, subGraph.UnsatisfiableConstraints())
+}
+
+// Make sure constraints survive a DOT round trip
+func TestEdgeConstraintDOT(t *testing.T) {
+	gOut, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	constraint := &pkgjson.PackageVer{Name: "C", Condition: ">=", Version: "3"}
+	assert.NoError(t, gOut.SetEdgeConstraint(findTestNode(t, gOut, pkgBBuild), findTestNode(t, gOut, pkgCRun), constraint))
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteDOTGraph(gOut, &buf))
+	gIn := NewPkgGraph()
+	assert.NoError(t, ReadDOTGraph(gIn, &buf))
+
+	assert.Equal(t, constraint, gIn.EdgeConstraint(findTestNode(t, gIn, pkgBBuild), findTestNode(t, gIn, pkgCRun)))
+	assert.Nil(t, gIn.EdgeConstraint(findTestNode(t, gIn, pkgABuild), findTestNode(t, gIn, pkgBRun)))
+
+	deepCopy, err := gOut.DeepCopy()
+	assert.NoError(t, err)
+	assert.Equal(t, constraint, deepCopy.EdgeConstraint(findTestNode(t, deepCopy, pkgBBuild), findTestNode(t, deepCopy, pkgCRun)))
+}
+
+func TestSetEdgeConstraintMissingEdge(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	a, err := g.FindExactPkgNodeFromPkg(&pkgA)
+	assert.NoError(t, err)
+	c, err := g.FindExactPkgNodeFromPkg(&pkgC)
+	assert.NoError(t, err)
+
+	err = g.SetEdgeConstraint(a.RunNode, c.RunNode, &pkgC)
+	assert.Error(t, err)
+}
+
+// Make sure SRPMs with build nodes but no run nodes are reported without modifying the graph
+func TestSpecsWithoutRunNodes(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.Empty(t, g.SpecsWithoutRunNodes())
+
+	// Add an orphaned build node directly, bypassing the lookup table
+	orphan := buildBuildNodeHelper(&pkgjson.PackageVer{Name: "orphan", Version: "1"})
+	orphan.nodeID = g.NewNode().ID()
+	g.AddNode(orphan)
+
+	assert.Equal(t, []string{"orphan.src.rpm"}, g.SpecsWithoutRunNodes())
+	assert.Equal(t, len(allNodes)+1, g.Nodes().Len())
+}
+
+// Make sure only prebuilt nodes depending on unavailable nodes are reported
+func TestPrebuiltNodesWithDependencies(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.Empty(t, g.PrebuiltNodesWithDependencies())
+
+	b, err := g.FindExactPkgNodeFromPkg(&pkgB)
+	assert.NoError(t, err)
+	c, err := g.FindExactPkgNodeFromPkg(&pkgC)
+	assert.NoError(t, err)
+
+	prebuiltB := g.CloneNode(b.RunNode)
+	prebuiltB.Type = TypePreBuilt
+	prebuiltB.State = StateUpToDate
+	assert.NoError(t, g.AddEdge(prebuiltB, c.RunNode))
+
+	prebuiltC := g.CloneNode(c.RunNode)
+	prebuiltC.Type = TypePreBuilt
+	prebuiltC.State = StateUpToDate
+
+	// C run is only built once C build is done
+	assert.Equal(t, []*PkgNode{prebuiltB}, g.PrebuiltNodesWithDependencies())
+
+	// Once the dependency is available the edge no longer matters
+	c.RunNode.State = StateUpToDate
+	assert.Empty(t, g.PrebuiltNodesWithDependencies())
+}
+
+// Make sure only edges between two different, concrete architectures are reported
+func TestCrossArchEdges(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.Empty(t, g.CrossArchEdges())
+
+	aBuild := findTestNode(t, g, pkgABuild)
+	bRun := findTestNode(t, g, pkgBRun)
+	cBuild := findTestNode(t, g, pkgCBuild)
+	d3 := findTestNode(t, g, pkgD3Unresolved)
+
+	bRun.Architecture = "aarch64"
+	cBuild.Architecture = "noarch"
+	d3.Architecture = "aarch64"
+
+	// A build -> B run and B run -> B build cross architectures, C run -> C build and C run -> D3 do not
+	crossArch := g.CrossArchEdges()
+	expected := [][2]*PkgNode{{aBuild, bRun}, {bRun, findTestNode(t, g, pkgBBuild)}}
+	if expected[0][0].ID() > expected[1][0].ID() {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+	assert.Equal(t, expected, crossArch)
+}
+
+// Make sure a lookup entry for a node removed behind the lookup table's back is reported as stale
+func TestFindExactStaleLookup(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	c2, err := g.FindExactPkgNodeFromPkg(&pkgC2)
+	assert.NoError(t, err)
+	assert.NotNil(t, c2)
+
+	// Bypass RemovePkgNode so the lookup table still points at the removed node
+	g.RemoveNode(c2.BuildNode.ID())
+	lookupEntry, err := g.FindExactPkgNodeFromPkg(&pkgC2)
+	assert.Error(t, err)
+	assert.Nil(t, lookupEntry)
+
+	// Nodes removed through RemovePkgNode are simply not found
+	c, err := g.FindExactPkgNodeFromPkg(&pkgC)
+	assert.NoError(t, err)
+	g.RemovePkgNode(c.RunNode)
+	lookupEntry, err = g.FindExactPkgNodeFromPkg(&pkgC)
+	assert.NoError(t, err)
+	assert.Nil(t, lookupEntry)
+}
+
+// Make sure only unconnected run and build nodes are reported
+func TestIsolatedNodes(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.Empty(t, g.IsolatedNodes())
+
+	// Unconnected remote nodes are not reported
+	_, err = g.AddPkgNode(&pkgjson.PackageVer{Name: "remote", Version: "1"}, StateUnresolved, TypeRemote, "", "", "", "", "", "")
+	assert.NoError(t, err)
+
+	isolatedRun, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "E", Version: "1"}, StateMeta, TypeRun, "E.src.rpm", "E.rpm", "E.spec", "", "test_arch", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []*PkgNode{isolatedRun}, g.IsolatedNodes())
+}
+
+// Make sure constraints falling between the available versions are reported
+func TestUnsatisfiableConstraints(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	bBuild := findTestNode(t, g, pkgBBuild)
+	cRun := findTestNode(t, g, pkgCRun)
+	aBuild := findTestNode(t, g, pkgABuild)
+	bRun := findTestNode(t, g, pkgBRun)
+
+	// C 3-3 and 3-4 exist, nothing in between
+	gap := &pkgjson.PackageVer{Name: "C", Version: "3-3.5", Condition: "="}
+	assert.NoError(t, g.SetEdgeConstraint(bBuild, cRun, gap))
+	assert.NoError(t, g.SetEdgeConstraint(aBuild, bRun, &pkgB))
+
+	reports := g.UnsatisfiableConstraints()
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, bBuild, reports[0].From)
+	assert.Equal(t, cRun, reports[0].To)
+	assert.Equal(t, gap, reports[0].Constraint)
+	assert.Equal(t, []*pkgjson.PackageVer{cRun.VersionedPkg, findTestNode(t, g, pkgC2Run).VersionedPkg}, reports[0].Available)
+
+	// Constraints are stored with the graph, so they are still checked once it is read back
+	var buf bytes.Buffer
+	assert.NoError(t, WriteDOTGraph(g, &buf))
+	gIn := NewPkgGraph()
+	assert.NoError(t, ReadDOTGraph(gIn, &buf))
+	reports = gIn.UnsatisfiableConstraints()
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, findTestNode(t, gIn, pkgBBuild), reports[0].From)
+	assert.Equal(t, gap, reports[0].Constraint)
+
+	// The constraint goes away with its edge
+	g.RemoveEdge(bBuild.ID(), cRun.ID())
+	assert.Empty(t, g.UnsatisfiableConstraints())
+}
+
+func TestValidateTestGraph(t *testing.T) {
+	gIn := NewPkgGraph()
+	var buf bytes.Buffer
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.NoError(t, WriteDOTGraph(g, &buf))
+	assert.NoError(t, ReadDOTGraph(gIn, &buf))
+
+	assert.Empty(t, g.Validate())
+	assert.Empty(t, gIn.Validate())
+	// Validating must not initialize (and so repair) the lookup table
+	assert.Nil(t, gIn.nodeLookup)
+}
+
+func TestValidate(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Remove a node behind the lookup table's back
+	g.RemoveNode(findTestNode(t, g, pkgD1Unresolved).ID())
+
+	// Add malformed nodes directly to the underlying graph, the lookup table would refuse them
+	addRawNode := func(reference *PkgNode) *PkgNode {
+		n := copyNode(reference)
+		n.nodeID = g.NewNode().ID()
+		g.AddNode(n)
+		return n
+	}
+	addRawNode(pkgARun)
+	addRawNode(buildBuildNodeHelper(&pkgjson.PackageVer{Name: "E", Version: "1"}))
+	addRawNode(buildRunNodeHelper(&pkgjson.PackageVer{Name: "F", Version: "1", Condition: ">=", SVersion: "2", SCondition: "<"}))
+	noPkg := buildRunNodeHelper(&pkgA)
+	noPkg.VersionedPkg = nil
+	addRawNode(noPkg)
+	prebuilt := addRawNode(pkgBRun)
+	prebuilt.Type = TypePreBuilt
+	prebuilt.State = StateUpToDate
+	g.SetEdge(g.NewEdge(prebuilt, findTestNode(t, g, pkgCRun)))
+
+	nodeCount := len(g.AllNodes())
+	problems := g.Validate()
+	assert.Equal(t, nodeCount, len(g.AllNodes()))
+
+	messages := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		messages = append(messages, problem.Error())
+	}
+	allMessages := strings.Join(messages, "\n")
+	assert.Equal(t, 6, len(problems), allMessages)
+	assert.Contains(t, allMessages, "has no package information")
+	assert.Contains(t, allMessages, "can't have double conditionals")
+	assert.Contains(t, allMessages, "2 run nodes share the lookup entry")
+	assert.Contains(t, allMessages, "has no run node")
+	assert.Contains(t, allMessages, "is stale")
+	assert.Contains(t, allMessages, "depends on nodes which are not available yet")
+}
+
+func TestFindConflictingRPMs(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// C and C2 come from the same SRPM, so sharing an RPM path is not a conflict
+	findTestNode(t, g, pkgC2Run).RpmPath = "C.rpm"
+	assert.Empty(t, g.FindConflictingRPMs())
+
+	aRun := findTestNode(t, g, pkgARun)
+	bRun := findTestNode(t, g, pkgBRun)
+	bRun.RpmPath = aRun.RpmPath
+	expected := []*PkgNode{aRun, bRun}
+	sortNodesByID(expected)
+	assert.Equal(t, map[string][]*PkgNode{"A.rpm": expected}, g.FindConflictingRPMs())
+}
+
+func TestFindOverlappingVersions(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+
+	// Remote nodes D<1 and D<=2 overlap, but they are only requirements
+	assert.Empty(t, g.FindOverlappingVersions())
+
+	addRun := func(pkg *pkgjson.PackageVer) *PkgNode {
+		n, addErr := g.AddPkgNode(pkg, StateMeta, TypeRun, pkg.Name+".src.rpm", pkg.Name+".rpm", pkg.Name+".spec", "", "test_arch", "")
+		assert.NoError(t, addErr)
+		return n
+	}
+	e1 := addRun(&pkgjson.PackageVer{Name: "E", Version: "1"})
+	eAny := addRun(&pkgjson.PackageVer{Name: "E", Version: "1", Condition: ">="})
+	e2 := addRun(&pkgjson.PackageVer{Name: "E", Version: "2"})
+	addRun(&pkgjson.PackageVer{Name: "F", Version: "1"})
+	addRun(&pkgjson.PackageVer{Name: "F", Version: "2"})
+	cNewer := addRun(&pkgjson.PackageVer{Name: "C", Version: "3-4", Condition: ">="})
+
+	expected := map[string][][]*PkgNode{
+		"C": {{findTestNode(t, g, pkgC2Run), cNewer}},
+		"E": {{e1, eAny, e2}},
+	}
+	assert.Equal(t, expected, g.FindOverlappingVersions())
+}